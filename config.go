@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/metal-tile/land/dqn"
+	"github.com/sinmetal/gcpmetadata"
+)
+
+// envString is 環境変数の値を返す。設定されていない場合はdefを返す
+// Flagのデフォルト値として使い、Flagの指定を環境変数より優先させる
+func envString(key string, def string) string {
+	v, ok := os.LookupEnv(key)
+	if !ok || v == "" {
+		return def
+	}
+	return v
+}
+
+// envBool is 環境変数の値をboolとして返す。設定されていないか、不正な値の場合はfalseを返す
+func envBool(key string) bool {
+	v := envString(key, "")
+	if v == "" {
+		return false
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		fmt.Printf("%s is invalid bool. %s\n", key, err)
+		return false
+	}
+	return b
+}
+
+// resolveProjectID is GCPのProject IDを返す
+// localの場合はGCEのMetadata Serverが無いので、GOOGLE_CLOUD_PROJECT から取得する
+func resolveProjectID(local bool) (string, error) {
+	if !local {
+		return gcpmetadata.GetProjectID()
+	}
+	projectID := envString("GOOGLE_CLOUD_PROJECT", "")
+	if projectID == "" {
+		return "", errors.New("GOOGLE_CLOUD_PROJECT is required in local mode")
+	}
+	return projectID, nil
+}
+
+// envDuration is 環境変数の値をtime.Durationとして返す。設定されていないか、不正な値の場合はdefを返す
+func envDuration(key string, def time.Duration) time.Duration {
+	v := envString(key, "")
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		fmt.Printf("%s is invalid duration. %s\n", key, err)
+		return def
+	}
+	return d
+}
+
+// BuildDQNOptions is DQN Clientの設定を組み立てる
+// headersは `Key=Value,Key=Value` 形式で指定する
+// selectorは dqn.ParseSelector の形式で指定する。MonsterTypeにSelectorが無いMonsterはこの戦略で行動を選ぶ
+func BuildDQNOptions(endpoint string, timeout time.Duration, headers string, selector string) ([]dqn.Option, error) {
+	s, err := dqn.ParseSelector(selector)
+	if err != nil {
+		return nil, err
+	}
+	opts := []dqn.Option{
+		dqn.WithEndpoint(endpoint),
+		dqn.WithTimeout(timeout),
+		dqn.WithSelector(s),
+	}
+	if strings.TrimSpace(headers) == "" {
+		return opts, nil
+	}
+	for _, v := range strings.Split(headers, ",") {
+		kv := strings.SplitN(strings.TrimSpace(v), "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("dqn header has an unexpected format. v = %s", v)
+		}
+		opts = append(opts, dqn.WithHeader(kv[0], kv[1]))
+	}
+	return opts, nil
+}
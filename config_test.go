@@ -0,0 +1,111 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestEnvDuration(t *testing.T) {
+	const key = "LAND_TEST_DURATION"
+	defer os.Unsetenv(key)
+
+	candidates := []struct {
+		v        string
+		expected time.Duration
+	}{
+		{v: "", expected: time.Second},
+		{v: "200ms", expected: 200 * time.Millisecond},
+		{v: "hoge", expected: time.Second},
+	}
+
+	for i, v := range candidates {
+		os.Setenv(key, v.v)
+		if e, g := v.expected, envDuration(key, time.Second); e != g {
+			t.Fatalf("%d : expected %s; got %s", i, e, g)
+		}
+	}
+}
+
+func TestBuildDQNOptions(t *testing.T) {
+	candidates := []struct {
+		headers  string
+		selector string
+		len      int
+		err      bool
+	}{
+		{headers: "", len: 3},
+		{headers: "X-Land=home, Authorization=Bearer hoge", len: 5},
+		{headers: "", selector: "epsilon:0.1", len: 3},
+		{headers: "", selector: "softmax:0.5", len: 3},
+		{headers: "X-Land", err: true},
+		{headers: "=home", err: true},
+		{headers: "", selector: "epsilon", err: true},
+		{headers: "", selector: "random", err: true},
+	}
+
+	for i, v := range candidates {
+		opts, err := BuildDQNOptions("http://localhost:8081/dqn", time.Second, v.headers, v.selector)
+		if v.err {
+			if err == nil {
+				t.Fatalf("%d : expected error", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%d : failed BuildDQNOptions. err=%+v", i, err)
+		}
+		if e, g := v.len, len(opts); e != g {
+			t.Fatalf("%d : expected len(opts) is %d; got %d", i, e, g)
+		}
+	}
+}
+
+func TestResolveProjectID_Local(t *testing.T) {
+	const key = "GOOGLE_CLOUD_PROJECT"
+	org, ok := os.LookupEnv(key)
+	defer func() {
+		if ok {
+			os.Setenv(key, org)
+		} else {
+			os.Unsetenv(key)
+		}
+	}()
+
+	os.Setenv(key, "metal-tile-local")
+	projectID, err := resolveProjectID(true)
+	if err != nil {
+		t.Fatalf("failed resolveProjectID. err=%+v", err)
+	}
+	if e, g := "metal-tile-local", projectID; e != g {
+		t.Fatalf("expected projectID is %s; got %s", e, g)
+	}
+
+	os.Unsetenv(key)
+	if _, err := resolveProjectID(true); err == nil {
+		t.Fatalf("expected error without %s", key)
+	}
+}
+
+func TestEnvBool(t *testing.T) {
+	const key = "LAND_TEST_BOOL"
+	defer os.Unsetenv(key)
+
+	candidates := []struct {
+		v        string
+		expected bool
+	}{
+		{v: "", expected: false},
+		{v: "true", expected: true},
+		{v: "1", expected: true},
+		{v: "false", expected: false},
+		{v: "hoge", expected: false},
+	}
+
+	for i, v := range candidates {
+		os.Setenv(key, v.v)
+		if e, g := v.expected, envBool(key); e != g {
+			t.Fatalf("%d : expected %t; got %t", i, e, g)
+		}
+	}
+}
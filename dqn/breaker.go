@@ -0,0 +1,117 @@
+package dqn
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sinmetal/slog"
+	"github.com/sinmetal/stime"
+)
+
+// CircuitBreakerClient is Primaryが連続で失敗した時にFallbackに切り替えるClient
+// Primaryが失敗した時は、そのRequestもFallbackで応答する
+// Fallbackに切り替わっている間は、retryInterval毎に1度だけPrimaryを試し、成功したらPrimaryに戻す
+type CircuitBreakerClient struct {
+	primary       Client
+	fallback      Client
+	threshold     int
+	retryInterval time.Duration
+
+	mu       sync.Mutex
+	failures int
+	open     bool
+	openedAt time.Time
+}
+
+// NewCircuitBreakerClient is CircuitBreakerClientを生成する
+// thresholdはFallbackに切り替えるまでのPrimaryの連続失敗回数
+func NewCircuitBreakerClient(primary Client, fallback Client, threshold int, retryInterval time.Duration) *CircuitBreakerClient {
+	if threshold < 1 {
+		threshold = 1
+	}
+	return &CircuitBreakerClient{
+		primary:       primary,
+		fallback:      fallback,
+		threshold:     threshold,
+		retryInterval: retryInterval,
+	}
+}
+
+// IsOpen is Fallbackに切り替わっているかどうかを返す
+func (c *CircuitBreakerClient) IsOpen() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.open
+}
+
+// Prediction is 状態に応じてPrimaryかFallbackでPredictionを行う
+func (c *CircuitBreakerClient) Prediction(ctx context.Context, body *Payload) (*Answer, error) {
+	if c.usePrimary() {
+		ans, err := c.primary.Prediction(ctx, body)
+		if err == nil {
+			c.success(ctx)
+			return ans, nil
+		}
+		c.failure(ctx, err)
+	}
+	return c.fallback.Prediction(ctx, body)
+}
+
+// BatchPrediction is 状態に応じてPrimaryかFallbackでBatchPredictionを行う
+func (c *CircuitBreakerClient) BatchPrediction(ctx context.Context, body *Payload) (map[int]*Answer, error) {
+	if c.usePrimary() {
+		answers, err := c.primary.BatchPrediction(ctx, body)
+		if err == nil {
+			c.success(ctx)
+			return answers, nil
+		}
+		c.failure(ctx, err)
+	}
+	return c.fallback.BatchPrediction(ctx, body)
+}
+
+// usePrimary is Primaryを使うかどうかを返す
+// Fallbackに切り替わっている場合は、retryIntervalが経過していればPrimaryを1度だけ試す
+func (c *CircuitBreakerClient) usePrimary() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.open {
+		return true
+	}
+	now := stime.Now()
+	if stime.InTime(now, c.openedAt, c.retryInterval) {
+		return false
+	}
+	// 他のRequestが同時にPrimaryを試さないように、次に試す時間を進めておく
+	c.openedAt = now
+	return true
+}
+
+func (c *CircuitBreakerClient) success(ctx context.Context) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.open {
+		slog.Info(ctx, "DQNCircuitBreakerClose", "primary is recovered.")
+	}
+	c.failures = 0
+	c.open = false
+}
+
+func (c *CircuitBreakerClient) failure(ctx context.Context, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.failures++
+	if !c.open && c.failures >= c.threshold {
+		slog.Warning(ctx, "DQNCircuitBreakerOpen", fmt.Sprintf("primary failed %d times. switch to fallback. %+v", c.failures, err))
+		c.open = true
+	}
+	if c.open {
+		c.openedAt = stime.Now()
+	}
+}
@@ -0,0 +1,91 @@
+package dqn
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sinmetal/slog"
+	"github.com/sinmetal/stime"
+)
+
+// switchableClient is UnitTestのために、成功と失敗を切り替えられるClient
+type switchableClient struct {
+	Fail            bool
+	PredictionCount int
+}
+
+func (c *switchableClient) Prediction(ctx context.Context, body *Payload) (*Answer, error) {
+	c.PredictionCount++
+	if c.Fail {
+		return nil, ErrDQNAPIResponse
+	}
+	return newAnswer(actionRight), nil
+}
+
+func (c *switchableClient) BatchPrediction(ctx context.Context, body *Payload) (map[int]*Answer, error) {
+	c.PredictionCount++
+	if c.Fail {
+		return nil, ErrDQNAPIResponse
+	}
+	return map[int]*Answer{0: newAnswer(actionRight)}, nil
+}
+
+func TestCircuitBreakerClient(t *testing.T) {
+	now := time.Date(2018, 5, 1, 0, 0, 0, 0, time.UTC)
+	stime.SetPermafrost(now)
+	defer stime.SetPermafrost(time.Time{})
+
+	primary := &switchableClient{Fail: true}
+	c := NewCircuitBreakerClient(primary, NewIdlePolicy(), 3, 10*time.Second)
+
+	ctx := slog.WithLog(context.Background())
+	body := &Payload{Instances: []Instance{Instance{}}}
+	for i := 0; i < 3; i++ {
+		// 失敗した時はFallbackで応答する
+		a, err := c.Prediction(ctx, body)
+		if err != nil {
+			t.Fatalf("%d : failed Prediction. err = %+v", i, err)
+		}
+		if a.IsMove {
+			t.Fatalf("%d : expected fallback answer; got %+v", i, a)
+		}
+	}
+	if !c.IsOpen() {
+		t.Fatalf("expected open after 3 failures")
+	}
+
+	// Fallbackに切り替わっている間はPrimaryを呼ばない
+	if _, err := c.BatchPrediction(ctx, body); err != nil {
+		t.Fatalf("failed BatchPrediction. err = %+v", err)
+	}
+	if e, g := 3, primary.PredictionCount; e != g {
+		t.Fatalf("expected primary PredictionCount is %d; got %d", e, g)
+	}
+
+	// retryIntervalが経過したが、Primaryはまだ失敗する
+	stime.SetPermafrost(now.Add(11 * time.Second))
+	if _, err := c.Prediction(ctx, body); err != nil {
+		t.Fatalf("failed Prediction. err = %+v", err)
+	}
+	if e, g := 4, primary.PredictionCount; e != g {
+		t.Fatalf("expected primary PredictionCount is %d; got %d", e, g)
+	}
+	if !c.IsOpen() {
+		t.Fatalf("expected open")
+	}
+
+	// Primaryが復帰した
+	primary.Fail = false
+	stime.SetPermafrost(now.Add(22 * time.Second))
+	a, err := c.Prediction(ctx, body)
+	if err != nil {
+		t.Fatalf("failed Prediction. err = %+v", err)
+	}
+	if e, g := AngleRight, a.Angle; e != g {
+		t.Fatalf("expected primary answer Angle is %f; got %f", e, g)
+	}
+	if c.IsOpen() {
+		t.Fatalf("expected close after primary recovered")
+	}
+}
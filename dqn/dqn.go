@@ -1,13 +1,14 @@
 package dqn
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"math"
 	"net/http"
-	"strings"
 
 	"github.com/sinmetal/slog"
 	"go.opencensus.io/trace"
@@ -34,6 +35,8 @@ const (
 	MonsterLayer = 0
 	// PlayerLayer is DQN PayloadのPlayerの座標の添字
 	PlayerLayer = 1
+	// ObstacleLayer is DQN Payloadの障害物の座標の添字
+	ObstacleLayer = 2
 )
 
 // ErrDQNAPIResponse is DQN ServerからのError時に利用する
@@ -51,6 +54,9 @@ type Payload struct {
 type Instance struct {
 	State [SenseRangeRow][SenseRangeCol][3]float64 `json:"state"`
 	Key   int                                      `json:"key"`
+
+	// Selector is このInstanceのQ Scoreから行動を選ぶ戦略。nilの場合はClientの設定を使う
+	Selector Selector `json:"-"`
 }
 
 // apiResponse is DQN APIからのResponseの型
@@ -75,21 +81,32 @@ type Answer struct {
 }
 
 // Client is DQN APIを実行するClient
+// BatchPrediction は複数のInstanceを1回のRequestで判断し、Instance.KeyごとのAnswerを返す
 type Client interface {
 	Prediction(ctx context.Context, body *Payload) (*Answer, error)
+	BatchPrediction(ctx context.Context, body *Payload) (map[int]*Answer, error)
 }
 
 var client Client
 
 // dqnImpl is DQN APIのためのデフォルト実装
-type dqnImpl struct{}
+type dqnImpl struct {
+	config
+}
 
 // NewClient is Clientを返す
-func NewClient() Client {
+// SetDummyClient で実装が差し替えられている場合は、Optionは無視される
+func NewClient(opts ...Option) Client {
 	if client != nil {
 		return client
 	}
-	return &dqnImpl{}
+	d := &dqnImpl{
+		config: newConfig(opts...),
+	}
+	if d.httpClient == nil {
+		d.httpClient = newDefaultHTTPClient()
+	}
+	return d
 }
 
 // SetDummyClient is UnitTestのために実装を差し替えるためのもの
@@ -102,27 +119,63 @@ func (d *dqnImpl) Prediction(ctx context.Context, body *Payload) (*Answer, error
 	ctx, span := trace.StartSpan(ctx, "/dqn")
 	defer span.End()
 
+	dqnRes, err := d.request(ctx, body)
+	if err != nil {
+		return nil, err
+	}
+	return buildDQNAnswer(body, dqnRes, d.selector)
+}
+
+// BatchPrediction is 複数のInstanceをまとめてDQN APIに送る実装
+func (d *dqnImpl) BatchPrediction(ctx context.Context, body *Payload) (map[int]*Answer, error) {
+	ctx, span := trace.StartSpan(ctx, "/dqn/batch")
+	defer span.End()
+
+	dqnRes, err := d.request(ctx, body)
+	if err != nil {
+		return nil, err
+	}
+	answers, err := buildDQNBatchAnswer(body, dqnRes, d.selector)
+	if err != nil {
+		slog.Info(ctx, "FailedDQNBatchAnswer", fmt.Sprintf("err = %s, instances = %d, predictions = %d", err.Error(), len(body.Instances), len(dqnRes.Predictions)))
+		return nil, err
+	}
+	return answers, nil
+}
+
+func (d *dqnImpl) request(ctx context.Context, body *Payload) (*apiResponse, error) {
 	b, err := json.Marshal(body)
 	if err != nil {
 		slog.Info(ctx, "FailedDQNPrediction", err.Error())
 		return nil, err
 	}
 
-	client := new(http.Client)
+	if d.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.timeout)
+		defer cancel()
+	}
+
 	req, err := http.NewRequest(
 		"POST",
-		"http://dqn-service.default.svc.cluster.local:8081/dqn",
-		strings.NewReader(string(b)),
+		d.endpoint,
+		bytes.NewReader(b),
 	)
 	if err != nil {
 		slog.Info(ctx, "FailedDQNPredictionRequest", err.Error())
 		return nil, err
 	}
-	res, err := client.Do(req)
+	req = req.WithContext(ctx)
+	for k, v := range d.header {
+		req.Header[k] = v
+	}
+
+	res, err := d.httpClient.Do(req)
 	if err != nil {
 		slog.Info(ctx, "FailedDQNClientDo", err.Error())
 		return nil, err
 	}
+	defer res.Body.Close()
 
 	resBody, err := ioutil.ReadAll(res.Body)
 	if err != nil {
@@ -142,69 +195,75 @@ func (d *dqnImpl) Prediction(ctx context.Context, body *Payload) (*Answer, error
 		return nil, err
 	}
 
-	return buildDQNAnswer(&dqnRes)
+	return &dqnRes, nil
+}
+
+// buildDQNBatchAnswer is PredictionをKeyでInstanceと突き合わせて、KeyごとのAnswerを組み立てる
+// 送ったInstanceのKeyに対応するPredictionが揃っていない場合や、
+// 同じKeyのPredictionが複数ある、送っていないKeyのPredictionがある場合は ErrDQNAPIResponse を返す
+func buildDQNBatchAnswer(body *Payload, res *apiResponse, selector Selector) (map[int]*Answer, error) {
+	sent := make(map[int]bool, len(body.Instances))
+	selectors := make(map[int]Selector, len(body.Instances))
+	for _, v := range body.Instances {
+		sent[v.Key] = true
+		if v.Selector != nil {
+			selectors[v.Key] = v.Selector
+		}
+	}
+
+	answers := make(map[int]*Answer, len(res.Predictions))
+	for _, p := range res.Predictions {
+		if !sent[p.Key] {
+			return nil, ErrDQNAPIResponse
+		}
+		if _, ok := answers[p.Key]; ok {
+			return nil, ErrDQNAPIResponse
+		}
+		s, ok := selectors[p.Key]
+		if !ok {
+			s = selector
+		}
+		ans, err := buildPredictionAnswer(&p, s)
+		if err != nil {
+			return nil, err
+		}
+		answers[p.Key] = ans
+	}
+	for _, v := range body.Instances {
+		if _, ok := answers[v.Key]; !ok {
+			return nil, ErrDQNAPIResponse
+		}
+	}
+	return answers, nil
 }
 
-func buildDQNAnswer(res *apiResponse) (*Answer, error) {
-	score := &struct {
-		None  float64
-		Left  float64
-		Right float64
-		Up    float64
-		Down  float64
-	}{
-		None:  res.Predictions[0].Q[0],
-		Left:  res.Predictions[0].Q[1],
-		Right: res.Predictions[0].Q[2],
-		Up:    res.Predictions[0].Q[3],
-		Down:  res.Predictions[0].Q[4],
-	}
-
-	if score.None > score.Left && score.None > score.Right && score.None > score.Up && score.None > score.Down {
-		// 何もしない
-		return &Answer{
-			X:      0,
-			Y:      0,
-			IsMove: false,
-			Angle:  AngleDown,
-			Speed:  0,
-		}, nil
-	} else if score.Left > score.None && score.Left > score.Right && score.Left > score.Up && score.Left > score.Down {
-		// 左
-		return &Answer{
-			X:      -1,
-			Y:      0,
-			IsMove: true,
-			Angle:  AngleLeft,
-			Speed:  speed,
-		}, nil
-	} else if score.Right > score.None && score.Right > score.Left && score.Right > score.Up && score.Right > score.Down {
-		// 右
-		return &Answer{
-			X:      1,
-			Y:      0,
-			IsMove: true,
-			Angle:  AngleRight,
-			Speed:  speed,
-		}, nil
-	} else if score.Up > score.None && score.Up > score.Left && score.Up > score.Right && score.Up > score.Down {
-		// 上
-		return &Answer{
-			X:      0,
-			Y:      -1,
-			IsMove: true,
-			Angle:  AngleUp,
-			Speed:  speed,
-		}, nil
-	} else {
-		// 下
-		return &Answer{
-			X:      0,
-			Y:      1,
-			IsMove: true,
-			Angle:  AngleDown,
-			Speed:  speed,
-		}, nil
+// buildDQNAnswer is 先頭のPredictionからAnswerを組み立てる
+// 先頭のInstanceにSelectorが指定されている場合は、selectorの代わりにそれを使う
+// Predictionが空の場合は ErrDQNAPIResponse を返す
+func buildDQNAnswer(body *Payload, res *apiResponse, selector Selector) (*Answer, error) {
+	if len(res.Predictions) < 1 {
+		return nil, ErrDQNAPIResponse
 	}
+	if len(body.Instances) > 0 && body.Instances[0].Selector != nil {
+		selector = body.Instances[0].Selector
+	}
+	return buildPredictionAnswer(&res.Predictions[0], selector)
+}
 
+// buildPredictionAnswer is Q Scoreから行動を選んでAnswerを組み立てる
+// Q Scoreが5つ揃っていない、もしくは数値ではない値が含まれている場合は ErrDQNAPIResponse を返す
+func buildPredictionAnswer(p *predictions, selector Selector) (*Answer, error) {
+	if len(p.Q) < actionCount {
+		return nil, ErrDQNAPIResponse
+	}
+	q := p.Q[:actionCount]
+	for _, v := range q {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil, ErrDQNAPIResponse
+		}
+	}
+	if selector == nil {
+		selector = &ArgmaxSelector{}
+	}
+	return newAnswer(selector.Select(q)), nil
 }
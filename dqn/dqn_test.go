@@ -2,6 +2,7 @@ package dqn
 
 import (
 	"context"
+	"math"
 	"testing"
 
 	"github.com/sinmetal/slog"
@@ -9,9 +10,10 @@ import (
 
 // DQNDummyClient is UnitTestのためのDQN Dummy実装
 type DQNDummyClient struct {
-	PredictionCount int
-	Body            *Payload
-	DummyAnswer     *Answer
+	PredictionCount      int
+	BatchPredictionCount int
+	Body                 *Payload
+	DummyAnswer          *Answer
 }
 
 func (client *DQNDummyClient) Prediction(ctx context.Context, body *Payload) (*Answer, error) {
@@ -20,6 +22,16 @@ func (client *DQNDummyClient) Prediction(ctx context.Context, body *Payload) (*A
 	return client.DummyAnswer, nil
 }
 
+func (client *DQNDummyClient) BatchPrediction(ctx context.Context, body *Payload) (map[int]*Answer, error) {
+	client.BatchPredictionCount++
+	client.Body = body
+	answers := make(map[int]*Answer)
+	for _, v := range body.Instances {
+		answers[v.Key] = client.DummyAnswer
+	}
+	return answers, nil
+}
+
 func TestSetDummyClient(t *testing.T) {
 	da := &Answer{
 		X:      -1,
@@ -67,3 +79,132 @@ func TestSetDummyClient(t *testing.T) {
 		t.Fatalf("expected Speed is %f; got %f", e, g)
 	}
 }
+
+func TestBuildDQNBatchAnswer(t *testing.T) {
+	body := &Payload{
+		Instances: []Instance{
+			Instance{Key: 0},
+			Instance{Key: 1},
+		},
+	}
+	res := &apiResponse{
+		Predictions: []predictions{
+			// DQN APIが順番通りに返してくるとは限らない
+			predictions{Key: 1, Q: []float64{0, 0, 1, 0, 0}},
+			predictions{Key: 0, Q: []float64{0, 1, 0, 0, 0}},
+		},
+	}
+
+	answers, err := buildDQNBatchAnswer(body, res, &ArgmaxSelector{})
+	if err != nil {
+		t.Fatalf("failed buildDQNBatchAnswer. err = %+v", err)
+	}
+	if e, g := AngleLeft, answers[0].Angle; e != g {
+		t.Fatalf("expected key 0 Angle is %f; got %f", e, g)
+	}
+	if e, g := AngleRight, answers[1].Angle; e != g {
+		t.Fatalf("expected key 1 Angle is %f; got %f", e, g)
+	}
+
+	candidates := []struct {
+		name        string
+		predictions []predictions
+	}{
+		{
+			name:        "missing key",
+			predictions: []predictions{predictions{Key: 0, Q: []float64{0, 1, 0, 0, 0}}},
+		},
+		{
+			name: "duplicated key",
+			predictions: []predictions{
+				predictions{Key: 0, Q: []float64{0, 1, 0, 0, 0}},
+				predictions{Key: 1, Q: []float64{0, 0, 1, 0, 0}},
+				predictions{Key: 1, Q: []float64{0, 0, 0, 1, 0}},
+			},
+		},
+		{
+			name: "unknown key",
+			predictions: []predictions{
+				predictions{Key: 0, Q: []float64{0, 1, 0, 0, 0}},
+				predictions{Key: 1, Q: []float64{0, 0, 1, 0, 0}},
+				predictions{Key: 2, Q: []float64{0, 0, 0, 1, 0}},
+			},
+		},
+	}
+	for _, v := range candidates {
+		res := &apiResponse{Predictions: v.predictions}
+		if _, err := buildDQNBatchAnswer(body, res, &ArgmaxSelector{}); err != ErrDQNAPIResponse {
+			t.Fatalf("%s : expected err is %+v; got %+v", v.name, ErrDQNAPIResponse, err)
+		}
+	}
+}
+
+func TestBuildDQNAnswer_Invalid(t *testing.T) {
+	candidates := []struct {
+		name string
+		res  *apiResponse
+	}{
+		{
+			name: "empty predictions",
+			res:  &apiResponse{},
+		},
+		{
+			name: "short q",
+			res:  &apiResponse{Predictions: []predictions{predictions{Q: []float64{0, 1, 0}}}},
+		},
+		{
+			name: "NaN",
+			res:  &apiResponse{Predictions: []predictions{predictions{Q: []float64{0, math.NaN(), 0, 0, 0}}}},
+		},
+	}
+
+	for _, v := range candidates {
+		if _, err := buildDQNAnswer(&Payload{}, v.res, &ArgmaxSelector{}); err != ErrDQNAPIResponse {
+			t.Fatalf("%s : expected err is %+v; got %+v", v.name, ErrDQNAPIResponse, err)
+		}
+	}
+}
+
+func TestBuildDQNAnswer_Tie(t *testing.T) {
+	// 全て同じQ Scoreの場合は何もしない
+	res := &apiResponse{Predictions: []predictions{predictions{Q: []float64{0.5, 0.5, 0.5, 0.5, 0.5}}}}
+	a, err := buildDQNAnswer(&Payload{}, res, nil)
+	if err != nil {
+		t.Fatalf("failed buildDQNAnswer. err = %+v", err)
+	}
+	if a.IsMove {
+		t.Fatalf("expected not move; got %+v", a)
+	}
+}
+
+func TestBuildDQNBatchAnswer_InstanceSelector(t *testing.T) {
+	body := &Payload{
+		Instances: []Instance{
+			Instance{Key: 0},
+			Instance{Key: 1, Selector: NewEpsilonGreedySelector(1, 1)},
+		},
+	}
+	res := &apiResponse{
+		Predictions: []predictions{
+			predictions{Key: 0, Q: []float64{0, 0, 1, 0, 0}},
+			predictions{Key: 1, Q: []float64{0, 0, 1, 0, 0}},
+		},
+	}
+
+	moved := false
+	for i := 0; i < 100; i++ {
+		answers, err := buildDQNBatchAnswer(body, res, &ArgmaxSelector{})
+		if err != nil {
+			t.Fatalf("failed buildDQNBatchAnswer. err = %+v", err)
+		}
+		if e, g := AngleRight, answers[0].Angle; e != g {
+			t.Fatalf("expected key 0 Angle is %f; got %f", e, g)
+		}
+		if answers[1].Angle != AngleRight {
+			moved = true
+		}
+	}
+	if !moved {
+		t.Fatalf("expected key 1 uses instance selector")
+	}
+}
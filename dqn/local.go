@@ -0,0 +1,359 @@
+package dqn
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+
+	"go.opencensus.io/trace"
+)
+
+const (
+	// LayerConv2D is 畳み込み層
+	LayerConv2D = "conv2d"
+	// LayerDense is 全結合層
+	LayerDense = "dense"
+	// LayerFlatten is 平坦化層
+	LayerFlatten = "flatten"
+
+	// ActivationLinear is 活性化関数無し
+	ActivationLinear = "linear"
+	// ActivationReLU is ReLU
+	ActivationReLU = "relu"
+	// ActivationTanh is tanh
+	ActivationTanh = "tanh"
+
+	// PaddingValid is Paddingを行わない
+	PaddingValid = "valid"
+	// PaddingSame is 出力のサイズが入力/strideになるように0でPaddingする (TensorFlowと同じ)
+	PaddingSame = "same"
+)
+
+// Model is ローカルで推論するためのQ-Networkの重み
+// 入力は Instance.State と同じ [SenseRangeRow][SenseRangeCol][3] (channels last)
+// 出力は apiResponse の Q と同じ [0]何もしない,[1]左,[2]右,[3]上,[4]下 の5つ
+type Model struct {
+	Layers []Layer `json:"layers"`
+}
+
+// Layer is Q-Networkの1層
+// Conv2DのKernelは [kernelHeight][kernelWidth][inChannels][filters]
+// DenseのWeightsは [inputs][units] で、入力は channels last のまま平坦化したもの
+// Kerasの get_weights() と同じ並び
+type Layer struct {
+	Type       string          `json:"type"`
+	Activation string          `json:"activation"`
+	Kernel     [][][][]float64 `json:"kernel,omitempty"`
+	Weights    [][]float64     `json:"weights,omitempty"`
+	Bias       []float64       `json:"bias"`
+	Stride     int             `json:"stride,omitempty"`
+	Padding    string          `json:"padding,omitempty"`
+}
+
+// tensor is [h][w][c] の値を平坦化して持つ
+type tensor struct {
+	h, w, c int
+	v       []float64
+}
+
+func (t *tensor) at(row int, col int, ch int) float64 {
+	return t.v[(row*t.w+col)*t.c+ch]
+}
+
+// LoadModelFile is JSONで書き出したModelを読み込む
+func LoadModelFile(path string) (*Model, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m Model
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// LocalClient is DQN APIを使わずに、プロセス内でQ-Networkを評価するClient
+// Optionは WithSelector のみが使われる
+type LocalClient struct {
+	model    *Model
+	selector Selector
+}
+
+// NewLocalClient is LocalClientを生成する
+// Modelの形が入力と出力に合っていない場合はErrorを返す
+func NewLocalClient(model *Model, opts ...Option) (*LocalClient, error) {
+	if err := model.Validate(); err != nil {
+		return nil, err
+	}
+	return &LocalClient{
+		model:    model,
+		selector: newConfig(opts...).selector,
+	}, nil
+}
+
+// Validate is 全ての層の重みの形が前の層の出力と合っていて、出力が行動の数と同じになるかを確認する
+// 重みの行ごとに長さを確認するので、途中の行だけ長さが違うModelも推論する前にErrorにする
+func (m *Model) Validate() error {
+	h, w, c := SenseRangeRow, SenseRangeCol, len(Instance{}.State[0][0])
+	for i := range m.Layers {
+		l := &m.Layers[i]
+		var err error
+		switch l.Type {
+		case LayerConv2D:
+			var g *conv2DGeometry
+			g, err = l.conv2DShape(h, w, c)
+			if err == nil {
+				h, w, c = g.oh, g.ow, g.filters
+			}
+		case LayerDense:
+			var units int
+			units, err = l.denseUnits(h * w * c)
+			h, w, c = 1, 1, units
+		case LayerFlatten:
+			h, w, c = 1, 1, h*w*c
+		default:
+			err = fmt.Errorf("unknown layer type %s", l.Type)
+		}
+		if err != nil {
+			return fmt.Errorf("layer %d : %s", i, err)
+		}
+		if err := activate(&tensor{}, l.Activation); err != nil {
+			return fmt.Errorf("layer %d : %s", i, err)
+		}
+	}
+	if h*w*c != actionCount {
+		return fmt.Errorf("model output size is %d. expected %d", h*w*c, actionCount)
+	}
+	return nil
+}
+
+// Prediction is Payloadの先頭のInstanceについてQ-Networkを評価する
+func (c *LocalClient) Prediction(ctx context.Context, body *Payload) (*Answer, error) {
+	ctx, span := trace.StartSpan(ctx, "/dqn/local")
+	defer span.End()
+
+	res, err := c.predict(body)
+	if err != nil {
+		return nil, err
+	}
+	return buildDQNAnswer(body, res, c.selector)
+}
+
+// BatchPrediction is Payloadの全てのInstanceについてQ-Networkを評価する
+func (c *LocalClient) BatchPrediction(ctx context.Context, body *Payload) (map[int]*Answer, error) {
+	ctx, span := trace.StartSpan(ctx, "/dqn/local/batch")
+	defer span.End()
+
+	res, err := c.predict(body)
+	if err != nil {
+		return nil, err
+	}
+	return buildDQNBatchAnswer(body, res, c.selector)
+}
+
+// predict is DQN APIと同じ形のResponseを組み立てる
+func (c *LocalClient) predict(body *Payload) (*apiResponse, error) {
+	res := &apiResponse{}
+	for i := range body.Instances {
+		q, err := c.Q(&body.Instances[i])
+		if err != nil {
+			return nil, err
+		}
+		res.Predictions = append(res.Predictions, predictions{Q: q, Key: body.Instances[i].Key})
+	}
+	return res, nil
+}
+
+// Q is InstanceのQ Scoreを計算する
+func (c *LocalClient) Q(instance *Instance) ([]float64, error) {
+	channels := len(instance.State[0][0])
+	t := &tensor{h: SenseRangeRow, w: SenseRangeCol, c: channels, v: make([]float64, 0, SenseRangeRow*SenseRangeCol*channels)}
+	for row := 0; row < SenseRangeRow; row++ {
+		for col := 0; col < SenseRangeCol; col++ {
+			t.v = append(t.v, instance.State[row][col][:]...)
+		}
+	}
+
+	for i, l := range c.model.Layers {
+		var err error
+		switch l.Type {
+		case LayerConv2D:
+			t, err = conv2D(t, &l)
+		case LayerDense:
+			t, err = dense(t, &l)
+		case LayerFlatten:
+			t = &tensor{h: 1, w: 1, c: len(t.v), v: t.v}
+		default:
+			err = fmt.Errorf("unknown layer type %s", l.Type)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("layer %d : %s", i, err)
+		}
+		if err := activate(t, l.Activation); err != nil {
+			return nil, fmt.Errorf("layer %d : %s", i, err)
+		}
+	}
+	return t.v, nil
+}
+
+// conv2DGeometry is Conv2Dの出力の大きさとPadding
+type conv2DGeometry struct {
+	kh, kw, filters int
+	stride          int
+	oh, ow          int
+	padTop, padLeft int
+}
+
+// conv2DShape is 入力が inH x inW x inC の時の出力の大きさを返す
+// Kernelの全ての [i][j][ch] の長さとBiasの長さが揃っていない場合はErrorを返す
+func (l *Layer) conv2DShape(inH int, inW int, inC int) (*conv2DGeometry, error) {
+	kh := len(l.Kernel)
+	if kh < 1 || len(l.Kernel[0]) < 1 || len(l.Kernel[0][0]) < 1 {
+		return nil, fmt.Errorf("conv2d kernel is empty")
+	}
+	kw := len(l.Kernel[0])
+	filters := len(l.Kernel[0][0][0])
+	if filters < 1 {
+		return nil, fmt.Errorf("conv2d kernel has no filter")
+	}
+	for i := range l.Kernel {
+		if len(l.Kernel[i]) != kw {
+			return nil, fmt.Errorf("conv2d kernel[%d] width is %d. expected %d", i, len(l.Kernel[i]), kw)
+		}
+		for j := range l.Kernel[i] {
+			if len(l.Kernel[i][j]) != inC {
+				return nil, fmt.Errorf("conv2d kernel[%d][%d] channels is %d. expected input channels %d", i, j, len(l.Kernel[i][j]), inC)
+			}
+			for ch := range l.Kernel[i][j] {
+				if len(l.Kernel[i][j][ch]) != filters {
+					return nil, fmt.Errorf("conv2d kernel[%d][%d][%d] filters is %d. expected %d", i, j, ch, len(l.Kernel[i][j][ch]), filters)
+				}
+			}
+		}
+	}
+	if len(l.Bias) != filters {
+		return nil, fmt.Errorf("conv2d bias size is %d. expected %d", len(l.Bias), filters)
+	}
+	g := &conv2DGeometry{kh: kh, kw: kw, filters: filters, stride: l.Stride}
+	if g.stride < 1 {
+		g.stride = 1
+	}
+
+	switch l.Padding {
+	case PaddingSame:
+		g.oh = (inH + g.stride - 1) / g.stride
+		g.ow = (inW + g.stride - 1) / g.stride
+		g.padTop = maxInt((g.oh-1)*g.stride+kh-inH, 0) / 2
+		g.padLeft = maxInt((g.ow-1)*g.stride+kw-inW, 0) / 2
+	case PaddingValid, "":
+		g.oh = (inH-kh)/g.stride + 1
+		g.ow = (inW-kw)/g.stride + 1
+	default:
+		return nil, fmt.Errorf("unknown padding %s", l.Padding)
+	}
+	if g.oh < 1 || g.ow < 1 {
+		return nil, fmt.Errorf("conv2d kernel %dx%d is larger than input %dx%d", kh, kw, inH, inW)
+	}
+	return g, nil
+}
+
+func conv2D(in *tensor, l *Layer) (*tensor, error) {
+	g, err := l.conv2DShape(in.h, in.w, in.c)
+	if err != nil {
+		return nil, err
+	}
+	kh, kw, filters, stride := g.kh, g.kw, g.filters, g.stride
+	oh, ow, padTop, padLeft := g.oh, g.ow, g.padTop, g.padLeft
+
+	out := &tensor{h: oh, w: ow, c: filters, v: make([]float64, oh*ow*filters)}
+	for row := 0; row < oh; row++ {
+		for col := 0; col < ow; col++ {
+			for f := 0; f < filters; f++ {
+				sum := l.Bias[f]
+				for i := 0; i < kh; i++ {
+					r := row*stride + i - padTop
+					if r < 0 || r >= in.h {
+						continue
+					}
+					for j := 0; j < kw; j++ {
+						c := col*stride + j - padLeft
+						if c < 0 || c >= in.w {
+							continue
+						}
+						for ch := 0; ch < in.c; ch++ {
+							sum += in.at(r, c, ch) * l.Kernel[i][j][ch][f]
+						}
+					}
+				}
+				out.v[(row*ow+col)*filters+f] = sum
+			}
+		}
+	}
+	return out, nil
+}
+
+// denseUnits is 入力がinputs個の時の出力の数を返す
+// Weightsの全ての行の長さとBiasの長さが揃っていない場合はErrorを返す
+func (l *Layer) denseUnits(inputs int) (int, error) {
+	if len(l.Weights) != inputs || inputs < 1 || len(l.Weights[0]) < 1 {
+		return 0, fmt.Errorf("dense weights size is %d. expected %d", len(l.Weights), inputs)
+	}
+	units := len(l.Weights[0])
+	for i, row := range l.Weights {
+		if len(row) != units {
+			return 0, fmt.Errorf("dense weights[%d] size is %d. expected %d", i, len(row), units)
+		}
+	}
+	if len(l.Bias) != units {
+		return 0, fmt.Errorf("dense bias size is %d. expected %d", len(l.Bias), units)
+	}
+	return units, nil
+}
+
+func dense(in *tensor, l *Layer) (*tensor, error) {
+	units, err := l.denseUnits(len(in.v))
+	if err != nil {
+		return nil, err
+	}
+
+	out := &tensor{h: 1, w: 1, c: units, v: make([]float64, units)}
+	copy(out.v, l.Bias)
+	for i, x := range in.v {
+		if x == 0 {
+			continue
+		}
+		for u := 0; u < units; u++ {
+			out.v[u] += x * l.Weights[i][u]
+		}
+	}
+	return out, nil
+}
+
+func activate(t *tensor, activation string) error {
+	switch activation {
+	case ActivationLinear, "":
+	case ActivationReLU:
+		for i, v := range t.v {
+			if v < 0 {
+				t.v[i] = 0
+			}
+		}
+	case ActivationTanh:
+		for i, v := range t.v {
+			t.v[i] = math.Tanh(v)
+		}
+	default:
+		return fmt.Errorf("unknown activation %s", activation)
+	}
+	return nil
+}
+
+func maxInt(a int, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
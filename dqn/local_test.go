@@ -0,0 +1,231 @@
+package dqn
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// referenceRecord is LocalClientに渡すPayloadと、期待するResponseの組
+type referenceRecord struct {
+	Payload  Payload     `json:"payload"`
+	Response apiResponse `json:"response"`
+}
+
+// TestLocalClient_Reference is LocalClientの結果が、testdata/reference の期待するResponseと一致するかを確認する
+// testdata/reference のResponseはdqn-serviceの記録ではなく、testdata/gen_reference.py がPythonで同じ計算をした結果なので、
+// 本番のdqn-serviceと同じ結果になることは確認していない。LocalClientの計算が変わっていないことを確認するためのTest
+// 書き出した本番のModelと、dqn-serviceに送ったPayloadと返ってきたResponseの組を記録したDirectoryを
+// DQN_REFERENCE_MODEL と DQN_REFERENCE_DIR に指定すると、同じTestでdqn-serviceと比べられる
+func TestLocalClient_Reference(t *testing.T) {
+	modelPath := os.Getenv("DQN_REFERENCE_MODEL")
+	if modelPath == "" {
+		modelPath = "testdata/model.json"
+	}
+	dir := os.Getenv("DQN_REFERENCE_DIR")
+	if dir == "" {
+		dir = "testdata/reference"
+	}
+
+	m, err := LoadModelFile(modelPath)
+	if err != nil {
+		t.Fatalf("failed LoadModelFile. err = %+v", err)
+	}
+	c, err := NewLocalClient(m)
+	if err != nil {
+		t.Fatalf("failed NewLocalClient. err = %+v", err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		t.Fatalf("failed Glob. err = %+v", err)
+	}
+	if len(files) < 1 {
+		t.Fatalf("reference record is not found in %s", dir)
+	}
+
+	ctx := context.Background()
+	const tolerance = 1e-6
+	for _, f := range files {
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			t.Fatalf("failed ReadFile %s. err = %+v", f, err)
+		}
+		var record referenceRecord
+		if err := json.Unmarshal(b, &record); err != nil {
+			t.Fatalf("failed Unmarshal %s. err = %+v", f, err)
+		}
+
+		res, err := c.predict(&record.Payload)
+		if err != nil {
+			t.Fatalf("%s : failed predict. err = %+v", f, err)
+		}
+		expected := make(map[int][]float64)
+		for _, p := range record.Response.Predictions {
+			expected[p.Key] = p.Q
+		}
+		for _, p := range res.Predictions {
+			eq, ok := expected[p.Key]
+			if !ok {
+				t.Fatalf("%s : key %d is not found in reference response", f, p.Key)
+			}
+			if e, g := len(eq), len(p.Q); e != g {
+				t.Fatalf("%s : key %d expected len(q) is %d; got %d", f, p.Key, e, g)
+			}
+			for i := range eq {
+				if math.Abs(eq[i]-p.Q[i]) > tolerance {
+					t.Fatalf("%s : key %d expected q[%d] is %f; got %f", f, p.Key, i, eq[i], p.Q[i])
+				}
+			}
+		}
+
+		expectedAnswers, err := buildDQNBatchAnswer(&record.Payload, &record.Response, &ArgmaxSelector{})
+		if err != nil {
+			t.Fatalf("%s : failed buildDQNBatchAnswer. err = %+v", f, err)
+		}
+		answers, err := c.BatchPrediction(ctx, &record.Payload)
+		if err != nil {
+			t.Fatalf("%s : failed BatchPrediction. err = %+v", f, err)
+		}
+		for k, e := range expectedAnswers {
+			if g := answers[k]; *e != *g {
+				t.Fatalf("%s : key %d expected answer is %+v; got %+v", f, k, e, g)
+			}
+		}
+	}
+}
+
+func TestNewLocalClient_InvalidModel(t *testing.T) {
+	candidates := []struct {
+		name  string
+		model *Model
+	}{
+		{
+			name:  "no layer",
+			model: &Model{},
+		},
+		{
+			name: "dense input size",
+			model: &Model{Layers: []Layer{
+				Layer{Type: LayerDense, Weights: [][]float64{{1, 1, 1, 1, 1}}, Bias: []float64{0, 0, 0, 0, 0}},
+			}},
+		},
+		{
+			name: "conv2d channels",
+			model: &Model{Layers: []Layer{
+				Layer{Type: LayerConv2D, Kernel: [][][][]float64{{{{1}}}}, Bias: []float64{0}},
+			}},
+		},
+		{
+			name: "unknown layer",
+			model: &Model{Layers: []Layer{
+				Layer{Type: "lstm"},
+			}},
+		},
+		{
+			name: "unknown activation",
+			model: &Model{Layers: []Layer{
+				Layer{Type: LayerFlatten, Activation: "gelu"},
+			}},
+		},
+	}
+
+	for _, v := range candidates {
+		if _, err := NewLocalClient(v.model); err == nil {
+			t.Fatalf("%s : expected error", v.name)
+		}
+	}
+}
+
+func TestModel_Validate_Ragged(t *testing.T) {
+	inputs := SenseRangeRow * SenseRangeCol * 3
+	weights := func() [][]float64 {
+		w := make([][]float64, inputs)
+		for i := range w {
+			w[i] = make([]float64, actionCount)
+		}
+		return w
+	}
+	kernel := func() [][][][]float64 {
+		k := make([][][][]float64, 1)
+		k[0] = make([][][]float64, 2)
+		for j := range k[0] {
+			k[0][j] = [][]float64{{1}, {1}, {1}}
+		}
+		return k
+	}
+	head := []Layer{Layer{Type: LayerFlatten}}
+	conv := Layer{Type: LayerConv2D, Bias: []float64{0}}
+	tail := []Layer{
+		Layer{Type: LayerFlatten},
+		Layer{Type: LayerDense, Weights: make([][]float64, SenseRangeRow*(SenseRangeCol-1)), Bias: make([]float64, actionCount)},
+	}
+	for i := range tail[1].Weights {
+		tail[1].Weights[i] = make([]float64, actionCount)
+	}
+
+	ok := &Model{Layers: append(head, Layer{Type: LayerDense, Weights: weights(), Bias: make([]float64, actionCount)})}
+	if err := ok.Validate(); err != nil {
+		t.Fatalf("failed Validate. err = %+v", err)
+	}
+	conv.Kernel = kernel()
+	if err := (&Model{Layers: append([]Layer{conv}, tail...)}).Validate(); err != nil {
+		t.Fatalf("failed Validate. err = %+v", err)
+	}
+
+	candidates := []struct {
+		name  string
+		model func() *Model
+	}{
+		{
+			// 入力が0の所は計算を飛ばすので、推論するまで気付けない行
+			name: "dense ragged weights row",
+			model: func() *Model {
+				w := weights()
+				w[inputs-1] = w[inputs-1][:actionCount-1]
+				return &Model{Layers: append(head, Layer{Type: LayerDense, Weights: w, Bias: make([]float64, actionCount)})}
+			},
+		},
+		{
+			name: "conv2d ragged kernel channels",
+			model: func() *Model {
+				l := conv
+				l.Kernel = kernel()
+				l.Kernel[0][1] = l.Kernel[0][1][:2]
+				return &Model{Layers: append([]Layer{l}, tail...)}
+			},
+		},
+		{
+			name: "conv2d ragged kernel filters",
+			model: func() *Model {
+				l := conv
+				l.Kernel = kernel()
+				l.Kernel[0][1][2] = []float64{1, 1}
+				return &Model{Layers: append([]Layer{l}, tail...)}
+			},
+		},
+		{
+			name: "conv2d ragged kernel width",
+			model: func() *Model {
+				l := conv
+				l.Kernel = kernel()
+				l.Kernel = append(l.Kernel, l.Kernel[0][:1])
+				return &Model{Layers: append([]Layer{l}, tail...)}
+			},
+		},
+	}
+
+	for _, v := range candidates {
+		m := v.model()
+		if err := m.Validate(); err == nil {
+			t.Fatalf("%s : expected error", v.name)
+		}
+		if _, err := NewLocalClient(m); err == nil {
+			t.Fatalf("%s : expected NewLocalClient error", v.name)
+		}
+	}
+}
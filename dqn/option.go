@@ -0,0 +1,90 @@
+package dqn
+
+import (
+	"net/http"
+	"time"
+)
+
+const (
+	// DefaultEndpoint is DQN APIのデフォルトのURL
+	DefaultEndpoint = "http://dqn-service.default.svc.cluster.local:8081/dqn"
+
+	// DefaultTimeout is DQN APIへの1Requestのデフォルトのタイムアウト
+	// Monster Controlは100ms間隔で動くので、DQNが応答しない場合に止まりすぎないようにしている
+	DefaultTimeout = 500 * time.Millisecond
+)
+
+// Option is NewClient, NewLocalClient で生成するClientの設定
+type Option func(c *config)
+
+// config is Optionで変更できるClientの設定
+type config struct {
+	endpoint   string
+	timeout    time.Duration
+	httpClient *http.Client
+	header     http.Header
+	selector   Selector
+}
+
+func newConfig(opts ...Option) config {
+	c := config{
+		endpoint: DefaultEndpoint,
+		timeout:  DefaultTimeout,
+		header:   http.Header{},
+		selector: &ArgmaxSelector{},
+	}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	return c
+}
+
+// WithEndpoint is DQN APIのURLを指定する
+func WithEndpoint(endpoint string) Option {
+	return func(c *config) {
+		c.endpoint = endpoint
+	}
+}
+
+// WithTimeout is DQN APIへの1Requestのタイムアウトを指定する
+// 0以下を指定した場合は、ctxのDeadlineのみに従う
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *config) {
+		c.timeout = timeout
+	}
+}
+
+// WithHTTPClient is DQN APIへのRequestに利用するhttp.Clientを指定する
+// Connectionを使い回すために、Clientは生成したものを全Requestで共有する
+func WithHTTPClient(client *http.Client) Option {
+	return func(c *config) {
+		c.httpClient = client
+	}
+}
+
+// WithHeader is DQN APIへのRequestに付与するHeaderを追加する
+func WithHeader(key string, value string) Option {
+	return func(c *config) {
+		c.header.Add(key, value)
+	}
+}
+
+// WithSelector is Q Scoreから行動を選ぶ戦略を指定する
+// Instance.Selector が指定されている場合は、そちらが優先される
+func WithSelector(selector Selector) Option {
+	return func(c *config) {
+		c.selector = selector
+	}
+}
+
+// newDefaultHTTPClient is 1つのDQN Serviceに対してConnectionを使い回すためのhttp.Clientを生成する
+func newDefaultHTTPClient() *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy:               http.ProxyFromEnvironment,
+			MaxIdleConns:        16,
+			MaxIdleConnsPerHost: 16,
+			IdleConnTimeout:     90 * time.Second,
+		},
+	}
+}
@@ -0,0 +1,86 @@
+package dqn
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sinmetal/slog"
+)
+
+func TestNewClient_Options(t *testing.T) {
+	SetDummyClient(nil)
+
+	var header http.Header
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		w.Write([]byte(`{"predictions":[{"q":[0,0,1,0,0],"key":0}]}`))
+	}))
+	defer ts.Close()
+
+	client := NewClient(
+		WithEndpoint(ts.URL),
+		WithTimeout(time.Second),
+		WithHTTPClient(ts.Client()),
+		WithHeader("X-Land", "home"),
+	)
+
+	ctx := slog.WithLog(context.Background())
+	a, err := client.Prediction(ctx, &Payload{Instances: []Instance{Instance{}}})
+	if err != nil {
+		t.Fatalf("failed Prediction. err = %+v", err)
+	}
+	if e, g := AngleRight, a.Angle; e != g {
+		t.Fatalf("expected Angle is %f; got %f", e, g)
+	}
+	if e, g := "home", header.Get("X-Land"); e != g {
+		t.Fatalf("expected X-Land header is %s; got %s", e, g)
+	}
+}
+
+func TestNewClient_Timeout(t *testing.T) {
+	SetDummyClient(nil)
+
+	done := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 応答しないDQN
+		select {
+		case <-done:
+		case <-time.After(10 * time.Second):
+		}
+	}))
+	defer ts.Close()
+	defer close(done)
+
+	client := NewClient(
+		WithEndpoint(ts.URL),
+		WithTimeout(50*time.Millisecond),
+	)
+
+	ctx := slog.WithLog(context.Background())
+	start := time.Now()
+	if _, err := client.Prediction(ctx, &Payload{Instances: []Instance{Instance{}}}); err == nil {
+		t.Fatalf("expected timeout error")
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Fatalf("expected timeout in 50ms; took %s", d)
+	}
+}
+
+func TestNewClient_ErrorStatus(t *testing.T) {
+	SetDummyClient(nil)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	client := NewClient(WithEndpoint(ts.URL))
+
+	ctx := slog.WithLog(context.Background())
+	if _, err := client.BatchPrediction(ctx, &Payload{Instances: []Instance{Instance{}}}); err != ErrDQNAPIResponse {
+		t.Fatalf("expected err is %+v; got %+v", ErrDQNAPIResponse, err)
+	}
+}
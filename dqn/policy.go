@@ -0,0 +1,193 @@
+package dqn
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Q Scoreの添字と同じ並びの行動
+const (
+	actionNone = iota
+	actionLeft
+	actionRight
+	actionUp
+	actionDown
+
+	actionCount
+)
+
+// newAnswer is 行動からAnswerを組み立てる
+func newAnswer(action int) *Answer {
+	switch action {
+	case actionLeft:
+		return &Answer{X: -1, Y: 0, IsMove: true, Angle: AngleLeft, Speed: speed}
+	case actionRight:
+		return &Answer{X: 1, Y: 0, IsMove: true, Angle: AngleRight, Speed: speed}
+	case actionUp:
+		return &Answer{X: 0, Y: -1, IsMove: true, Angle: AngleUp, Speed: speed}
+	case actionDown:
+		return &Answer{X: 0, Y: 1, IsMove: true, Angle: AngleDown, Speed: speed}
+	default:
+		return &Answer{X: 0, Y: 0, IsMove: false, Angle: AngleDown, Speed: 0}
+	}
+}
+
+// actionDelta is 行動した時に移動するRow, Col
+func actionDelta(action int) (row int, col int) {
+	switch action {
+	case actionLeft:
+		return 0, -1
+	case actionRight:
+		return 0, 1
+	case actionUp:
+		return -1, 0
+	case actionDown:
+		return 1, 0
+	default:
+		return 0, 0
+	}
+}
+
+// isBlocked is Instanceの中心にいるMonsterが、行動した先に障害物があるかどうかを返す
+func isBlocked(instance *Instance, action int) bool {
+	dr, dc := actionDelta(action)
+	row := SenseRangeRow/2 + dr
+	col := SenseRangeCol/2 + dc
+	if row < 0 || row >= SenseRangeRow || col < 0 || col >= SenseRangeCol {
+		return true
+	}
+	return instance.State[row][col][ObstacleLayer] > 0
+}
+
+// PolicyClient is DQN APIを使わずに、Instanceからローカルで行動を決めるClient
+// DQN Serviceが使えない時のFallbackとして利用する
+type PolicyClient struct {
+	decide func(instance *Instance) int
+}
+
+// Prediction is Payloadの先頭のInstanceについて行動を決める
+func (p *PolicyClient) Prediction(ctx context.Context, body *Payload) (*Answer, error) {
+	if len(body.Instances) < 1 {
+		return nil, ErrDQNAPIResponse
+	}
+	return newAnswer(p.decide(&body.Instances[0])), nil
+}
+
+// BatchPrediction is Payloadの全てのInstanceについて行動を決める
+func (p *PolicyClient) BatchPrediction(ctx context.Context, body *Payload) (map[int]*Answer, error) {
+	answers := make(map[int]*Answer, len(body.Instances))
+	for i := range body.Instances {
+		answers[body.Instances[i].Key] = newAnswer(p.decide(&body.Instances[i]))
+	}
+	return answers, nil
+}
+
+// NewPolicyClient is 名前を指定してPolicyClientを生成する
+// chase, wander, idle を指定できる
+func NewPolicyClient(name string) (*PolicyClient, error) {
+	switch name {
+	case "chase":
+		return NewChasePolicy(), nil
+	case "wander":
+		return NewWanderPolicy(time.Now().UnixNano()), nil
+	case "idle":
+		return NewIdlePolicy(), nil
+	default:
+		return nil, fmt.Errorf("unknown policy. name = %s", name)
+	}
+}
+
+// NewIdlePolicy is 何もしないPolicy
+func NewIdlePolicy() *PolicyClient {
+	return &PolicyClient{
+		decide: func(instance *Instance) int {
+			return actionNone
+		},
+	}
+}
+
+// NewWanderPolicy is 障害物の無い方向にランダムに動くPolicy
+func NewWanderPolicy(seed int64) *PolicyClient {
+	var mu sync.Mutex
+	r := rand.New(rand.NewSource(seed))
+	return &PolicyClient{
+		decide: func(instance *Instance) int {
+			mu.Lock()
+			action := r.Intn(actionCount)
+			mu.Unlock()
+
+			if isBlocked(instance, action) {
+				return actionNone
+			}
+			return action
+		},
+	}
+}
+
+// NewChasePolicy is 感知範囲内で一番近いプレイヤーに向かって動くPolicy
+// 距離が遠い方の軸を優先して動き、障害物がある場合はもう一方の軸で近づく
+func NewChasePolicy() *PolicyClient {
+	return &PolicyClient{
+		decide: func(instance *Instance) int {
+			dr, dc, ok := nearestPlayer(instance)
+			if !ok {
+				return actionNone
+			}
+
+			rowAction := actionNone
+			if dr < 0 {
+				rowAction = actionUp
+			} else if dr > 0 {
+				rowAction = actionDown
+			}
+			colAction := actionNone
+			if dc < 0 {
+				colAction = actionLeft
+			} else if dc > 0 {
+				colAction = actionRight
+			}
+
+			candidates := []int{rowAction, colAction}
+			if abs(dc) > abs(dr) {
+				candidates = []int{colAction, rowAction}
+			}
+			for _, action := range candidates {
+				if action == actionNone || isBlocked(instance, action) {
+					continue
+				}
+				return action
+			}
+			return actionNone
+		},
+	}
+}
+
+// nearestPlayer is Instanceの中心から一番近いプレイヤーまでのRow, Colの差を返す
+func nearestPlayer(instance *Instance) (dr int, dc int, ok bool) {
+	min := -1
+	for row := 0; row < SenseRangeRow; row++ {
+		for col := 0; col < SenseRangeCol; col++ {
+			if instance.State[row][col][PlayerLayer] <= 0 {
+				continue
+			}
+			r := row - SenseRangeRow/2
+			c := col - SenseRangeCol/2
+			d := abs(r) + abs(c)
+			if min < 0 || d < min {
+				min = d
+				dr, dc, ok = r, c, true
+			}
+		}
+	}
+	return dr, dc, ok
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
@@ -0,0 +1,111 @@
+package dqn
+
+import (
+	"context"
+	"testing"
+)
+
+func TestChasePolicy(t *testing.T) {
+	candidates := []struct {
+		player   [][2]int
+		obstacle [][2]int
+		expected *Answer
+	}{
+		{
+			// プレイヤーがいない
+			expected: newAnswer(actionNone),
+		},
+		{
+			// 右にプレイヤーがいる
+			player:   [][2]int{{SenseRangeRow / 2, SenseRangeCol/2 + 2}},
+			expected: newAnswer(actionRight),
+		},
+		{
+			// 左上にいるが、上の方が遠い
+			player:   [][2]int{{0, SenseRangeCol/2 - 1}},
+			expected: newAnswer(actionUp),
+		},
+		{
+			// 上に障害物があるので左から回り込む
+			player:   [][2]int{{0, SenseRangeCol/2 - 1}},
+			obstacle: [][2]int{{SenseRangeRow/2 - 1, SenseRangeCol / 2}},
+			expected: newAnswer(actionLeft),
+		},
+		{
+			// 近い方のプレイヤーを追いかける
+			player:   [][2]int{{SenseRangeRow/2 + 1, SenseRangeCol / 2}, {0, 0}},
+			expected: newAnswer(actionDown),
+		},
+		{
+			// 真下にいるが障害物で進めない
+			player:   [][2]int{{SenseRangeRow/2 + 2, SenseRangeCol / 2}},
+			obstacle: [][2]int{{SenseRangeRow/2 + 1, SenseRangeCol / 2}},
+			expected: newAnswer(actionNone),
+		},
+	}
+
+	ctx := context.Background()
+	policy := NewChasePolicy()
+	for i, v := range candidates {
+		instance := Instance{}
+		for _, p := range v.player {
+			instance.State[p[0]][p[1]][PlayerLayer] = 1
+		}
+		for _, o := range v.obstacle {
+			instance.State[o[0]][o[1]][ObstacleLayer] = 1
+		}
+		a, err := policy.Prediction(ctx, &Payload{Instances: []Instance{instance}})
+		if err != nil {
+			t.Fatalf("%d : failed Prediction. err = %+v", i, err)
+		}
+		if e, g := *v.expected, *a; e != g {
+			t.Fatalf("%d : expected %+v; got %+v", i, e, g)
+		}
+	}
+}
+
+func TestWanderPolicy(t *testing.T) {
+	ctx := context.Background()
+
+	// 周りが全て障害物なので動けない
+	instance := Instance{}
+	for _, action := range []int{actionLeft, actionRight, actionUp, actionDown} {
+		dr, dc := actionDelta(action)
+		instance.State[SenseRangeRow/2+dr][SenseRangeCol/2+dc][ObstacleLayer] = 1
+	}
+	body := &Payload{Instances: []Instance{instance, Instance{Key: 1}}}
+	body.Instances[0].Key = 0
+
+	policy := NewWanderPolicy(1)
+	for i := 0; i < 100; i++ {
+		answers, err := policy.BatchPrediction(ctx, body)
+		if err != nil {
+			t.Fatalf("failed BatchPrediction. err = %+v", err)
+		}
+		if e, g := 2, len(answers); e != g {
+			t.Fatalf("expected len(answers) is %d; got %d", e, g)
+		}
+		if answers[0].IsMove {
+			t.Fatalf("expected not move; got %+v", answers[0])
+		}
+	}
+}
+
+func TestNewPolicyClient(t *testing.T) {
+	for _, name := range []string{"chase", "wander", "idle"} {
+		if _, err := NewPolicyClient(name); err != nil {
+			t.Fatalf("failed NewPolicyClient %s. err = %+v", name, err)
+		}
+	}
+	if _, err := NewPolicyClient("hoge"); err == nil {
+		t.Fatalf("expected error")
+	}
+
+	a, err := NewIdlePolicy().Prediction(context.Background(), &Payload{Instances: []Instance{Instance{}}})
+	if err != nil {
+		t.Fatalf("failed Prediction. err = %+v", err)
+	}
+	if a.IsMove {
+		t.Fatalf("expected not move; got %+v", a)
+	}
+}
@@ -0,0 +1,158 @@
+package dqn
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Selector is Q Scoreから行動を選ぶ戦略
+// qは [0]何もしない,[1]左,[2]右,[3]上,[4]下 の5つで、NaNなどが含まれていないことは呼び出し側で確認している
+type Selector interface {
+	Select(q []float64) int
+}
+
+// lockedRand is 複数goroutineから使えるように、Lockを取って使う*rand.Rand
+type lockedRand struct {
+	mu sync.Mutex
+	r  *rand.Rand
+}
+
+func newLockedRand(seed int64) *lockedRand {
+	return &lockedRand{r: rand.New(rand.NewSource(seed))}
+}
+
+func (r *lockedRand) Float64() float64 {
+	if r == nil {
+		return rand.Float64()
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.r.Float64()
+}
+
+func (r *lockedRand) Intn(n int) int {
+	if r == nil {
+		return rand.Intn(n)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.r.Intn(n)
+}
+
+// ArgmaxSelector is 一番Q Scoreが高い行動を選ぶ
+// 同じQ Scoreの行動が複数ある場合は、添字が小さい方を選ぶ (何もしない,左,右,上,下の順に優先)
+type ArgmaxSelector struct{}
+
+// Select is 一番Q Scoreが高い行動を選ぶ
+func (s *ArgmaxSelector) Select(q []float64) int {
+	return argmax(q)
+}
+
+func argmax(q []float64) int {
+	best := 0
+	for i := 1; i < len(q); i++ {
+		if q[i] > q[best] {
+			best = i
+		}
+	}
+	return best
+}
+
+// EpsilonGreedySelector is Epsilonの確率でランダムな行動を、それ以外は一番Q Scoreが高い行動を選ぶ
+type EpsilonGreedySelector struct {
+	Epsilon float64
+	rand    *lockedRand
+}
+
+// NewEpsilonGreedySelector is EpsilonGreedySelectorを生成する
+// seedを固定すると、選ぶ行動が再現できる
+func NewEpsilonGreedySelector(epsilon float64, seed int64) *EpsilonGreedySelector {
+	return &EpsilonGreedySelector{
+		Epsilon: epsilon,
+		rand:    newLockedRand(seed),
+	}
+}
+
+// Select is Epsilonの確率でランダムな行動を選ぶ
+func (s *EpsilonGreedySelector) Select(q []float64) int {
+	if s.rand.Float64() < s.Epsilon {
+		return s.rand.Intn(len(q))
+	}
+	return argmax(q)
+}
+
+// SoftmaxSelector is Q ScoreをTemperatureで割ったSoftmaxの確率で行動を選ぶ
+// Temperatureが高いほどランダムに、0に近いほど ArgmaxSelector と同じになる
+type SoftmaxSelector struct {
+	Temperature float64
+	rand        *lockedRand
+}
+
+// NewSoftmaxSelector is SoftmaxSelectorを生成する
+// seedを固定すると、選ぶ行動が再現できる
+func NewSoftmaxSelector(temperature float64, seed int64) *SoftmaxSelector {
+	return &SoftmaxSelector{
+		Temperature: temperature,
+		rand:        newLockedRand(seed),
+	}
+}
+
+// Select is Softmaxの確率で行動を選ぶ
+func (s *SoftmaxSelector) Select(q []float64) int {
+	if s.Temperature <= 0 {
+		return argmax(q)
+	}
+
+	// overflowしないように最大値を引いてからexpを取る
+	max := q[argmax(q)]
+	p := make([]float64, len(q))
+	sum := 0.0
+	for i, v := range q {
+		p[i] = math.Exp((v - max) / s.Temperature)
+		sum += p[i]
+	}
+
+	x := s.rand.Float64() * sum
+	for i, v := range p {
+		x -= v
+		if x < 0 {
+			return i
+		}
+	}
+	return len(q) - 1
+}
+
+// ParseSelector is 文字列からSelectorを生成する
+// argmax, epsilon:{epsilon}, softmax:{temperature} を指定できる
+// ランダムな行動はプロセス共通の乱数から選ぶ
+func ParseSelector(s string) (Selector, error) {
+	kv := strings.SplitN(strings.TrimSpace(s), ":", 2)
+	switch kv[0] {
+	case "", "argmax":
+		return &ArgmaxSelector{}, nil
+	case "epsilon", "softmax":
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("selector parameter is required. selector = %s", s)
+		}
+		v, err := strconv.ParseFloat(kv[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("miss ParseFloat selector parameter = %s", kv[1])
+		}
+		if kv[0] == "epsilon" {
+			if v < 0 || v > 1 {
+				return nil, fmt.Errorf("epsilon must be between 0 and 1. epsilon = %f", v)
+			}
+			return &EpsilonGreedySelector{Epsilon: v}, nil
+		}
+		if v < 0 {
+			return nil, fmt.Errorf("temperature must not be negative. temperature = %f", v)
+		}
+		return &SoftmaxSelector{Temperature: v}, nil
+	default:
+		return nil, fmt.Errorf("unknown selector. selector = %s", s)
+	}
+}
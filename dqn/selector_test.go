@@ -0,0 +1,108 @@
+package dqn
+
+import "testing"
+
+func TestArgmaxSelector(t *testing.T) {
+	candidates := []struct {
+		q        []float64
+		expected int
+	}{
+		{q: []float64{1, 0, 0, 0, 0}, expected: actionNone},
+		{q: []float64{0, 0, 0, 0, 1}, expected: actionDown},
+		// 同じQ Scoreの場合は添字が小さい方
+		{q: []float64{0, 1, 1, 0, 0}, expected: actionLeft},
+		{q: []float64{0, 0, 0, 1, 1}, expected: actionUp},
+		{q: []float64{0, 0, 0, 0, 0}, expected: actionNone},
+	}
+
+	s := &ArgmaxSelector{}
+	for i, v := range candidates {
+		if e, g := v.expected, s.Select(v.q); e != g {
+			t.Fatalf("%d : expected %d; got %d", i, e, g)
+		}
+	}
+}
+
+func TestEpsilonGreedySelector(t *testing.T) {
+	q := []float64{0, 0, 1, 0, 0}
+
+	s := NewEpsilonGreedySelector(0, 1)
+	for i := 0; i < 100; i++ {
+		if e, g := actionRight, s.Select(q); e != g {
+			t.Fatalf("expected %d; got %d", e, g)
+		}
+	}
+
+	s = NewEpsilonGreedySelector(1, 1)
+	counts := make(map[int]int)
+	for i := 0; i < 1000; i++ {
+		counts[s.Select(q)]++
+	}
+	for action := 0; action < actionCount; action++ {
+		if counts[action] == 0 {
+			t.Fatalf("expected action %d is selected. counts = %+v", action, counts)
+		}
+	}
+}
+
+func TestSoftmaxSelector(t *testing.T) {
+	q := []float64{0, 0, 10, 0, 0}
+
+	// Temperatureが低い場合はほぼArgmaxと同じ
+	s := NewSoftmaxSelector(0.01, 1)
+	for i := 0; i < 100; i++ {
+		if e, g := actionRight, s.Select(q); e != g {
+			t.Fatalf("expected %d; got %d", e, g)
+		}
+	}
+
+	// Temperatureが高い場合は全ての行動が選ばれる
+	s = NewSoftmaxSelector(1000, 1)
+	counts := make(map[int]int)
+	for i := 0; i < 1000; i++ {
+		counts[s.Select(q)]++
+	}
+	for action := 0; action < actionCount; action++ {
+		if counts[action] == 0 {
+			t.Fatalf("expected action %d is selected. counts = %+v", action, counts)
+		}
+	}
+
+	// 大きなQ Scoreでもoverflowしない
+	s = NewSoftmaxSelector(1, 1)
+	if e, g := actionLeft, s.Select([]float64{0, 1e10, 0, 0, 0}); e != g {
+		t.Fatalf("expected %d; got %d", e, g)
+	}
+}
+
+func TestParseSelector(t *testing.T) {
+	candidates := []struct {
+		s   string
+		err bool
+	}{
+		{s: ""},
+		{s: "argmax"},
+		{s: "epsilon:0.1"},
+		{s: "softmax:0.5"},
+		{s: "epsilon", err: true},
+		{s: "epsilon:1.5", err: true},
+		{s: "softmax:-1", err: true},
+		{s: "softmax:hoge", err: true},
+		{s: "hoge", err: true},
+	}
+
+	for i, v := range candidates {
+		s, err := ParseSelector(v.s)
+		if v.err {
+			if err == nil {
+				t.Fatalf("%d : expected error", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%d : failed ParseSelector. err = %+v", i, err)
+		}
+		// プロセス共通の乱数でも動く
+		s.Select([]float64{0, 1, 2, 3, 4})
+	}
+}
@@ -0,0 +1,121 @@
+#!/usr/bin/env python3
+"""Regenerate the expected responses in testdata/reference from testdata/model.json.
+
+model.json has small random weights and is not a trained model. The files in
+reference/ hold hand-written payloads. Their "response" is computed by this
+script with its own pure Python forward pass (TensorFlow semantics for "same"
+padding). They are not recorded dqn-service output, so the test only guards
+LocalClient against regressions and does not prove it matches dqn-service.
+To compare with the real service, export the production model, record
+payload/response pairs from dqn-service, and run the test with
+DQN_REFERENCE_MODEL and DQN_REFERENCE_DIR.
+
+Usage: python3 testdata/gen_reference.py  (run from the dqn directory)
+"""
+
+import glob
+import json
+import math
+import os
+
+HERE = os.path.dirname(os.path.abspath(__file__))
+
+
+def conv2d(x, layer):
+    kernel = layer["kernel"]
+    bias = layer["bias"]
+    stride = layer.get("stride") or 1
+    kh, kw = len(kernel), len(kernel[0])
+    h, w = len(x), len(x[0])
+    filters = len(bias)
+    if layer.get("padding") == "same":
+        oh = (h + stride - 1) // stride
+        ow = (w + stride - 1) // stride
+        pad_top = max((oh - 1) * stride + kh - h, 0) // 2
+        pad_left = max((ow - 1) * stride + kw - w, 0) // 2
+    else:
+        oh = (h - kh) // stride + 1
+        ow = (w - kw) // stride + 1
+        pad_top = pad_left = 0
+
+    out = []
+    for row in range(oh):
+        out_row = []
+        for col in range(ow):
+            cell = []
+            for f in range(filters):
+                s = bias[f]
+                for i in range(kh):
+                    r = row * stride + i - pad_top
+                    if r < 0 or r >= h:
+                        continue
+                    for j in range(kw):
+                        c = col * stride + j - pad_left
+                        if c < 0 or c >= w:
+                            continue
+                        for ch, v in enumerate(x[r][c]):
+                            s += v * kernel[i][j][ch][f]
+                cell.append(s)
+            out_row.append(cell)
+        out.append(out_row)
+    return out
+
+
+def flatten(x):
+    if isinstance(x[0], list):
+        return [v for row in x for cell in row for v in cell]
+    return x
+
+
+def dense(x, layer):
+    out = list(layer["bias"])
+    for i, v in enumerate(flatten(x)):
+        for u in range(len(out)):
+            out[u] += v * layer["weights"][i][u]
+    return out
+
+
+def activate(x, activation):
+    if isinstance(x[0], list):
+        return [activate(v, activation) for v in x]
+    if activation == "relu":
+        return [max(v, 0.0) for v in x]
+    if activation == "tanh":
+        return [math.tanh(v) for v in x]
+    return x
+
+
+def q(model, state):
+    x = state
+    for layer in model["layers"]:
+        if layer["type"] == "conv2d":
+            x = conv2d(x, layer)
+        elif layer["type"] == "dense":
+            x = dense(x, layer)
+        elif layer["type"] == "flatten":
+            x = flatten(x)
+        else:
+            raise ValueError("unknown layer type %s" % layer["type"])
+        x = activate(x, layer.get("activation"))
+    return flatten(x)
+
+
+def main():
+    with open(os.path.join(HERE, "model.json")) as f:
+        model = json.load(f)
+    for path in sorted(glob.glob(os.path.join(HERE, "reference", "*.json"))):
+        with open(path) as f:
+            record = json.load(f)
+        record["response"] = {
+            "predictions": [
+                {"q": q(model, i["state"]), "key": i["key"]}
+                for i in record["payload"]["instances"]
+            ]
+        }
+        with open(path, "w") as f:
+            json.dump(record, f, indent=1)
+            f.write("\n")
+
+
+if __name__ == "__main__":
+    main()
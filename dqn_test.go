@@ -8,9 +8,10 @@ import (
 
 // DQNDummyClient is UnitTestのためのDQN Dummy実装
 type DQNDummyClient struct {
-	PredictionCount int
-	Body            *dqn.Payload
-	DummyAnswer     *dqn.Answer
+	PredictionCount      int
+	BatchPredictionCount int
+	Body                 *dqn.Payload
+	DummyAnswer          *dqn.Answer
 }
 
 func (client *DQNDummyClient) Prediction(ctx context.Context, body *dqn.Payload) (*dqn.Answer, error) {
@@ -18,3 +19,13 @@ func (client *DQNDummyClient) Prediction(ctx context.Context, body *dqn.Payload)
 	client.Body = body
 	return client.DummyAnswer, nil
 }
+
+func (client *DQNDummyClient) BatchPrediction(ctx context.Context, body *dqn.Payload) (map[int]*dqn.Answer, error) {
+	client.BatchPredictionCount++
+	client.Body = body
+	answers := make(map[int]*dqn.Answer)
+	for _, v := range body.Instances {
+		answers[v.Key] = client.DummyAnswer
+	}
+	return answers, nil
+}
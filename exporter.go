@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"contrib.go.opencensus.io/exporter/stackdriver"
+	"go.opencensus.io/trace"
+)
+
+const (
+	// TraceExporterStackdriver is Stackdriver TraceにTraceを送る
+	TraceExporterStackdriver = "stackdriver"
+	// TraceExporterStdout is 標準出力にTraceを書き出す
+	TraceExporterStdout = "stdout"
+	// TraceExporterNone is Traceを書き出さない
+	TraceExporterNone = "none"
+)
+
+// traceExporter is 終了時にFlushできるtrace.Exporter
+type traceExporter interface {
+	trace.Exporter
+	Flush()
+}
+
+// newTraceExporter is 指定した種類のtraceExporterを生成する
+// TraceExporterNone の場合はnilを返す
+func newTraceExporter(kind string, projectID string) (traceExporter, error) {
+	switch kind {
+	case TraceExporterStackdriver:
+		return stackdriver.NewExporter(stackdriver.Options{
+			ProjectID: projectID,
+		})
+	case TraceExporterStdout:
+		return &stdoutExporter{w: os.Stdout}, nil
+	case TraceExporterNone:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unknown trace exporter. kind = %s", kind)
+	}
+}
+
+// stdoutExporter is ローカル開発用に、Spanを1行のJSONで書き出すExporter
+type stdoutExporter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// stdoutSpan is stdoutExporterが書き出すSpanの内容
+type stdoutSpan struct {
+	Name         string  `json:"name"`
+	TraceID      string  `json:"traceId"`
+	SpanID       string  `json:"spanId"`
+	ParentSpanID string  `json:"parentSpanId,omitempty"`
+	StartTime    string  `json:"startTime"`
+	DurationMs   float64 `json:"durationMs"`
+	StatusCode   int32   `json:"statusCode"`
+	Message      string  `json:"message,omitempty"`
+}
+
+// ExportSpan is Spanを書き出す
+func (e *stdoutExporter) ExportSpan(sd *trace.SpanData) {
+	s := stdoutSpan{
+		Name:       sd.Name,
+		TraceID:    sd.TraceID.String(),
+		SpanID:     sd.SpanID.String(),
+		StartTime:  sd.StartTime.Format(time.RFC3339Nano),
+		DurationMs: float64(sd.EndTime.Sub(sd.StartTime)) / float64(time.Millisecond),
+		StatusCode: sd.Status.Code,
+		Message:    sd.Status.Message,
+	}
+	if sd.ParentSpanID != (trace.SpanID{}) {
+		s.ParentSpanID = sd.ParentSpanID.String()
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := json.NewEncoder(e.w).Encode(s); err != nil {
+		fmt.Printf("failed stdoutExporter.ExportSpan. %+v\n", err)
+	}
+}
+
+// Flush is 書き出しは都度行っているので何もしない
+func (e *stdoutExporter) Flush() {}
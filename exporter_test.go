@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"go.opencensus.io/trace"
+)
+
+func TestStdoutExporter_ExportSpan(t *testing.T) {
+	var buf bytes.Buffer
+	e := &stdoutExporter{w: &buf}
+
+	start := time.Date(2018, 5, 1, 0, 0, 0, 0, time.UTC)
+	e.ExportSpan(&trace.SpanData{
+		SpanContext: trace.SpanContext{
+			TraceID: trace.TraceID{1},
+			SpanID:  trace.SpanID{2},
+		},
+		Name:      "/monster/handleMonsters",
+		StartTime: start,
+		EndTime:   start.Add(15 * time.Millisecond),
+	})
+
+	var s stdoutSpan
+	if err := json.Unmarshal(buf.Bytes(), &s); err != nil {
+		t.Fatalf("failed json.Unmarshal. err=%+v, body=%s", err, buf.String())
+	}
+	if e, g := "/monster/handleMonsters", s.Name; e != g {
+		t.Fatalf("expected Name is %s; got %s", e, g)
+	}
+	if e, g := 15.0, s.DurationMs; e != g {
+		t.Fatalf("expected DurationMs is %f; got %f", e, g)
+	}
+	if e, g := "", s.ParentSpanID; e != g {
+		t.Fatalf("expected ParentSpanID is empty; got %s", g)
+	}
+}
+
+func TestNewTraceExporter(t *testing.T) {
+	e, err := newTraceExporter(TraceExporterNone, "")
+	if err != nil {
+		t.Fatalf("failed newTraceExporter. err=%+v", err)
+	}
+	if e != nil {
+		t.Fatalf("expected nil exporter; got %+v", e)
+	}
+
+	e, err = newTraceExporter(TraceExporterStdout, "")
+	if err != nil {
+		t.Fatalf("failed newTraceExporter. err=%+v", err)
+	}
+	if _, ok := e.(*stdoutExporter); !ok {
+		t.Fatalf("expected stdoutExporter; got %T", e)
+	}
+
+	if _, err := newTraceExporter("hoge", ""); err == nil {
+		t.Fatalf("expected error")
+	}
+}
@@ -1,13 +1,72 @@
 package main
 
-import "github.com/metal-tile/land/firedb"
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/metal-tile/land/firedb"
+)
 
 // ConvertXYToRowCol XY座標からマップの座標を割り出す
-// x -> col
-// y -> row
+// 計算は firedb.ConvertXYToRowCol に任せる
 func ConvertXYToRowCol(x float64, y float64, scale float64) (row int, col int) {
-	col = int(x / (firedb.MapChipWidth * scale))
-	row = int(y / (firedb.MapChipHeight * scale))
+	return firedb.ConvertXYToRowCol(x, y, scale)
+}
+
+// FieldSize is FieldStoreのFieldの縦幅と横幅を返す
+// FieldStoreがnilの場合は firedb.MapSizeRow, firedb.MapSizeCol を返す
+func FieldSize(fs firedb.FieldStore) (rows int, cols int) {
+	if fs == nil {
+		return firedb.MapSizeRow, firedb.MapSizeCol
+	}
+	return fs.Size()
+}
+
+// ParseChipPassability is `1:false,2:true` 形式の文字列から、ChipIDごとの通行可否のTableを組み立てる
+func ParseChipPassability(s string) (firedb.ChipPassability, error) {
+	p := firedb.ChipPassability{}
+	if strings.TrimSpace(s) == "" {
+		return p, nil
+	}
+	for _, v := range strings.Split(s, ",") {
+		kv := strings.Split(strings.TrimSpace(v), ":")
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("chip passability has an unexpected format. v = %s", v)
+		}
+		chipID, err := strconv.Atoi(kv[0])
+		if err != nil {
+			return nil, fmt.Errorf("miss Atoi chipID = %s", kv[0])
+		}
+		passable, err := strconv.ParseBool(kv[1])
+		if err != nil {
+			return nil, fmt.Errorf("miss ParseBool passable = %s", kv[1])
+		}
+		p[chipID] = passable
+	}
+	return p, nil
+}
 
-	return
+// ParseChipReplacement is `10:1,11:1` 形式の文字列から、壊れたChipを置き換えるChipIDのTableを組み立てる
+func ParseChipReplacement(s string) (firedb.ChipReplacement, error) {
+	r := firedb.ChipReplacement{}
+	if strings.TrimSpace(s) == "" {
+		return r, nil
+	}
+	for _, v := range strings.Split(s, ",") {
+		kv := strings.Split(strings.TrimSpace(v), ":")
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("chip replacement has an unexpected format. v = %s", v)
+		}
+		chipID, err := strconv.Atoi(kv[0])
+		if err != nil {
+			return nil, fmt.Errorf("miss Atoi chipID = %s", kv[0])
+		}
+		replacement, err := strconv.Atoi(kv[1])
+		if err != nil {
+			return nil, fmt.Errorf("miss Atoi replacement = %s", kv[1])
+		}
+		r[chipID] = replacement
+	}
+	return r, nil
 }
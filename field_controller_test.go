@@ -11,3 +11,95 @@ func TestConvertXYToRowCol(t *testing.T) {
 		t.Fatalf("expected row is %d; got %d", e, g)
 	}
 }
+
+func TestParseChipPassability(t *testing.T) {
+	candidates := []struct {
+		s        string
+		expected map[int]bool
+		err      bool
+	}{
+		{
+			s:        "",
+			expected: map[int]bool{},
+		},
+		{
+			s:        "1:false, 2:true",
+			expected: map[int]bool{1: false, 2: true},
+		},
+		{
+			s:   "1",
+			err: true,
+		},
+		{
+			s:   "a:false",
+			err: true,
+		},
+	}
+
+	for i, v := range candidates {
+		p, err := ParseChipPassability(v.s)
+		if v.err {
+			if err == nil {
+				t.Fatalf("%d : expected error", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%d : failed ParseChipPassability. err=%+v", i, err)
+		}
+		if e, g := len(v.expected), len(p); e != g {
+			t.Fatalf("%d : expected len is %d; got %d", i, e, g)
+		}
+		for k, passable := range v.expected {
+			if e, g := passable, p[k]; e != g {
+				t.Fatalf("%d : expected %d is %t; got %t", i, k, e, g)
+			}
+		}
+	}
+}
+
+func TestParseChipReplacement(t *testing.T) {
+	candidates := []struct {
+		s        string
+		expected map[int]int
+		err      bool
+	}{
+		{
+			s:        "",
+			expected: map[int]int{},
+		},
+		{
+			s:        "10:1, 11:2",
+			expected: map[int]int{10: 1, 11: 2},
+		},
+		{
+			s:   "10",
+			err: true,
+		},
+		{
+			s:   "10:a",
+			err: true,
+		},
+	}
+
+	for i, v := range candidates {
+		r, err := ParseChipReplacement(v.s)
+		if v.err {
+			if err == nil {
+				t.Fatalf("%d : expected error", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%d : failed ParseChipReplacement. err=%+v", i, err)
+		}
+		if e, g := len(v.expected), len(r); e != g {
+			t.Fatalf("%d : expected len is %d; got %d", i, e, g)
+		}
+		for k, chipID := range v.expected {
+			if e, g := chipID, r[k]; e != g {
+				t.Fatalf("%d : expected %d is %d; got %d", i, k, e, g)
+			}
+		}
+	}
+}
@@ -6,9 +6,86 @@ import (
 	"strconv"
 
 	"github.com/metal-tile/land/firedb"
+	"github.com/pkg/errors"
 )
 
-func fieldHandler(w http.ResponseWriter, r *http.Request) {
+// fieldHandler is FieldStoreの値の確認と、Field Collectionへの書き込みを行うHandler
+// GET  /field?row={row}&col={col} : 指定したChipを返す
+// POST /field?row={row}&col={col}&chip={chip}&hitPoint={hitPoint}&rows={rows}&cols={cols} : (row, col) を左上として、rows x cols (default 1 x 1) の範囲のChipを書き込む
+// 複数のLandを動かしている場合は `land={land}` で対象のLandを指定する
+func fieldHandler(lands *LandManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		land, err := lands.Resolve(r.FormValue("land"))
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintf(w, "%s", err)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			handleField(w, r, land)
+		case http.MethodPost:
+			handleFieldWrite(w, r, land)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func handleFieldWrite(w http.ResponseWriter, r *http.Request, land *Land) {
+	params := map[string]int{"row": 0, "col": 0, "chip": 0, "rows": 1, "cols": 1}
+	for _, k := range []string{"row", "col", "chip", "rows", "cols"} {
+		p := r.FormValue(k)
+		if p == "" {
+			if k == "rows" || k == "cols" {
+				continue
+			}
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "%s is required", k)
+			return
+		}
+		v, err := strconv.Atoi(p)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "%s is %s", k, err)
+			return
+		}
+		params[k] = v
+	}
+	var hp float64
+	if p := r.FormValue("hitPoint"); p != "" {
+		v, err := strconv.ParseFloat(p, 64)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "hitPoint is %s", err)
+			return
+		}
+		hp = v
+	}
+
+	row, col := params["row"], params["col"]
+	rows, cols := params["rows"], params["cols"]
+	v := &firedb.FieldValue{Row: row, Col: col, ChipID: params["chip"], HitPoint: hp}
+	var err error
+	if rows == 1 && cols == 1 {
+		err = land.FieldStore.WriteValue(r.Context(), land.World.FieldPath(), v)
+	} else {
+		err = land.FieldStore.FillRect(r.Context(), land.World.FieldPath(), row, col, rows, cols, v)
+	}
+	if errors.Cause(err) == firedb.ErrOutOfBounds {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "%s", err)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "%s", err)
+		return
+	}
+	fmt.Fprintf(w, "%d:%d %dx%d chip=%d hitPoint=%f is written", row, col, rows, cols, v.ChipID, v.HitPoint)
+}
+
+func handleField(w http.ResponseWriter, r *http.Request, land *Land) {
 	rowParam := r.FormValue("row")
 	colParam := r.FormValue("col")
 
@@ -18,6 +95,7 @@ func fieldHandler(w http.ResponseWriter, r *http.Request) {
 		if err != nil {
 			w.WriteHeader(http.StatusBadRequest)
 			fmt.Fprintf(w, "row is %s", err)
+			return
 		}
 		row = ro
 	}
@@ -28,15 +106,72 @@ func fieldHandler(w http.ResponseWriter, r *http.Request) {
 		if err != nil {
 			w.WriteHeader(http.StatusBadRequest)
 			fmt.Fprintf(w, "col is %s", err)
+			return
 		}
-		row = co
+		col = co
 	}
 
-	fs := firedb.NewFieldStore()
-	v, err := fs.GetValue(row, col)
+	v, err := land.FieldStore.GetValue(row, col)
+	if errors.Cause(err) == firedb.ErrOutOfBounds {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "%s", err)
+		return
+	}
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		fmt.Fprintf(w, "%s", err)
+		return
 	}
 	fmt.Fprintf(w, "%d:%d %+v", row, col, v)
 }
+
+// fieldDamageHandler is Chipにダメージを与えるHandler
+// POST /field/damage?row={row}&col={col}&amount={amount} : ダメージを受けた後のChipを返す
+// 複数のLandを動かしている場合は `land={land}` で対象のLandを指定する
+func fieldDamageHandler(lands *LandManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		land, err := lands.Resolve(r.FormValue("land"))
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintf(w, "%s", err)
+			return
+		}
+		row, err := strconv.Atoi(r.FormValue("row"))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "row is %s", err)
+			return
+		}
+		col, err := strconv.Atoi(r.FormValue("col"))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "col is %s", err)
+			return
+		}
+		amount, err := strconv.ParseFloat(r.FormValue("amount"), 64)
+		if err != nil || amount <= 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "amount must be a positive number. amount = %s", r.FormValue("amount"))
+			return
+		}
+
+		v, err := land.FieldStore.DamageChip(r.Context(), land.World.FieldPath(), row, col, amount, lands.ChipReplacement)
+		switch errors.Cause(err) {
+		case nil:
+			fmt.Fprintf(w, "%d:%d %+v", row, col, v)
+		case firedb.ErrChipNotFound:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintf(w, "%s", err)
+		case firedb.ErrChipIndestructible, firedb.ErrOutOfBounds:
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "%s", err)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintf(w, "%s", err)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package firedb
+
+import (
+	"context"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+// DamageTargetKind is ダメージを受けたものの種類
+type DamageTargetKind string
+
+const (
+	// DamageTargetPlayer is Monsterからプレイヤーへのダメージ
+	DamageTargetPlayer DamageTargetKind = "player"
+
+	// DamageTargetMonster is プレイヤーなどからMonsterへのダメージ
+	DamageTargetMonster DamageTargetKind = "monster"
+)
+
+// DamageEvent is landが判定したダメージの記録
+// Clientはこのイベントをダメージの正とする
+type DamageEvent struct {
+	ID string `firestore:"-" json:"id"`
+
+	// Attacker is ダメージを与えたもののID
+	Attacker string `firestore:"attacker" json:"attacker"`
+
+	// Target is ダメージを受けたもののID
+	Target     string           `firestore:"target" json:"target"`
+	TargetKind DamageTargetKind `firestore:"targetKind" json:"targetKind"`
+
+	Amount float64 `firestore:"amount" json:"amount"`
+
+	// HitPoint is ダメージを受けた後の残りのHP。landがHPを管理しているMonsterの場合だけ設定する
+	HitPoint float64 `firestore:"hitPoint" json:"hitPoint"`
+
+	// Killed is このダメージでTargetが倒れたかどうか
+	Killed bool `firestore:"killed" json:"killed"`
+
+	// Row, Col is ダメージを受けたもののTile
+	Row int `firestore:"row" json:"row"`
+	Col int `firestore:"col" json:"col"`
+
+	CreatedAt time.Time `firestore:"createdAt" json:"createdAt"`
+}
+
+// DamageStore is DamageEventに関するFirestoreとのやりとりの役割を持つ
+type DamageStore interface {
+	AddEvents(ctx context.Context, l []*DamageEvent) (int, error)
+}
+
+type damageStoreImple struct {
+	world *World
+}
+
+var damageStore DamageStore
+
+// NewDamageStoreWithWorld is 指定したWorldのCollectionを扱うDamageStoreを生成する
+// SetDamageStoreで差し替えられている場合は、差し替えた実装を返す
+func NewDamageStoreWithWorld(w *World) DamageStore {
+	if damageStore != nil {
+		return damageStore
+	}
+	return &damageStoreImple{world: w}
+}
+
+// SetDamageStore is DamageStoreの実装を差し替える
+// Unit Testのために利用する
+func SetDamageStore(s DamageStore) {
+	damageStore = s
+}
+
+// AddEvents is DamageEventを、WriteBatchでまとめて追加して、書き込めた数を返す
+// DocumentのIDは自動で割り当てて、DamageEventのIDに設定する
+// 途中のCommitで失敗した場合も、それより前にCommitできた先頭からの数を返す
+func (s *damageStoreImple) AddEvents(ctx context.Context, l []*DamageEvent) (int, error) {
+	col := db.Collection(s.world.DamagePath())
+	committed := 0
+	err := commitBatches(ctx, len(l), func(b *firestore.WriteBatch, start int, end int) {
+		for _, e := range l[start:end] {
+			ref := col.NewDoc()
+			e.ID = ref.ID
+			b.Create(ref, e)
+		}
+	}, func(start int, end int) error {
+		committed = end
+		return nil
+	})
+	return committed, err
+}
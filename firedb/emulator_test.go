@@ -0,0 +1,52 @@
+package firedb
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+// setUpEmulator is FIRESTORE_EMULATOR_HOST が設定されている場合に、Firestore Emulatorに接続する
+// 設定されていない場合はTestをSkipする
+// 他のTestと重ならないように、Test毎のCollectionのPathを返す
+func setUpEmulator(t *testing.T) (context.Context, string) {
+	if os.Getenv(EmulatorHostEnv) == "" {
+		t.Skipf("%s is not set", EmulatorHostEnv)
+	}
+	ctx := context.Background()
+	if err := SetUp(ctx, "metal-tile-test"); err != nil {
+		t.Fatalf("failed SetUp. err=%+v", err)
+	}
+	return ctx, fmt.Sprintf("test-%s-%d", t.Name(), time.Now().UnixNano())
+}
+
+func TestEmulatorClientOptions(t *testing.T) {
+	org, ok := os.LookupEnv(EmulatorHostEnv)
+	defer func() {
+		if ok {
+			os.Setenv(EmulatorHostEnv, org)
+		} else {
+			os.Unsetenv(EmulatorHostEnv)
+		}
+	}()
+
+	os.Unsetenv(EmulatorHostEnv)
+	opts, err := emulatorClientOptions()
+	if err != nil {
+		t.Fatalf("failed emulatorClientOptions. err=%+v", err)
+	}
+	if e, g := 0, len(opts); e != g {
+		t.Fatalf("expected len(opts) is %d; got %d", e, g)
+	}
+
+	os.Setenv(EmulatorHostEnv, "localhost:8812")
+	opts, err = emulatorClientOptions()
+	if err != nil {
+		t.Fatalf("failed emulatorClientOptions. err=%+v", err)
+	}
+	if e, g := 1, len(opts); e != g {
+		t.Fatalf("expected len(opts) is %d; got %d", e, g)
+	}
+}
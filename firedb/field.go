@@ -6,13 +6,20 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+
+	"cloud.google.com/go/firestore"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 const (
 	// MapSizeRow is Map縦幅
+	// Landのメタデータで指定されていない場合に使う
 	MapSizeRow = 200
 
 	// MapSizeCol is Map横幅
+	// Landのメタデータで指定されていない場合に使う
 	MapSizeCol = 255
 
 	// MapChipWidth マップチップ1つの幅
@@ -30,64 +37,165 @@ type FieldValue struct {
 	HitPoint float64 `firestore:"hitPoint"`
 }
 
+// ChipPassability is ChipIDごとに通行可能かどうかを表すTable
+// Tableに存在しないChipIDは通行可能として扱う
+type ChipPassability map[int]bool
+
+// IsObstacle is 指定したChipが障害物かどうかを返す
+// 通行不可のChipIDか、HitPointが残っている破壊可能なオブジェクトが置かれている場合に障害物とする
+// まだFirestoreから同期されていないChip(nil)は障害物として扱わない
+func (p ChipPassability) IsObstacle(v *FieldValue) bool {
+	if v == nil {
+		return false
+	}
+	if v.HitPoint > 0 {
+		return true
+	}
+	passable, ok := p[v.ChipID]
+	if !ok {
+		return false
+	}
+	return passable == false
+}
+
+// ErrOutOfBounds is Fieldの外のRowColを指定した時に利用する
+// 呼び出し元で判定する時は errors.Cause(err) == ErrOutOfBounds で比べる
+var ErrOutOfBounds = errors.New("field: out of bounds")
+
+// ErrChipNotFound is 指定したChipのDocumentがFirestoreに存在しない時に利用する
+var ErrChipNotFound = errors.New("field: chip not found")
+
+// ErrChipIndestructible is HitPointが残っていないChipにダメージを与えようとした時に利用する
+var ErrChipIndestructible = errors.New("field: chip is indestructible")
+
+// ChipReplacement is 壊れたChipを置き換えるChipIDのTable
+// Tableに存在しないChipIDは、HitPointが0になるだけでChipIDは変わらない
+type ChipReplacement map[int]int
+
+// Replace is 壊れたChipの置き換え先のChipIDを返す
+func (r ChipReplacement) Replace(chipID int) int {
+	if v, ok := r[chipID]; ok {
+		return v
+	}
+	return chipID
+}
+
+// ApplyDamage is ChipのHitPointをamountだけ減らす
+// HitPointが0以下になった場合は、HitPointを0にしてChipIDをreplacementで置き換え、trueを返す
+func (v *FieldValue) ApplyDamage(amount float64, replacement ChipReplacement) (bool, error) {
+	if amount <= 0 {
+		return false, fmt.Errorf("damage must be positive. amount = %f", amount)
+	}
+	if v.HitPoint <= 0 {
+		return false, ErrChipIndestructible
+	}
+	v.HitPoint -= amount
+	if v.HitPoint > 0 {
+		return false, nil
+	}
+	v.HitPoint = 0
+	v.ChipID = replacement.Replace(v.ChipID)
+	return true, nil
+}
+
 // FieldStore is FieldStore
 type FieldStore interface {
 	SetValue(row int, col int, v *FieldValue) error
 	GetValue(row int, col int) (*FieldValue, error)
 	Watch(ctx context.Context, path string) error
+	DamageChip(ctx context.Context, path string, row int, col int, amount float64, replacement ChipReplacement) (*FieldValue, error)
+	WriteValue(ctx context.Context, path string, v *FieldValue) error
+	FillRect(ctx context.Context, path string, row int, col int, rows int, cols int, v *FieldValue) error
+	Size() (rows int, cols int)
 }
 
 type defaultFieldStore struct {
 	mu    *sync.RWMutex
-	Field [MapSizeRow][MapSizeCol]*FieldValue
+	rows  int
+	cols  int
+	Field [][]*FieldValue
 }
 
 var fieldStore FieldStore
 
 // NewFieldStore is New FieldStore
+// プロセスで共通のFieldStoreを返す。Landごとに分ける場合は NewLandFieldStore を利用する
 func NewFieldStore() FieldStore {
 	if fieldStore == nil {
-		fieldStore = &defaultFieldStore{
-			mu: &sync.RWMutex{},
-		}
+		fieldStore = NewLandFieldStore()
 	}
 	return fieldStore
 }
 
+// NewLandFieldStore is 1つのLand用に、他と共有しない MapSizeRow x MapSizeCol のFieldStoreを生成する
+func NewLandFieldStore() FieldStore {
+	s, err := NewSizedFieldStore(MapSizeRow, MapSizeCol)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// NewSizedFieldStore is 1つのLand用に、他と共有しない rows x cols のFieldStoreを生成する
+// Landごとに大きさが違う場合は、Landのメタデータから大きさを決めて利用する
+func NewSizedFieldStore(rows int, cols int) (FieldStore, error) {
+	if rows < 1 || cols < 1 {
+		return nil, fmt.Errorf("field size must be positive. rows = %d, cols = %d", rows, cols)
+	}
+	field := make([][]*FieldValue, rows)
+	for i := range field {
+		field[i] = make([]*FieldValue, cols)
+	}
+	return &defaultFieldStore{
+		mu:    &sync.RWMutex{},
+		rows:  rows,
+		cols:  cols,
+		Field: field,
+	}, nil
+}
+
 // SetFieldStore is UnitTest時に実装を差し替えたいときに利用する
 func SetFieldStore(s FieldStore) {
 	fieldStore = s
 }
 
+// Size is Fieldの縦幅と横幅を返す
+func (s *defaultFieldStore) Size() (rows int, cols int) {
+	return s.rows, s.cols
+}
+
 func (s *defaultFieldStore) SetValue(row int, col int, v *FieldValue) error {
+	if err := CheckBounds(row, col, s.rows, s.cols); err != nil {
+		return err
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if row > len(s.Field) {
-		return fmt.Errorf("row : %d > filed.length : %d", row, len(s.Field))
-	}
-	if col > len(s.Field[row]) {
-		return fmt.Errorf("col : %d > filed[%d].length : %d", col, row, len(s.Field[row]))
-	}
-
 	s.Field[row][col] = v
 	return nil
 }
 
 func (s *defaultFieldStore) GetValue(row int, col int) (*FieldValue, error) {
+	if err := CheckBounds(row, col, s.rows, s.cols); err != nil {
+		return nil, err
+	}
+
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	if row > len(s.Field) {
-		return nil, fmt.Errorf("row : %d > filed.length : %d", row, len(s.Field))
-	}
-	if col > len(s.Field[row]) {
-		return nil, fmt.Errorf("col : %d > filed[%d].length : %d", col, row, len(s.Field[row]))
-	}
-
 	return s.Field[row][col], nil
 }
 
+// CheckBounds is (row, col) が rows x cols のFieldの中かを確認する
+// Fieldの外の場合は ErrOutOfBounds をWrapしたエラーを返す
+func CheckBounds(row int, col int, rows int, cols int) error {
+	if row < 0 || row >= rows || col < 0 || col >= cols {
+		return errors.Wrapf(ErrOutOfBounds, "row : %d, col : %d is out of field %d x %d", row, col, rows, cols)
+	}
+	return nil
+}
+
 func (s *defaultFieldStore) Watch(ctx context.Context, path string) error {
 	iter := db.Collection(path).Snapshots(ctx)
 	defer iter.Stop()
@@ -112,12 +220,119 @@ func (s *defaultFieldStore) Watch(ctx context.Context, path string) error {
 			fv.Row = row
 			fv.Col = col
 			if err := s.SetValue(row, col, &fv); err != nil {
+				if errors.Cause(err) == ErrOutOfBounds {
+					// Landの大きさより外側のChipは使わないので、読み飛ばす
+					continue
+				}
 				return err
 			}
 		}
 	}
 }
 
+// DamageChip is pathのField CollectionのChipにダメージを与えて、ダメージを受けた後のChipを返す
+// 複数のland podが同じChipに同時にダメージを与えても二重に反映されないように、Transactionの中で読み込んで書き込む
+// 書き込んだ値はWatchを待たずに、このFieldStoreにも反映する
+func (s *defaultFieldStore) DamageChip(ctx context.Context, path string, row int, col int, amount float64, replacement ChipReplacement) (*FieldValue, error) {
+	if err := CheckBounds(row, col, s.rows, s.cols); err != nil {
+		return nil, err
+	}
+
+	ref := db.Collection(path).Doc(FieldDocID(row, col))
+	var fv FieldValue
+	err := db.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if status.Code(err) == codes.NotFound {
+			return ErrChipNotFound
+		}
+		if err != nil {
+			return err
+		}
+		fv = FieldValue{}
+		if err := doc.DataTo(&fv); err != nil {
+			return err
+		}
+		if _, err := fv.ApplyDamage(amount, replacement); err != nil {
+			return err
+		}
+		return tx.Set(ref, fieldDocData(&fv), firestore.MergeAll)
+	})
+	if err != nil {
+		return nil, err
+	}
+	fv.Row = row
+	fv.Col = col
+	v := fv
+	if err := s.SetValue(row, col, &v); err != nil {
+		return nil, err
+	}
+	return &fv, nil
+}
+
+// WriteValue is v.Row, v.ColのChipを、pathのField Collectionに書き込む
+// 書き込んだ値はWatchを待たずに、このFieldStoreにも反映する
+func (s *defaultFieldStore) WriteValue(ctx context.Context, path string, v *FieldValue) error {
+	return s.FillRect(ctx, path, v.Row, v.Col, 1, 1, v)
+}
+
+// FillRect is (row, col) を左上として、rows x cols の範囲のChipを全てvのChipID, HitPointにして、pathのField Collectionに書き込む
+// WriteBatchでまとめて書き込み、1つのWriteBatchに入れられる数を超える場合はMaxBatchWrites毎に分けてCommitする
+// 途中のCommitで失敗した場合、それより前にCommitした範囲は書き込まれたままになる
+func (s *defaultFieldStore) FillRect(ctx context.Context, path string, row int, col int, rows int, cols int, v *FieldValue) error {
+	l, err := fieldRectValues(row, col, rows, cols, s.rows, s.cols, v)
+	if err != nil {
+		return err
+	}
+
+	c := db.Collection(path)
+	return commitBatches(ctx, len(l), func(b *firestore.WriteBatch, start int, end int) {
+		for _, fv := range l[start:end] {
+			b.Set(c.Doc(FieldDocID(fv.Row, fv.Col)), fieldDocData(fv), firestore.MergeAll)
+		}
+	}, func(start int, end int) error {
+		for _, fv := range l[start:end] {
+			if err := s.SetValue(fv.Row, fv.Col, fv); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// fieldRectValues is (row, col) を左上として、rows x cols の範囲のChipをvのChipID, HitPointで組み立てる
+// 範囲が fieldRows x fieldCols のFieldの外にはみ出る場合は ErrOutOfBounds をWrapしたエラーを返す
+func fieldRectValues(row int, col int, rows int, cols int, fieldRows int, fieldCols int, v *FieldValue) ([]*FieldValue, error) {
+	if rows < 1 || cols < 1 {
+		return nil, fmt.Errorf("rows and cols must be positive. rows = %d, cols = %d", rows, cols)
+	}
+	if row < 0 || col < 0 || row+rows > fieldRows || col+cols > fieldCols {
+		return nil, errors.Wrapf(ErrOutOfBounds, "rect row : %d, col : %d, rows : %d, cols : %d is out of field %d x %d", row, col, rows, cols, fieldRows, fieldCols)
+	}
+
+	l := make([]*FieldValue, 0, rows*cols)
+	for r := row; r < row+rows; r++ {
+		for c := col; c < col+cols; c++ {
+			l = append(l, &FieldValue{Row: r, Col: c, ChipID: v.ChipID, HitPoint: v.HitPoint})
+		}
+	}
+	return l, nil
+}
+
+// fieldDocData is Field CollectionのDocumentに書き込む値
+// Row, ColはDocumentのIDで表すので、Documentには書き込まない
+func fieldDocData(v *FieldValue) map[string]interface{} {
+	return map[string]interface{}{
+		"chip":     v.ChipID,
+		"hitPoint": v.HitPoint,
+	}
+}
+
+// FieldDocID is FieldのRowColから、Field CollectionのDocumentのID `row-000-col-000` を組み立てる
+// buildFieldRowCol と対になる
+func FieldDocID(row int, col int) string {
+	return fmt.Sprintf("row-%03d-col-%03d", row, col)
+}
+
 // buildFieldRowCol is Firestoreから送られてくるidから、FieldのRowColを抜き出す
 // FieldのKeyとして `row-000-col-000` という文字列を使っているので、それをばらしている
 func buildFieldRowCol(id string) (row int, col int, error error) {
@@ -0,0 +1,297 @@
+package firedb
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestChipPassability_IsObstacle(t *testing.T) {
+	p := ChipPassability{1: true, 2: false}
+
+	candidates := []struct {
+		v        *FieldValue
+		obstacle bool
+	}{
+		{v: nil, obstacle: false},
+		{v: &FieldValue{ChipID: 1}, obstacle: false},
+		{v: &FieldValue{ChipID: 2}, obstacle: true},
+		{v: &FieldValue{ChipID: 3}, obstacle: false},
+		{v: &FieldValue{ChipID: 1, HitPoint: 10}, obstacle: true},
+	}
+
+	for i, v := range candidates {
+		if e, g := v.obstacle, p.IsObstacle(v.v); e != g {
+			t.Fatalf("%d : expected %t; got %t", i, e, g)
+		}
+	}
+}
+
+func TestFieldValue_ApplyDamage(t *testing.T) {
+	r := ChipReplacement{10: 1}
+
+	candidates := []struct {
+		v         FieldValue
+		amount    float64
+		expected  FieldValue
+		destroyed bool
+		err       bool
+	}{
+		{v: FieldValue{ChipID: 10, HitPoint: 5}, amount: 3, expected: FieldValue{ChipID: 10, HitPoint: 2}},
+		{v: FieldValue{ChipID: 10, HitPoint: 5}, amount: 5, expected: FieldValue{ChipID: 1, HitPoint: 0}, destroyed: true},
+		{v: FieldValue{ChipID: 10, HitPoint: 5}, amount: 8, expected: FieldValue{ChipID: 1, HitPoint: 0}, destroyed: true},
+		// 置き換え先が無いChipは、ChipIDは変わらない
+		{v: FieldValue{ChipID: 11, HitPoint: 5}, amount: 8, expected: FieldValue{ChipID: 11, HitPoint: 0}, destroyed: true},
+		{v: FieldValue{ChipID: 10, HitPoint: 0}, amount: 1, expected: FieldValue{ChipID: 10, HitPoint: 0}, err: true},
+		{v: FieldValue{ChipID: 10, HitPoint: 5}, amount: 0, expected: FieldValue{ChipID: 10, HitPoint: 5}, err: true},
+	}
+
+	for i, v := range candidates {
+		fv := v.v
+		destroyed, err := fv.ApplyDamage(v.amount, r)
+		if e, g := v.err, err != nil; e != g {
+			t.Fatalf("%d : expected err %t; got %v", i, e, err)
+		}
+		if e, g := v.destroyed, destroyed; e != g {
+			t.Fatalf("%d : expected destroyed %t; got %t", i, e, g)
+		}
+		if e, g := v.expected, fv; e != g {
+			t.Fatalf("%d : expected %+v; got %+v", i, e, g)
+		}
+	}
+}
+
+func TestFieldDocID(t *testing.T) {
+	candidates := []struct {
+		row int
+		col int
+		id  string
+	}{
+		{row: 0, col: 0, id: "row-000-col-000"},
+		{row: 12, col: 3, id: "row-012-col-003"},
+		{row: 199, col: 254, id: "row-199-col-254"},
+	}
+
+	for i, v := range candidates {
+		id := FieldDocID(v.row, v.col)
+		if e, g := v.id, id; e != g {
+			t.Fatalf("%d : expected %s; got %s", i, e, g)
+		}
+		row, col, err := buildFieldRowCol(id)
+		if err != nil {
+			t.Fatalf("%d : failed buildFieldRowCol. err=%+v", i, err)
+		}
+		if row != v.row || col != v.col {
+			t.Fatalf("%d : expected %d:%d; got %d:%d", i, v.row, v.col, row, col)
+		}
+	}
+}
+
+func TestFieldRectValues(t *testing.T) {
+	v := &FieldValue{ChipID: 3, HitPoint: 10}
+
+	candidates := []struct {
+		row  int
+		col  int
+		rows int
+		cols int
+		ids  []string
+		err  bool
+	}{
+		{row: 0, col: 0, rows: 1, cols: 1, ids: []string{"row-000-col-000"}},
+		{row: 10, col: 20, rows: 2, cols: 2, ids: []string{"row-010-col-020", "row-010-col-021", "row-011-col-020", "row-011-col-021"}},
+		{row: MapSizeRow - 1, col: MapSizeCol - 1, rows: 1, cols: 1, ids: []string{"row-199-col-254"}},
+		{row: MapSizeRow - 1, col: 0, rows: 2, cols: 1, err: true},
+		{row: 0, col: MapSizeCol - 1, rows: 1, cols: 2, err: true},
+		{row: -1, col: 0, rows: 1, cols: 1, err: true},
+		{row: 0, col: 0, rows: 0, cols: 1, err: true},
+	}
+
+	for i, c := range candidates {
+		l, err := fieldRectValues(c.row, c.col, c.rows, c.cols, MapSizeRow, MapSizeCol, v)
+		if e, g := c.err, err != nil; e != g {
+			t.Fatalf("%d : expected err %t; got %v", i, e, err)
+		}
+		if err != nil && c.rows > 0 && errors.Cause(err) != ErrOutOfBounds {
+			t.Fatalf("%d : expected ErrOutOfBounds; got %v", i, err)
+		}
+		if e, g := len(c.ids), len(l); e != g {
+			t.Fatalf("%d : expected len is %d; got %d", i, e, g)
+		}
+		for j, fv := range l {
+			if e, g := c.ids[j], FieldDocID(fv.Row, fv.Col); e != g {
+				t.Fatalf("%d : expected [%d] is %s; got %s", i, j, e, g)
+			}
+			if fv.ChipID != v.ChipID || fv.HitPoint != v.HitPoint {
+				t.Fatalf("%d : expected [%d] is %+v; got %+v", i, j, v, fv)
+			}
+		}
+	}
+
+	// 全てのChipは別のFieldValueなので、後から書き換えても他のChipに影響しない
+	l, _ := fieldRectValues(0, 0, 1, 2, MapSizeRow, MapSizeCol, v)
+	l[0].ChipID = 100
+	if e, g := 3, l[1].ChipID; e != g {
+		t.Fatalf("expected ChipID is %d; got %d", e, g)
+	}
+}
+
+func TestDefaultFieldStore_Bounds(t *testing.T) {
+	s := NewLandFieldStore()
+	if e, g := MapSizeRow, len(s.(*defaultFieldStore).Field); e != g {
+		t.Fatalf("expected rows is %d; got %d", e, g)
+	}
+
+	candidates := []struct {
+		row int
+		col int
+		ok  bool
+	}{
+		{row: 0, col: 0, ok: true},
+		{row: MapSizeRow - 1, col: MapSizeCol - 1, ok: true},
+		{row: MapSizeRow, col: 0, ok: false},
+		{row: 0, col: MapSizeCol, ok: false},
+		{row: -1, col: 0, ok: false},
+		{row: 0, col: -1, ok: false},
+	}
+
+	for i, c := range candidates {
+		v := &FieldValue{Row: c.row, Col: c.col, ChipID: i}
+		err := s.SetValue(c.row, c.col, v)
+		if c.ok {
+			if err != nil {
+				t.Fatalf("%d : failed SetValue. err=%+v", i, err)
+			}
+		} else if errors.Cause(err) != ErrOutOfBounds {
+			t.Fatalf("%d : expected SetValue returns ErrOutOfBounds; got %v", i, err)
+		}
+
+		g, err := s.GetValue(c.row, c.col)
+		if c.ok {
+			if err != nil {
+				t.Fatalf("%d : failed GetValue. err=%+v", i, err)
+			}
+			if g != v {
+				t.Fatalf("%d : expected %+v; got %+v", i, v, g)
+			}
+		} else if errors.Cause(err) != ErrOutOfBounds {
+			t.Fatalf("%d : expected GetValue returns ErrOutOfBounds; got %v", i, err)
+		}
+	}
+}
+
+func TestNewSizedFieldStore(t *testing.T) {
+	s, err := NewSizedFieldStore(30, 40)
+	if err != nil {
+		t.Fatalf("failed NewSizedFieldStore. err=%+v", err)
+	}
+	rows, cols := s.Size()
+	if e, g := 30, rows; e != g {
+		t.Fatalf("expected rows is %d; got %d", e, g)
+	}
+	if e, g := 40, cols; e != g {
+		t.Fatalf("expected cols is %d; got %d", e, g)
+	}
+	if err := s.SetValue(29, 39, &FieldValue{Row: 29, Col: 39}); err != nil {
+		t.Fatalf("failed SetValue. err=%+v", err)
+	}
+	if _, err := s.GetValue(30, 0); errors.Cause(err) != ErrOutOfBounds {
+		t.Fatalf("expected ErrOutOfBounds; got %v", err)
+	}
+	if _, err := s.GetValue(0, 40); errors.Cause(err) != ErrOutOfBounds {
+		t.Fatalf("expected ErrOutOfBounds; got %v", err)
+	}
+
+	for _, size := range [][2]int{{0, 10}, {10, 0}, {-1, 10}} {
+		if _, err := NewSizedFieldStore(size[0], size[1]); err == nil {
+			t.Fatalf("expected error for size %v", size)
+		}
+	}
+}
+
+func TestDefaultFieldStore_DamageChipConcurrently(t *testing.T) {
+	ctx, path := setUpEmulator(t)
+
+	s, err := NewSizedFieldStore(10, 10)
+	if err != nil {
+		t.Fatalf("failed NewSizedFieldStore. err=%+v", err)
+	}
+	if err := s.WriteValue(ctx, path, &FieldValue{Row: 3, Col: 4, ChipID: 1, HitPoint: 100}); err != nil {
+		t.Fatalf("failed WriteValue. err=%+v", err)
+	}
+
+	// 複数のland podが同じChipに同時にダメージを与えても、全てのダメージが1回ずつ反映される
+	const n = 10
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := s.DamageChip(ctx, path, 3, 4, 3, ChipReplacement{1: 2}); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("failed DamageChip. err=%+v", err)
+	}
+
+	doc, err := db.Collection(path).Doc(FieldDocID(3, 4)).Get(ctx)
+	if err != nil {
+		t.Fatalf("failed Get. err=%+v", err)
+	}
+	var fv FieldValue
+	if err := doc.DataTo(&fv); err != nil {
+		t.Fatalf("failed DataTo. err=%+v", err)
+	}
+	if e, g := 100.0-3*n, fv.HitPoint; e != g {
+		t.Fatalf("expected HitPoint is %f; got %f", e, g)
+	}
+	if e, g := 1, fv.ChipID; e != g {
+		t.Fatalf("expected ChipID is %d; got %d", e, g)
+	}
+}
+
+func TestDefaultFieldStore_FillRectOverMaxBatchWrites(t *testing.T) {
+	ctx, path := setUpEmulator(t)
+
+	s, err := NewSizedFieldStore(30, 30)
+	if err != nil {
+		t.Fatalf("failed NewSizedFieldStore. err=%+v", err)
+	}
+	// 30 x 20 = 600 Chipなので、MaxBatchWritesを超えて2回に分けてCommitする
+	rows, cols := 30, 20
+	if err := s.FillRect(ctx, path, 0, 0, rows, cols, &FieldValue{ChipID: 5, HitPoint: 10}); err != nil {
+		t.Fatalf("failed FillRect. err=%+v", err)
+	}
+
+	docs, err := db.Collection(path).Documents(ctx).GetAll()
+	if err != nil {
+		t.Fatalf("failed GetAll. err=%+v", err)
+	}
+	if e, g := rows*cols, len(docs); e != g {
+		t.Fatalf("expected %d documents; got %d", e, g)
+	}
+	for _, doc := range docs {
+		var fv FieldValue
+		if err := doc.DataTo(&fv); err != nil {
+			t.Fatalf("failed DataTo. err=%+v", err)
+		}
+		if fv.ChipID != 5 || fv.HitPoint != 10 {
+			t.Fatalf("%s : expected ChipID 5, HitPoint 10; got %+v", doc.Ref.ID, fv)
+		}
+	}
+
+	// 2回目のCommitで書き込んだChipも、このFieldStoreに反映されている
+	v, err := s.GetValue(rows-1, cols-1)
+	if err != nil {
+		t.Fatalf("failed GetValue. err=%+v", err)
+	}
+	if e, g := 5, v.ChipID; e != g {
+		t.Fatalf("expected ChipID is %d; got %d", e, g)
+	}
+}
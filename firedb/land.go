@@ -0,0 +1,74 @@
+package firedb
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// LandMetadata is Landごとの設定
+// Documentは `world-{world}-lands/{land}` に置く
+type LandMetadata struct {
+	ID string `firestore:"-" json:"id"`
+
+	// Rows, Cols is LandのFieldの縦幅と横幅。0の場合は MapSizeRow, MapSizeCol を使う
+	Rows int `firestore:"rows" json:"rows"`
+	Cols int `firestore:"cols" json:"cols"`
+}
+
+// Validate is LandMetadataとして使えるかを確認する
+func (m *LandMetadata) Validate() error {
+	if m.Rows < 0 || m.Cols < 0 {
+		return fmt.Errorf("land %s size must not be negative. rows = %d, cols = %d", m.ID, m.Rows, m.Cols)
+	}
+	return nil
+}
+
+// LandStore is Landのメタデータに関するFirestoreとのやりとりの役割を持つ
+type LandStore interface {
+	GetMetadata(ctx context.Context) (*LandMetadata, error)
+}
+
+type landStoreImple struct {
+	world *World
+}
+
+var landStore LandStore
+
+// NewLandStoreWithWorld is 指定したWorldのLandのメタデータを扱うLandStoreを生成する
+// SetLandStoreで差し替えられている場合は、差し替えた実装を返す
+func NewLandStoreWithWorld(w *World) LandStore {
+	if landStore != nil {
+		return landStore
+	}
+	return &landStoreImple{world: w}
+}
+
+// SetLandStore is LandStoreの実装を差し替える
+// Unit Testのために利用する
+func SetLandStore(s LandStore) {
+	landStore = s
+}
+
+// GetMetadata is Landのメタデータを取得する
+// Documentが存在しない場合は、全ての値を既定値にしたメタデータを返す
+func (s *landStoreImple) GetMetadata(ctx context.Context) (*LandMetadata, error) {
+	doc, err := db.Doc(s.world.LandDocPath()).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return &LandMetadata{ID: s.world.LandID}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var m LandMetadata
+	if err := doc.DataTo(&m); err != nil {
+		return nil, err
+	}
+	m.ID = doc.Ref.ID
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
@@ -0,0 +1,22 @@
+package firedb
+
+import "testing"
+
+func TestLandMetadata_Validate(t *testing.T) {
+	candidates := []struct {
+		meta  *LandMetadata
+		valid bool
+	}{
+		{meta: &LandMetadata{ID: "home"}, valid: true},
+		{meta: &LandMetadata{ID: "home", Rows: 30, Cols: 40}, valid: true},
+		{meta: &LandMetadata{ID: "home", Rows: -1, Cols: 40}, valid: false},
+		{meta: &LandMetadata{ID: "home", Rows: 30, Cols: -1}, valid: false},
+	}
+
+	for i, v := range candidates {
+		err := v.meta.Validate()
+		if e, g := v.valid, err == nil; e != g {
+			t.Fatalf("%d : expected valid %t; got %t. err = %v", i, e, g, err)
+		}
+	}
+}
@@ -7,16 +7,49 @@ import (
 	"cloud.google.com/go/firestore"
 )
 
+// MaxBatchWrites is 1つのWriteBatchに入れられる書き込みの上限
+const MaxBatchWrites = 500
+
+// commitBatches is n個の書き込みを、MaxBatchWrites毎に分けたWriteBatchでCommitする
+// addにはWriteBatchと、そのWriteBatchに入れる範囲 [start, end) を渡す
+// committedがnilでない場合は、Commitに成功した範囲ごとに呼ぶ
+// 途中のCommitで失敗した場合、それより前にCommitした範囲は書き込まれたままになる
+func commitBatches(ctx context.Context, n int, add func(b *firestore.WriteBatch, start int, end int), committed func(start int, end int) error) error {
+	for start := 0; start < n; start += MaxBatchWrites {
+		end := start + MaxBatchWrites
+		if end > n {
+			end = n
+		}
+		b := db.Batch()
+		add(b, start, end)
+		if _, err := b.Commit(ctx); err != nil {
+			return err
+		}
+		if committed == nil {
+			continue
+		}
+		if err := committed(start, end); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 var mu sync.RWMutex
 var db *firestore.Client
 
 // SetUp is SetUp
+// FIRESTORE_EMULATOR_HOST が設定されている場合は、Firestore Emulatorに接続する
 func SetUp(ctx context.Context, projectID string) error {
 	return createWithSetClient(ctx, projectID)
 }
 
 func createWithSetClient(ctx context.Context, projectID string) error {
-	client, err := firestore.NewClient(ctx, projectID)
+	opts, err := emulatorClientOptions()
+	if err != nil {
+		return err
+	}
+	client, err := firestore.NewClient(ctx, projectID, opts...)
 	if err != nil {
 		return err
 	}
@@ -1,22 +1,45 @@
 package firedb
 
-import "context"
+import (
+	"context"
+	"errors"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
 
 // MonsterStore is Monsterに関するFirestoreとのやりとりの役割を持つ
 type MonsterStore interface {
 	UpdatePosition(ctx context.Context, p *MonsterPosition) error
+	Delete(ctx context.Context, id string) error
+	Load(ctx context.Context, id string) (*MonsterPosition, error)
+	List(ctx context.Context) ([]*MonsterPosition, error)
+	ListDefinition(ctx context.Context) ([]*MonsterPosition, error)
 }
 
-type monsterStoreImple struct{}
+// ErrMonsterNotFound is 指定したMonsterがFirestoreに存在しない時に利用する
+var ErrMonsterNotFound = errors.New("monster: not found")
+
+type monsterStoreImple struct {
+	world *World
+}
 
 var monsterStore MonsterStore
 
-// NewMonsterStore is MonsterStoreを生成する
+// NewMonsterStore is 既存のCollectionを指す DefaultWorld のMonsterStoreを生成する
 func NewMonsterStore() MonsterStore {
+	return NewMonsterStoreWithWorld(DefaultWorld())
+}
+
+// NewMonsterStoreWithWorld is 指定したWorldのCollectionを扱うMonsterStoreを生成する
+// SetMonsterStoreで差し替えられている場合は、差し替えた実装を返す
+func NewMonsterStoreWithWorld(w *World) MonsterStore {
 	if monsterStore != nil {
 		return monsterStore
 	}
-	return &monsterStoreImple{}
+	return &monsterStoreImple{world: w}
 }
 
 // SetMonsterStore is MonsterStoreの実装を差し替える
@@ -33,14 +56,94 @@ type MonsterPosition struct {
 	IsMove bool    `json:"isMove" firestore:"isMove"`
 	X      float64 `json:"x" firestore:"x"`
 	Y      float64 `json:"y" firestore:"y"`
+
+	// HitPoint is 残りのHP。出現した時や読み込んだ時に、MonsterTypeのHitPointを設定する
+	// 0以下になったMonsterは倒されたものとして取り除くので、0のままのMonsterはHitPointを持たず、ダメージを受けない
+	HitPoint float64 `json:"hitPoint,omitempty" firestore:"hitPoint,omitempty"`
+
+	// Type is Monsterの種類。SpawnPointから出現したMonsterに設定される
+	Type string `json:"type,omitempty" firestore:"type,omitempty"`
+
+	// SpawnPoint is 出現したSpawnPointのID。SpawnPointから出現していない場合は空
+	SpawnPoint string `json:"spawnPoint,omitempty" firestore:"spawnPoint,omitempty"`
 }
 
 // UpdatePosition is MonsterのPositionを更新する
 func (s *monsterStoreImple) UpdatePosition(ctx context.Context, p *MonsterPosition) error {
-	_, err := db.Collection("world-default-land-home-monster-position").Doc(p.ID).Set(ctx, p)
+	_, err := db.Collection(s.world.MonsterPositionPath()).Doc(p.ID).Set(ctx, p)
 	if err != nil {
 		return err
 	}
 
 	return nil
 }
+
+// Delete is MonsterのPositionのDocumentを削除する
+// 存在しない場合もエラーにはしない
+func (s *monsterStoreImple) Delete(ctx context.Context, id string) error {
+	_, err := db.Collection(s.world.MonsterPositionPath()).Doc(id).Delete(ctx)
+	return err
+}
+
+// UpdatePositions is 複数のMonsterのPositionを、WriteBatchでまとめて更新する
+// 1つのWriteBatchに入れられる数には上限があるので、MaxBatchWrites毎に分けてCommitする
+func (s *monsterStoreImple) UpdatePositions(ctx context.Context, l []*MonsterPosition) error {
+	col := db.Collection(s.world.MonsterPositionPath())
+	return commitBatches(ctx, len(l), func(b *firestore.WriteBatch, start int, end int) {
+		for _, p := range l[start:end] {
+			b.Set(col.Doc(p.ID), p)
+		}
+	}, nil)
+}
+
+// Load is 最後に書き込んだMonsterのPositionを取得する
+// 存在しない場合は ErrMonsterNotFound を返す
+func (s *monsterStoreImple) Load(ctx context.Context, id string) (*MonsterPosition, error) {
+	doc, err := db.Collection(s.world.MonsterPositionPath()).Doc(id).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, ErrMonsterNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var mp MonsterPosition
+	if err := doc.DataTo(&mp); err != nil {
+		return nil, err
+	}
+	mp.ID = doc.Ref.ID
+	return &mp, nil
+}
+
+// List is 最後に書き込んだ全てのMonsterのPositionを取得する
+// 再起動した時に、Monsterを止まる前の状態に戻すために利用する
+func (s *monsterStoreImple) List(ctx context.Context) ([]*MonsterPosition, error) {
+	return listMonsterPositions(ctx, db.Collection(s.world.MonsterPositionPath()))
+}
+
+// ListDefinition is Land起動時に配置するMonsterの定義を取得する
+func (s *monsterStoreImple) ListDefinition(ctx context.Context) ([]*MonsterPosition, error) {
+	return listMonsterPositions(ctx, db.Collection(s.world.MonsterDefinitionPath()))
+}
+
+func listMonsterPositions(ctx context.Context, col *firestore.CollectionRef) ([]*MonsterPosition, error) {
+	iter := col.Documents(ctx)
+	defer iter.Stop()
+
+	var l []*MonsterPosition
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var mp MonsterPosition
+		if err := doc.DataTo(&mp); err != nil {
+			return nil, err
+		}
+		mp.ID = doc.Ref.ID
+		l = append(l, &mp)
+	}
+	return l, nil
+}
@@ -8,6 +8,9 @@ import (
 type DummyMonsterStore struct {
 	UpdatePositionCount int
 	MonsterPosition     *MonsterPosition
+	Definitions         []*MonsterPosition
+	Positions           []*MonsterPosition
+	Deleted             []string
 }
 
 func (s *DummyMonsterStore) UpdatePosition(ctx context.Context, p *MonsterPosition) error {
@@ -17,6 +20,34 @@ func (s *DummyMonsterStore) UpdatePosition(ctx context.Context, p *MonsterPositi
 	return nil
 }
 
+func (s *DummyMonsterStore) Delete(ctx context.Context, id string) error {
+	s.Deleted = append(s.Deleted, id)
+	for i, v := range s.Positions {
+		if v.ID == id {
+			s.Positions = append(s.Positions[:i], s.Positions[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (s *DummyMonsterStore) Load(ctx context.Context, id string) (*MonsterPosition, error) {
+	for _, v := range s.Positions {
+		if v.ID == id {
+			return v, nil
+		}
+	}
+	return nil, ErrMonsterNotFound
+}
+
+func (s *DummyMonsterStore) List(ctx context.Context) ([]*MonsterPosition, error) {
+	return s.Positions, nil
+}
+
+func (s *DummyMonsterStore) ListDefinition(ctx context.Context) ([]*MonsterPosition, error) {
+	return s.Definitions, nil
+}
+
 func TestMonsterStore_UpdatePosition(t *testing.T) {
 	dummy := &DummyMonsterStore{}
 	SetMonsterStore(dummy)
@@ -0,0 +1,108 @@
+package firedb
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/api/iterator"
+)
+
+// MonsterType is Monsterの種類ごとの能力と振る舞いの定義
+// 各Monsterは MonsterPosition.Type でMonsterTypeのIDを参照する
+type MonsterType struct {
+	ID string `firestore:"-" json:"id" yaml:"id"`
+
+	// HitPoint is 出現した時のHP
+	HitPoint float64 `firestore:"hitPoint" json:"hitPoint" yaml:"hitPoint"`
+
+	// Attack is プレイヤーに与えるダメージ
+	Attack float64 `firestore:"attack" json:"attack" yaml:"attack"`
+
+	// Speed is 1Tickで移動する量
+	Speed float64 `firestore:"speed" json:"speed" yaml:"speed"`
+
+	// SenseRange is Monsterの周囲何Tile以内のプレイヤーを感知するか
+	// DQNの入力の大きさは決まっているので、Sense Rangeの半分より大きくはできない。0の場合はDQNのSense Range全体を使う
+	SenseRange int `firestore:"senseRange" json:"senseRange" yaml:"senseRange"`
+
+	// Policy is 行動を決める方法。dqn, chase, wander, idle を指定できる。空の場合は dqn
+	Policy string `firestore:"policy" json:"policy" yaml:"policy"`
+
+	// Selector is PolicyがdqnのMonsterが、Q Scoreから行動を選ぶ戦略。argmax, epsilon:{epsilon}, softmax:{temperature} を指定できる
+	// 空の場合は -dqnSelector の戦略を使う
+	Selector string `firestore:"selector" json:"selector" yaml:"selector"`
+
+	// SpriteID is Clientが表示に使う画像のID
+	SpriteID string `firestore:"spriteId" json:"spriteId" yaml:"spriteId"`
+}
+
+// Validate is MonsterTypeとして使えるかを確認する
+// senseRangeの上限は呼び出し側のDQNの入力の大きさで決まるので、ここでは負の値だけを確認する
+func (t *MonsterType) Validate() error {
+	if t.ID == "" {
+		return fmt.Errorf("monster type id is required")
+	}
+	if t.HitPoint < 0 {
+		return fmt.Errorf("monster type %s hitPoint must not be negative. hitPoint = %f", t.ID, t.HitPoint)
+	}
+	if t.Attack < 0 {
+		return fmt.Errorf("monster type %s attack must not be negative. attack = %f", t.ID, t.Attack)
+	}
+	if t.Speed < 0 {
+		return fmt.Errorf("monster type %s speed must not be negative. speed = %f", t.ID, t.Speed)
+	}
+	if t.SenseRange < 0 {
+		return fmt.Errorf("monster type %s senseRange must not be negative. senseRange = %d", t.ID, t.SenseRange)
+	}
+	return nil
+}
+
+// MonsterTypeStore is MonsterTypeに関するFirestoreとのやりとりの役割を持つ
+type MonsterTypeStore interface {
+	ListTypes(ctx context.Context) ([]*MonsterType, error)
+}
+
+type monsterTypeStoreImple struct {
+	world *World
+}
+
+var monsterTypeStore MonsterTypeStore
+
+// NewMonsterTypeStoreWithWorld is 指定したWorldのCollectionを扱うMonsterTypeStoreを生成する
+// SetMonsterTypeStoreで差し替えられている場合は、差し替えた実装を返す
+func NewMonsterTypeStoreWithWorld(w *World) MonsterTypeStore {
+	if monsterTypeStore != nil {
+		return monsterTypeStore
+	}
+	return &monsterTypeStoreImple{world: w}
+}
+
+// SetMonsterTypeStore is MonsterTypeStoreの実装を差し替える
+// Unit Testのために利用する
+func SetMonsterTypeStore(s MonsterTypeStore) {
+	monsterTypeStore = s
+}
+
+// ListTypes is Worldの全てのMonsterTypeを取得する
+func (s *monsterTypeStoreImple) ListTypes(ctx context.Context) ([]*MonsterType, error) {
+	iter := db.Collection(s.world.MonsterTypePath()).Documents(ctx)
+	defer iter.Stop()
+
+	var l []*MonsterType
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var t MonsterType
+		if err := doc.DataTo(&t); err != nil {
+			return nil, err
+		}
+		t.ID = doc.Ref.ID
+		l = append(l, &t)
+	}
+	return l, nil
+}
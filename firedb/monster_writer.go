@@ -0,0 +1,268 @@
+package firedb
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sinmetal/slog"
+	"github.com/sinmetal/stime"
+	"go.opencensus.io/trace"
+)
+
+// DefaultMonsterWriteInterval is BufferedMonsterStoreがFirestoreに書き込む間隔
+const DefaultMonsterWriteInterval = 500 * time.Millisecond
+
+// deletedMonsterRetention is Deleteしたことを覚えておく時間
+// Despawnと同じTickで処理中だった更新が、後から届いても書き込まないようにするため
+const deletedMonsterRetention = time.Minute
+
+// MonsterPositionsUpdater is 複数のMonsterのPositionをまとめて書き込めるMonsterStore
+type MonsterPositionsUpdater interface {
+	UpdatePositions(ctx context.Context, l []*MonsterPosition) error
+}
+
+// MonsterWriteStats is BufferedMonsterStoreの書き込みの統計
+// Requested = Written + Unchanged + Merged + Dropped + 書き込み待ち の関係になる (失敗した分は書き込み待ちに戻る)
+type MonsterWriteStats struct {
+	// Requested is UpdatePositionが呼ばれた回数
+	Requested uint64 `json:"requested"`
+
+	// Unchanged is 最後に書き込んだ値から変わっていないので、書き込まなかった回数
+	Unchanged uint64 `json:"unchanged"`
+
+	// Merged is 書き込む前に同じMonsterの更新が来たので、新しい値にまとめた回数
+	Merged uint64 `json:"merged"`
+
+	// Dropped is Deleteした後に来た更新なので、書き込まなかった回数
+	Dropped uint64 `json:"dropped"`
+
+	// Written is Firestoreに書き込んだ回数
+	Written uint64 `json:"written"`
+
+	// Failed is Firestoreへの書き込みに失敗した回数
+	Failed uint64 `json:"failed"`
+
+	// Batches is Flushで書き込んだ回数
+	Batches uint64 `json:"batches"`
+}
+
+// Saved is Bufferによって減らせた書き込みの回数
+func (s MonsterWriteStats) Saved() uint64 {
+	return s.Unchanged + s.Merged
+}
+
+// BufferedMonsterStore is MonsterStoreのUpdatePositionをBufferして、Runの間隔でまとめて書き込むMonsterStore
+// 最後に書き込んだ値から変わっていない更新は捨て、書き込む前に来た同じMonsterの更新は最新の値にまとめる
+type BufferedMonsterStore struct {
+	store    MonsterStore
+	interval time.Duration
+
+	// flushMu is Flushの書き込みと、Delete, Forgetが重ならないようにするためのLock
+	// 書き込み中のPositionが、削除した後にFirestoreやlastに残らないようにする
+	flushMu sync.Mutex
+
+	mu      sync.Mutex
+	pending map[string]MonsterPosition
+	last    map[string]MonsterPosition
+	deleted map[string]time.Time
+	stats   MonsterWriteStats
+}
+
+// NewBufferedMonsterStore is storeへの書き込みをBufferするBufferedMonsterStoreを生成する
+// storeが MonsterPositionsUpdater を実装している場合は、まとめて書き込む
+func NewBufferedMonsterStore(store MonsterStore, interval time.Duration) *BufferedMonsterStore {
+	if interval <= 0 {
+		interval = DefaultMonsterWriteInterval
+	}
+	return &BufferedMonsterStore{
+		store:    store,
+		interval: interval,
+		pending:  make(map[string]MonsterPosition),
+		last:     make(map[string]MonsterPosition),
+		deleted:  make(map[string]time.Time),
+	}
+}
+
+// UpdatePosition is MonsterのPositionを書き込み待ちにする
+// Deleteした後に来た更新は、Documentを復活させないように捨てる
+func (s *BufferedMonsterStore) UpdatePosition(ctx context.Context, p *MonsterPosition) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.stats.Requested++
+	if _, ok := s.deleted[p.ID]; ok {
+		s.stats.Dropped++
+		return nil
+	}
+	if _, ok := s.pending[p.ID]; ok {
+		s.stats.Merged++
+	}
+	if last, ok := s.last[p.ID]; ok && last == *p {
+		delete(s.pending, p.ID)
+		s.stats.Unchanged++
+		return nil
+	}
+	s.pending[p.ID] = *p
+	return nil
+}
+
+// Delete is Monsterの書き込み待ちを捨ててから、元のMonsterStoreでDocumentを削除する
+// 削除した後に、書き込み待ちの値でDocumentが復活しないようにするため
+// 書き込み中のFlushがある場合は、書き込みが終わるのを待ってから削除する
+// 削除したIDは deletedMonsterRetention の間覚えておき、その間に来た UpdatePosition は捨てる
+func (s *BufferedMonsterStore) Delete(ctx context.Context, id string) error {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+
+	s.mu.Lock()
+	delete(s.pending, id)
+	delete(s.last, id)
+	s.deleted[id] = stime.Now()
+	s.mu.Unlock()
+
+	return s.store.Delete(ctx, id)
+}
+
+// Load is 元のMonsterStoreからMonsterのPositionを取得する
+// 書き込み待ちの値は含まない
+func (s *BufferedMonsterStore) Load(ctx context.Context, id string) (*MonsterPosition, error) {
+	return s.store.Load(ctx, id)
+}
+
+// List is 元のMonsterStoreから全てのMonsterのPositionを取得する
+// 書き込み待ちの値は含まない
+func (s *BufferedMonsterStore) List(ctx context.Context) ([]*MonsterPosition, error) {
+	return s.store.List(ctx)
+}
+
+// ListDefinition is 元のMonsterStoreからMonsterの定義を取得する
+func (s *BufferedMonsterStore) ListDefinition(ctx context.Context) ([]*MonsterPosition, error) {
+	return s.store.ListDefinition(ctx)
+}
+
+// Stats is 書き込みの統計を返す
+func (s *BufferedMonsterStore) Stats() MonsterWriteStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.stats
+}
+
+// Forget is Monsterの書き込み待ちと、最後に書き込んだ値を捨てる
+// Monsterを削除した時に、削除した後に書き込んでしまわないように利用する
+// 書き込み中のFlushがある場合は、書き込みが終わるのを待ってから捨てる
+func (s *BufferedMonsterStore) Forget(id string) {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+
+	s.forget(id)
+}
+
+func (s *BufferedMonsterStore) forget(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.pending, id)
+	delete(s.last, id)
+}
+
+// Flush is 書き込み待ちのPositionを書き込む
+// 失敗した場合は、その間に新しい更新が来ていないMonsterを書き込み待ちに戻す
+// 書き込みが終わるまで、Delete, Forgetは待たされる
+func (s *BufferedMonsterStore) Flush(ctx context.Context) error {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+
+	s.mu.Lock()
+	s.pruneDeleted(stime.Now())
+	if len(s.pending) < 1 {
+		s.mu.Unlock()
+		return nil
+	}
+	l := make([]*MonsterPosition, 0, len(s.pending))
+	for _, v := range s.pending {
+		p := v
+		l = append(l, &p)
+	}
+	s.pending = make(map[string]MonsterPosition)
+	s.mu.Unlock()
+
+	sort.Slice(l, func(i, j int) bool {
+		return l[i].ID < l[j].ID
+	})
+
+	ctx, span := trace.StartSpan(ctx, "/monster/flushPositions")
+	defer span.End()
+	span.AddAttributes(trace.Int64Attribute("positions", int64(len(l))))
+
+	err := s.write(ctx, l)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		s.stats.Failed += uint64(len(l))
+		for _, p := range l {
+			if _, ok := s.pending[p.ID]; !ok {
+				s.pending[p.ID] = *p
+			}
+		}
+		return err
+	}
+	s.stats.Written += uint64(len(l))
+	s.stats.Batches++
+	for _, p := range l {
+		s.last[p.ID] = *p
+	}
+	return nil
+}
+
+// pruneDeleted is deletedMonsterRetention より前にDeleteしたIDを忘れる
+// 呼び出し側でmuのLockを取る
+func (s *BufferedMonsterStore) pruneDeleted(now time.Time) {
+	for id, at := range s.deleted {
+		if now.Sub(at) > deletedMonsterRetention {
+			delete(s.deleted, id)
+		}
+	}
+}
+
+func (s *BufferedMonsterStore) write(ctx context.Context, l []*MonsterPosition) error {
+	if u, ok := s.store.(MonsterPositionsUpdater); ok {
+		return u.UpdatePositions(ctx, l)
+	}
+	for _, p := range l {
+		if err := s.store.UpdatePosition(ctx, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Run is intervalごとにFlushする
+// ctxがcancelされると、残っている書き込み待ちをFlushしてから終了する
+func (s *BufferedMonsterStore) Run(ctx context.Context) error {
+	t := time.NewTicker(s.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			fctx, cancel := context.WithTimeout(slog.WithLog(context.Background()), 5*time.Second)
+			s.flushWithLog(fctx)
+			cancel()
+			return ctx.Err()
+		case <-t.C:
+			s.flushWithLog(slog.WithLog(context.Background()))
+		}
+	}
+}
+
+func (s *BufferedMonsterStore) flushWithLog(ctx context.Context) {
+	defer slog.Flush(ctx)
+
+	if err := s.Flush(ctx); err != nil {
+		slog.Warning(ctx, "FailedFlushMonsterPositions", fmt.Sprintf("%+v", err))
+	}
+}
@@ -0,0 +1,292 @@
+package firedb
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sinmetal/stime"
+)
+
+// batchMonsterStore is UpdatePositionsでまとめて書き込むMonsterStore Dummy実装
+type batchMonsterStore struct {
+	Batches [][]*MonsterPosition
+	Deleted []string
+	Err     error
+}
+
+func (s *batchMonsterStore) UpdatePosition(ctx context.Context, p *MonsterPosition) error {
+	return errors.New("UpdatePosition should not be called")
+}
+
+func (s *batchMonsterStore) UpdatePositions(ctx context.Context, l []*MonsterPosition) error {
+	if s.Err != nil {
+		return s.Err
+	}
+	s.Batches = append(s.Batches, l)
+	return nil
+}
+
+func (s *batchMonsterStore) Delete(ctx context.Context, id string) error {
+	s.Deleted = append(s.Deleted, id)
+	return nil
+}
+
+func (s *batchMonsterStore) Load(ctx context.Context, id string) (*MonsterPosition, error) {
+	return nil, ErrMonsterNotFound
+}
+
+func (s *batchMonsterStore) List(ctx context.Context) ([]*MonsterPosition, error) {
+	return nil, nil
+}
+
+func (s *batchMonsterStore) ListDefinition(ctx context.Context) ([]*MonsterPosition, error) {
+	return nil, nil
+}
+
+// blockingMonsterStore is UpdatePositionsの途中で止まるMonsterStore Dummy実装
+// 書き込み中にDeleteされた時の順番を確かめるために使う
+type blockingMonsterStore struct {
+	batchMonsterStore
+
+	started chan struct{}
+	release chan struct{}
+
+	mu     sync.Mutex
+	events []string
+}
+
+func (s *blockingMonsterStore) UpdatePositions(ctx context.Context, l []*MonsterPosition) error {
+	close(s.started)
+	<-s.release
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, "write")
+	return s.batchMonsterStore.UpdatePositions(ctx, l)
+}
+
+func (s *blockingMonsterStore) Delete(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, "delete")
+	return s.batchMonsterStore.Delete(ctx, id)
+}
+
+func TestBufferedMonsterStore(t *testing.T) {
+	ctx := context.Background()
+	inner := &batchMonsterStore{}
+	s := NewBufferedMonsterStore(inner, 0)
+
+	// 1回目のFlushまでに、mob1は3回、mob2は1回更新される
+	s.UpdatePosition(ctx, &MonsterPosition{ID: "mob1", X: 1})
+	s.UpdatePosition(ctx, &MonsterPosition{ID: "mob1", X: 2})
+	s.UpdatePosition(ctx, &MonsterPosition{ID: "mob1", X: 3})
+	s.UpdatePosition(ctx, &MonsterPosition{ID: "mob2", X: 10})
+	if err := s.Flush(ctx); err != nil {
+		t.Fatalf("failed Flush. err=%+v", err)
+	}
+	if e, g := 1, len(inner.Batches); e != g {
+		t.Fatalf("expected %d batches; got %d", e, g)
+	}
+	if e, g := 2, len(inner.Batches[0]); e != g {
+		t.Fatalf("expected %d positions; got %d", e, g)
+	}
+	if e, g := 3.0, inner.Batches[0][0].X; e != g {
+		t.Fatalf("expected latest mob1 X is %f; got %f", e, g)
+	}
+
+	// 変わっていない更新は書き込まない
+	s.UpdatePosition(ctx, &MonsterPosition{ID: "mob1", X: 3})
+	s.UpdatePosition(ctx, &MonsterPosition{ID: "mob2", X: 10})
+	if err := s.Flush(ctx); err != nil {
+		t.Fatalf("failed Flush. err=%+v", err)
+	}
+	if e, g := 1, len(inner.Batches); e != g {
+		t.Fatalf("expected %d batches; got %d", e, g)
+	}
+
+	// 動いた後に、書き込む前に元の位置に戻った場合も書き込まない
+	s.UpdatePosition(ctx, &MonsterPosition{ID: "mob1", X: 4})
+	s.UpdatePosition(ctx, &MonsterPosition{ID: "mob1", X: 3})
+	if err := s.Flush(ctx); err != nil {
+		t.Fatalf("failed Flush. err=%+v", err)
+	}
+	if e, g := 1, len(inner.Batches); e != g {
+		t.Fatalf("expected %d batches; got %d", e, g)
+	}
+
+	stats := s.Stats()
+	if e, g := uint64(8), stats.Requested; e != g {
+		t.Fatalf("expected Requested is %d; got %d", e, g)
+	}
+	if e, g := uint64(2), stats.Written; e != g {
+		t.Fatalf("expected Written is %d; got %d", e, g)
+	}
+	if e, g := uint64(6), stats.Saved(); e != g {
+		t.Fatalf("expected Saved is %d; got %d. %+v", e, g, stats)
+	}
+	if e, g := stats.Requested, stats.Written+stats.Saved(); e != g {
+		t.Fatalf("expected Requested = Written + Saved. %+v", stats)
+	}
+}
+
+func TestBufferedMonsterStore_FlushFailed(t *testing.T) {
+	ctx := context.Background()
+	inner := &batchMonsterStore{Err: errors.New("unavailable")}
+	s := NewBufferedMonsterStore(inner, 0)
+
+	s.UpdatePosition(ctx, &MonsterPosition{ID: "mob1", X: 1})
+	s.UpdatePosition(ctx, &MonsterPosition{ID: "mob2", X: 1})
+	if err := s.Flush(ctx); err == nil {
+		t.Fatalf("expected error")
+	}
+	// 失敗している間に来た更新は、戻さずに新しい値を使う
+	s.UpdatePosition(ctx, &MonsterPosition{ID: "mob2", X: 2})
+
+	inner.Err = nil
+	if err := s.Flush(ctx); err != nil {
+		t.Fatalf("failed Flush. err=%+v", err)
+	}
+	if e, g := 2, len(inner.Batches[0]); e != g {
+		t.Fatalf("expected %d positions; got %d", e, g)
+	}
+	if e, g := 2.0, inner.Batches[0][1].X; e != g {
+		t.Fatalf("expected mob2 X is %f; got %f", e, g)
+	}
+	if e, g := uint64(2), s.Stats().Failed; e != g {
+		t.Fatalf("expected Failed is %d; got %d", e, g)
+	}
+}
+
+func TestBufferedMonsterStore_Forget(t *testing.T) {
+	ctx := context.Background()
+	inner := &DummyMonsterStore{}
+	s := NewBufferedMonsterStore(inner, 0)
+
+	s.UpdatePosition(ctx, &MonsterPosition{ID: "mob1", X: 1})
+	s.Forget("mob1")
+	if err := s.Flush(ctx); err != nil {
+		t.Fatalf("failed Flush. err=%+v", err)
+	}
+	if e, g := 0, inner.UpdatePositionCount; e != g {
+		t.Fatalf("expected UpdatePositionCount is %d; got %d", e, g)
+	}
+
+	// MonsterPositionsUpdaterを実装していない場合は、1つずつ書き込む
+	s.UpdatePosition(ctx, &MonsterPosition{ID: "mob1", X: 1})
+	s.UpdatePosition(ctx, &MonsterPosition{ID: "mob2", X: 1})
+	if err := s.Flush(ctx); err != nil {
+		t.Fatalf("failed Flush. err=%+v", err)
+	}
+	if e, g := 2, inner.UpdatePositionCount; e != g {
+		t.Fatalf("expected UpdatePositionCount is %d; got %d", e, g)
+	}
+}
+
+func TestBufferedMonsterStore_Delete(t *testing.T) {
+	ctx := context.Background()
+	inner := &batchMonsterStore{}
+	s := NewBufferedMonsterStore(inner, 0)
+
+	s.UpdatePosition(ctx, &MonsterPosition{ID: "mob1", X: 1})
+	if err := s.Delete(ctx, "mob1"); err != nil {
+		t.Fatalf("failed Delete. err=%+v", err)
+	}
+	if e, g := 1, len(inner.Deleted); e != g {
+		t.Fatalf("expected %d deleted; got %d", e, g)
+	}
+
+	// 削除した後に、書き込み待ちの値で復活させない
+	if err := s.Flush(ctx); err != nil {
+		t.Fatalf("failed Flush. err=%+v", err)
+	}
+	if e, g := 0, len(inner.Batches); e != g {
+		t.Fatalf("expected %d batches; got %d", e, g)
+	}
+}
+
+func TestBufferedMonsterStore_DeleteDuringFlush(t *testing.T) {
+	for _, failed := range []bool{false, true} {
+		ctx := context.Background()
+		inner := &blockingMonsterStore{
+			started: make(chan struct{}),
+			release: make(chan struct{}),
+		}
+		if failed {
+			inner.Err = errors.New("unavailable")
+		}
+		s := NewBufferedMonsterStore(inner, 0)
+
+		s.UpdatePosition(ctx, &MonsterPosition{ID: "mob1", X: 1})
+		flushed := make(chan error, 1)
+		go func() {
+			flushed <- s.Flush(ctx)
+		}()
+		<-inner.started
+
+		deleted := make(chan error, 1)
+		go func() {
+			deleted <- s.Delete(ctx, "mob1")
+		}()
+		close(inner.release)
+		if err := <-flushed; (err != nil) != failed {
+			t.Fatalf("failed=%t : unexpected Flush err=%+v", failed, err)
+		}
+		if err := <-deleted; err != nil {
+			t.Fatalf("failed=%t : failed Delete. err=%+v", failed, err)
+		}
+
+		// 書き込み中だった値で、削除した後にDocumentを復活させない
+		if e, g := []string{"write", "delete"}, inner.events; len(e) != len(g) || e[0] != g[0] || e[1] != g[1] {
+			t.Fatalf("failed=%t : expected events %v; got %v", failed, e, g)
+		}
+		// 失敗した書き込みを書き込み待ちに戻さず、lastにも残さない
+		s.mu.Lock()
+		pending, last := len(s.pending), len(s.last)
+		s.mu.Unlock()
+		if pending != 0 || last != 0 {
+			t.Fatalf("failed=%t : expected pending and last are empty; got pending=%d, last=%d", failed, pending, last)
+		}
+	}
+}
+
+func TestBufferedMonsterStore_UpdateAfterDelete(t *testing.T) {
+	now := time.Date(2019, 2, 1, 10, 0, 0, 0, time.UTC)
+	stime.SetPermafrost(now)
+	defer stime.SetPermafrost(time.Time{})
+
+	ctx := context.Background()
+	inner := &batchMonsterStore{}
+	s := NewBufferedMonsterStore(inner, 0)
+
+	if err := s.Delete(ctx, "mob1"); err != nil {
+		t.Fatalf("failed Delete. err=%+v", err)
+	}
+	// Despawnと同じTickで処理中だった更新が、Deleteの後に届く
+	s.UpdatePosition(ctx, &MonsterPosition{ID: "mob1", X: 1})
+	if err := s.Flush(ctx); err != nil {
+		t.Fatalf("failed Flush. err=%+v", err)
+	}
+	if e, g := 0, len(inner.Batches); e != g {
+		t.Fatalf("expected %d batches; got %d", e, g)
+	}
+	if e, g := uint64(1), s.Stats().Dropped; e != g {
+		t.Fatalf("expected Dropped is %d; got %d", e, g)
+	}
+
+	// 十分に時間が経てば、削除したIDは忘れる
+	stime.SetPermafrost(now.Add(deletedMonsterRetention + time.Second))
+	if err := s.Flush(ctx); err != nil {
+		t.Fatalf("failed Flush. err=%+v", err)
+	}
+	s.UpdatePosition(ctx, &MonsterPosition{ID: "mob1", X: 2})
+	if err := s.Flush(ctx); err != nil {
+		t.Fatalf("failed Flush. err=%+v", err)
+	}
+	if e, g := 1, len(inner.Batches); e != g {
+		t.Fatalf("expected %d batches; got %d", e, g)
+	}
+}
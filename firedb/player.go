@@ -3,12 +3,15 @@ package firedb
 import (
 	"context"
 	"fmt"
+	"sort"
 	"sync"
 	"time"
 
 	"cloud.google.com/go/firestore"
 	"github.com/pkg/errors"
 	"github.com/sinmetal/stime"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 // PlayerStore is PlayerStore
@@ -17,33 +20,73 @@ type PlayerStore interface {
 	GetPosition(id string) *PlayerPosition
 	GetPlayerMapSnapshot() map[string]*User
 	GetPositionMapSnapshot() map[string]*PlayerPosition
-	SetPassiveUser(ctx context.Context, id string) error
-	UpdateActiveUser(ctx context.Context, id string, active bool) error
+	QueryRect(landID string, row int, col int, rows int, cols int) []*PlayerPosition
+	CheckPresence(ctx context.Context) ([]PresenceTransition, error)
+	Subscribe(bufferSize int) *PlayerSubscription
 }
 
 // defaultPlayerStore is Default PlayerStore Functions
+// playerMapとpresenceは playerMapMutex で、positionMapとpositionIndexは positionMapMutex で一緒に守る
+// 両方のLockを取る場合は playerMapMutex, positionMapMutex の順に取る
+// presenceRetryは presenceRetryMutex で守り、他のLockを取ったまま取らない
 type defaultPlayerStore struct {
+	world            *World
 	playerMap        map[string]*User
 	positionMap      map[string]*PlayerPosition
+	positionIndex    *playerIndex
+	presence         *PresenceMachine
+	events           *PlayerEventHub
 	playerMapMutex   *sync.RWMutex
 	positionMapMutex *sync.RWMutex
+
+	// presenceRetry is `world-{world}-users` への書き込みに失敗した、プレイヤーごとの最後の在席状態の遷移
+	// PresenceMachineは書き込む前に状態を進めてしまうので、次のCheckPresenceでもう一度書き込む
+	presenceRetry      map[string]PresenceTransition
+	presenceRetryMutex *sync.Mutex
+
+	// updatePresence is 在席状態の遷移を書き込む関数。nilの場合は UpdatePresence を使う
+	// Unit Testで書き込みの失敗を起こすために差し替える
+	updatePresence func(ctx context.Context, t PresenceTransition) error
 }
 
 var playerStore PlayerStore
 
 // NewPlayerStore is NewPlayerStore
+// NewPlayerStoreWithWorld で生成していない場合は、DefaultWorld のCollectionを扱う
 func NewPlayerStore() PlayerStore {
 	if playerStore == nil {
-		playerStore = &defaultPlayerStore{
-			playerMap:        make(map[string]*User),
-			positionMap:      make(map[string]*PlayerPosition),
-			playerMapMutex:   &sync.RWMutex{},
-			positionMapMutex: &sync.RWMutex{},
-		}
+		playerStore = newDefaultPlayerStore(DefaultWorld(), DefaultPresenceThresholds())
 	}
 	return playerStore
 }
 
+// NewPlayerStoreWithWorld is 指定したWorldのCollectionを扱い、指定した遷移時間で在席状態を進めるPlayerStoreを生成する
+// 全てのLandのプレイヤーを扱うので、WorldのLandIDは使わない
+// 生成したPlayerStoreは、NewPlayerStoreが返すPlayerStoreにする
+func NewPlayerStoreWithWorld(w *World, thresholds PresenceThresholds) (PlayerStore, error) {
+	if err := thresholds.Validate(); err != nil {
+		return nil, err
+	}
+	playerStore = newDefaultPlayerStore(w, thresholds)
+	return playerStore, nil
+}
+
+func newDefaultPlayerStore(w *World, thresholds PresenceThresholds) *defaultPlayerStore {
+	return &defaultPlayerStore{
+		world:            w,
+		playerMap:        make(map[string]*User),
+		positionMap:      make(map[string]*PlayerPosition),
+		positionIndex:    newPlayerIndex(),
+		presence:         NewPresenceMachine(thresholds),
+		events:           NewPlayerEventHub(),
+		playerMapMutex:   &sync.RWMutex{},
+		positionMapMutex: &sync.RWMutex{},
+
+		presenceRetry:      make(map[string]PresenceTransition),
+		presenceRetryMutex: &sync.Mutex{},
+	}
+}
+
 // SetPlayerStore is 実装を差し替えたいときに利用する
 func SetPlayerStore(s PlayerStore) {
 	playerStore = s
@@ -51,9 +94,10 @@ func SetPlayerStore(s PlayerStore) {
 
 // User is `world-{world}-users`
 type User struct {
-	Name      string    `firestore:"name"`
-	Active    bool      `firestore:"active"`
-	UpdatedAt time.Time `firestore:"updatedAt"`
+	Name      string        `firestore:"name"`
+	Active    bool          `firestore:"active"`
+	Presence  PresenceState `firestore:"presence"`
+	UpdatedAt time.Time     `firestore:"updatedAt"`
 }
 
 // PlayerPosition is Player Position Struct
@@ -65,6 +109,24 @@ type PlayerPosition struct {
 	X                 float64   `json:"x" firestore:"x"`
 	Y                 float64   `json:"y" firestore:"y"`
 	FirestoreUpdateAt time.Time `firestore:"-"` // FirestoreのUpdateTime
+
+	// Land is プレイヤーがいるLandのID
+	// 全てのLandで1つのCollectionを共有しているので、X, YだけではどのLandにいるか分からない
+	// 空の場合は DefaultLandID にいるものとして扱う
+	Land string `json:"land" firestore:"land"`
+}
+
+// LandID is プレイヤーがいるLandのIDを返す。Landが空の場合は DefaultLandID を返す
+func (pp *PlayerPosition) LandID() string {
+	return LandIDOrDefault(pp.Land)
+}
+
+// LandIDOrDefault is Land IDが空の場合は DefaultLandID を返す
+func LandIDOrDefault(id string) string {
+	if id == "" {
+		return DefaultLandID
+	}
+	return id
 }
 
 // Watch is PlayerPosition Sync Firestore
@@ -94,25 +156,106 @@ func (s *defaultPlayerStore) Watch(ctx context.Context, path string) error {
 				return errors.WithStack(err)
 			}
 			pp.FirestoreUpdateAt = v.Doc.UpdateTime
-			s.positionMap[pp.ID] = &pp
 
-			if isChangeActiveStatus(s.playerMap, pp.ID) {
-				fmt.Printf("%s is Active\n", pp.ID)
-				if err := s.SetActiveUser(ctx, pp.ID); err != nil {
-					return errors.WithStack(err)
+			if t, ok := s.updatePosition(&pp); ok {
+				fmt.Printf("%s is %s -> %s\n", t.ID, t.From, t.To)
+				if err := s.writePresence(ctx, t); err != nil {
+					// 書き込めなかった遷移は、次のCheckPresenceでもう一度書き込む
+					fmt.Printf("failed UpdatePresence. retry next CheckPresence. %+v\n", err)
 				}
 			}
 		}
 	}
 }
 
+// updatePosition is positionMapを更新して、プレイヤーが動いたことをPresenceMachineに記録する
+// 在席状態が変わった場合は、その遷移を返す
+// Firestoreへの書き込みは行わないので、Lockを取っている間に通信で待たされることは無い
+func (s *defaultPlayerStore) updatePosition(pp *PlayerPosition) (PresenceTransition, bool) {
+	// Eventの順番が遷移の順番と同じになるように、playerMapMutexのLockを取ったまま送る
+	// offlineになったプレイヤーを取り除く evictPlayer と入れ違いにならないように、positionMapもこのLockの中で更新する
+	s.playerMapMutex.Lock()
+	defer s.playerMapMutex.Unlock()
+
+	s.positionMapMutex.Lock()
+	s.positionMap[pp.ID] = pp
+	s.positionIndex.update(pp.ID, pp.LandID(), pp.X, pp.Y)
+	s.positionMapMutex.Unlock()
+
+	v := *pp
+	t, ok := s.presence.Seen(pp.ID)
+	if ok {
+		s.applyTransition(t, &v)
+	}
+	s.events.Publish(PlayerEvent{Type: PlayerMoved, ID: pp.ID, Presence: PresenceActive, Position: &v, At: stime.Now()})
+	return t, ok
+}
+
+// evaluatePresence is 時間経過による在席状態の遷移をplayerMapに反映して返す
+// offlineになったプレイヤーは、全てのMapから取り除く
+func (s *defaultPlayerStore) evaluatePresence() []PresenceTransition {
+	s.playerMapMutex.Lock()
+	defer s.playerMapMutex.Unlock()
+
+	l := s.presence.Evaluate()
+	for _, t := range l {
+		s.applyTransition(t, nil)
+		if t.To == PresenceOffline {
+			s.evictPlayer(t.ID)
+		}
+	}
+	return l
+}
+
+// evictPlayer is いなくなったプレイヤーを、playerMap, presence, positionMap, positionIndexから取り除く
+// 次に動いた時は、初めて見たプレイヤーとして unknown から active に遷移する
+// playerMapMutexのLockを取ってから呼ぶ
+func (s *defaultPlayerStore) evictPlayer(id string) {
+	delete(s.playerMap, id)
+	s.presence.Forget(id)
+
+	s.positionMapMutex.Lock()
+	defer s.positionMapMutex.Unlock()
+
+	delete(s.positionMap, id)
+	s.positionIndex.remove(id)
+}
+
+// applyTransition is playerMapのユーザの状態を更新して、Subscriptionに在席状態の変化を送る
+// Snapshotとして外に渡したUserを書き換えないように、新しいUserに差し替える
+// playerMapMutexのLockを取ってから呼ぶ
+func (s *defaultPlayerStore) applyTransition(t PresenceTransition, pp *PlayerPosition) {
+	var u User
+	if v, ok := s.playerMap[t.ID]; ok {
+		u = *v
+	}
+	u.Active = t.To.Active()
+	u.Presence = t.To
+	u.UpdatedAt = t.At
+	s.playerMap[t.ID] = &u
+
+	if e, ok := newPresenceEvent(t, pp); ok {
+		s.events.Publish(e)
+	}
+}
+
+// Subscribe is プレイヤーの変化をPlayerEventとして受け取る
+// bufferSizeが0以下の場合は DefaultPlayerEventBufferSize を使う
+// 受け取る側はCを読み続ける必要があり、読まなくなった場合はCloseする
+func (s *defaultPlayerStore) Subscribe(bufferSize int) *PlayerSubscription {
+	return s.events.Subscribe(bufferSize)
+}
+
+// GetPlayerMapSnapshot is PlayerMapをCopyして返す
+// 中身のUserもCopyしているので、返した値を書き換えてもStoreには影響しない
 func (s *defaultPlayerStore) GetPlayerMapSnapshot() map[string]*User {
 	s.playerMapMutex.RLock()
 	defer s.playerMapMutex.RUnlock()
 
-	playerMap := make(map[string]*User)
+	playerMap := make(map[string]*User, len(s.playerMap))
 	for k, v := range s.playerMap {
-		playerMap[k] = v
+		u := *v
+		playerMap[k] = &u
 	}
 	return playerMap
 }
@@ -120,75 +263,136 @@ func (s *defaultPlayerStore) GetPlayerMapSnapshot() map[string]*User {
 // GetPositionMapSnapshot is PlayerPositionMapをCopyして返す
 // Copyしているのは、複数goroutineで使うことを考慮しているため。
 // Map全体を見る処理が軽い場合は、Copyせずに直接Lockを取った方が良いが、重たい処理をする時のためにSnapshotを取っている。
+// 中身のPlayerPositionもCopyしているので、返した値を書き換えてもStoreには影響しない
 func (s *defaultPlayerStore) GetPositionMapSnapshot() map[string]*PlayerPosition {
 	s.positionMapMutex.RLock()
 	defer s.positionMapMutex.RUnlock()
 
-	positionMap := make(map[string]*PlayerPosition)
+	positionMap := make(map[string]*PlayerPosition, len(s.positionMap))
 	for k, v := range s.positionMap {
-		positionMap[k] = v
+		pp := *v
+		positionMap[k] = &pp
 	}
 
 	return positionMap
 }
 
-// GetPosition is 指定したIDのプレイヤーのポジションを取得
+// GetPosition is 指定したIDのプレイヤーのポジションのCopyを取得
 func (s *defaultPlayerStore) GetPosition(id string) *PlayerPosition {
+	s.positionMapMutex.RLock()
+	defer s.positionMapMutex.RUnlock()
+
 	pp, ok := s.positionMap[id]
 	if ok == false {
 		return nil
 	}
-	return pp
+	v := *pp
+	return &v
 }
 
-// SetActiveUser is 移動しているなどアクティブであることが計測されたユーザの状態を更新する
-func (s *defaultPlayerStore) SetActiveUser(ctx context.Context, id string) error {
-	_, ok := s.playerMap[id]
-	if !ok {
-		s.playerMap[id] = &User{}
-	}
+// QueryRect is landIDのLandの (row, col) のTileを左上として、rows x cols の範囲のTileにいるプレイヤーのポジションのCopyを返す
+// 全プレイヤーを見ずに、範囲にいるプレイヤーだけを探す。landIDが空の場合は DefaultLandID を探す
+func (s *defaultPlayerStore) QueryRect(landID string, row int, col int, rows int, cols int) []*PlayerPosition {
+	s.positionMapMutex.RLock()
+	defer s.positionMapMutex.RUnlock()
 
-	s.playerMap[id].Active = true
-	s.playerMap[id].UpdatedAt = stime.Now()
+	ids := s.positionIndex.queryRect(LandIDOrDefault(landID), row, col, rows, cols)
+	l := make([]*PlayerPosition, 0, len(ids))
+	for _, id := range ids {
+		v := *s.positionMap[id]
+		l = append(l, &v)
+	}
+	return l
+}
 
-	if err := s.UpdateActiveUser(ctx, id, true); err != nil {
-		return errors.WithStack(err)
+// CheckPresence is 時間経過でプレイヤーの在席状態を進めて、変わったものをFirestoreに書き込む
+// 前回までに書き込みに失敗した遷移も、ここでもう一度書き込む
+// 書き込みに失敗した場合も、他のプレイヤーの書き込みは続ける
+func (s *defaultPlayerStore) CheckPresence(ctx context.Context) ([]PresenceTransition, error) {
+	l := append(s.takePresenceRetries(), s.evaluatePresence()...)
+	var rerr error
+	for _, t := range l {
+		fmt.Printf("%s is %s -> %s\n", t.ID, t.From, t.To)
+		if err := s.writePresence(ctx, t); err != nil {
+			rerr = err
+		}
 	}
-	return nil
+	return l, rerr
 }
 
-// SetPassiveUser is ユーザをパッシブ状態にする
-func (s *defaultPlayerStore) SetPassiveUser(ctx context.Context, id string) error {
-	v, ok := s.playerMap[id]
-	if !ok {
-		s.playerMap[id] = &User{}
+// writePresence is 在席状態の遷移を書き込む
+// 失敗した場合は presenceRetry に入れて、次のCheckPresenceでもう一度書き込む
+// 成功した場合は、それより前に失敗していた同じプレイヤーの遷移を presenceRetry から取り除く
+func (s *defaultPlayerStore) writePresence(ctx context.Context, t PresenceTransition) error {
+	update := s.updatePresence
+	if update == nil {
+		update = s.UpdatePresence
 	}
-	v.Active = false
-	v.UpdatedAt = stime.Now()
+	err := update(ctx, t)
 
-	s.playerMap[id] = v
+	s.presenceRetryMutex.Lock()
+	defer s.presenceRetryMutex.Unlock()
 
-	if err := s.UpdateActiveUser(ctx, id, false); err != nil {
-		return errors.WithStack(err)
+	v, ok := s.presenceRetry[t.ID]
+	if err != nil {
+		if !ok || !v.At.After(t.At) {
+			s.presenceRetry[t.ID] = t
+		}
+		return err
+	}
+	if ok && !v.At.After(t.At) {
+		delete(s.presenceRetry, t.ID)
 	}
 	return nil
 }
 
-func (s *defaultPlayerStore) UpdateActiveUser(ctx context.Context, id string, active bool) error {
-	ref := db.Doc(fmt.Sprintf("world-default-users/%s", id))
+// takePresenceRetries is 書き込みに失敗した遷移を取り出して、プレイヤーのID順に返す
+// その後に在席状態が変わっている場合は、今の状態を書き込むように遷移先を差し替える
+// offlineになって取り除かれたプレイヤーは、失敗した遷移をそのまま書き込む
+func (s *defaultPlayerStore) takePresenceRetries() []PresenceTransition {
+	s.presenceRetryMutex.Lock()
+	l := make([]PresenceTransition, 0, len(s.presenceRetry))
+	for _, t := range s.presenceRetry {
+		l = append(l, t)
+	}
+	s.presenceRetry = make(map[string]PresenceTransition)
+	s.presenceRetryMutex.Unlock()
+
+	s.playerMapMutex.RLock()
+	defer s.playerMapMutex.RUnlock()
+	for i, t := range l {
+		if state := s.presence.State(t.ID); state != PresenceUnknown {
+			l[i].To = state
+		}
+	}
+	sort.Slice(l, func(i, j int) bool {
+		return l[i].ID < l[j].ID
+	})
+	return l
+}
+
+// UpdatePresence is 在席状態の遷移を `world-{world}-users` に書き込む
+// usersへの書き込みは、全てここを通す
+func (s *defaultPlayerStore) UpdatePresence(ctx context.Context, t PresenceTransition) error {
+	ref := db.Doc(s.world.UserDocPath(t.ID))
 	err := db.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		var u User
 		doc, err := tx.Get(ref)
-		if err != nil {
+		if err != nil && status.Code(err) != codes.NotFound {
 			return err
 		}
-		var u User
-		doc.DataTo(&u)
-		u.Active = active
-		u.UpdatedAt = time.Now()
+		if err == nil {
+			if err := doc.DataTo(&u); err != nil {
+				return err
+			}
+		}
+		u.Active = t.To.Active()
+		u.Presence = t.To
+		u.UpdatedAt = t.At
 		return tx.Set(ref, &u)
 	})
 	if err != nil {
-		return errors.WithMessage(err, fmt.Sprintf("id = %s", id))
+		return errors.WithMessage(err, fmt.Sprintf("id = %s, presence = %s", t.ID, t.To))
 	}
 
 	return nil
@@ -203,19 +407,3 @@ func ExistsActivePlayer(playerMap map[string]*User) bool {
 	}
 	return false
 }
-
-func isChangeActiveStatus(playerMap map[string]*User, id string) bool {
-	u, ok := playerMap[id]
-	if !ok {
-		return true
-	}
-	if u.Active == false {
-		return true
-	}
-	t := u.UpdatedAt.Add(time.Minute * 10)
-	if t.Before(stime.Now()) {
-		return true
-	}
-
-	return false
-}
@@ -0,0 +1,142 @@
+package firedb
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// PlayerEventType is PlayerEventの種類
+type PlayerEventType string
+
+const (
+	// PlayerJoined is プレイヤーが動き始めて、activeになったEvent
+	// unknown, passive, offline から active になった時に送る
+	PlayerJoined PlayerEventType = "joined"
+
+	// PlayerMoved is プレイヤーの位置が更新されたEvent
+	PlayerMoved PlayerEventType = "moved"
+
+	// PlayerIdle is プレイヤーがしばらく動いていないEvent
+	// idle, passive になった時に送る。どちらになったかは Presence で判断する
+	PlayerIdle PlayerEventType = "idle"
+
+	// PlayerLeft is プレイヤーがいなくなったEvent
+	// offline になった時に送る
+	PlayerLeft PlayerEventType = "left"
+)
+
+// DefaultPlayerEventBufferSize is Subscribeでbufferを指定しなかった時のbufferの大きさ
+const DefaultPlayerEventBufferSize = 64
+
+// PlayerEvent is PlayerStoreから送られるプレイヤーの変化
+type PlayerEvent struct {
+	Type     PlayerEventType
+	ID       string
+	Presence PresenceState
+
+	// Position is Joined, Movedの時のプレイヤーの位置のCopy。それ以外はnil
+	Position *PlayerPosition
+	At       time.Time
+}
+
+// newPresenceEvent is 在席状態の遷移から送るEventを組み立てる
+// Eventを送る必要が無い遷移の場合はfalseを返す
+func newPresenceEvent(t PresenceTransition, pp *PlayerPosition) (PlayerEvent, bool) {
+	e := PlayerEvent{ID: t.ID, Presence: t.To, At: t.At}
+	switch t.To {
+	case PresenceActive:
+		if t.From.Active() {
+			// idleから戻っただけなので、Movedだけを送る
+			return e, false
+		}
+		e.Type = PlayerJoined
+		e.Position = pp
+	case PresenceIdle, PresencePassive:
+		e.Type = PlayerIdle
+	case PresenceOffline:
+		e.Type = PlayerLeft
+	default:
+		return e, false
+	}
+	return e, true
+}
+
+// PlayerSubscription is PlayerEventを受け取るためのSubscription
+// 受け取る側が遅くてbufferが一杯の時は、Eventを捨ててDroppedを増やす
+// PlayerStoreのWatchが受け取る側に待たされないようにするため
+type PlayerSubscription struct {
+	// droppedは32bit環境でatomicに扱えるように先頭に置く
+	dropped uint64
+
+	// C is PlayerEventが送られるChannel。Closeするとcloseされる
+	C <-chan PlayerEvent
+
+	c   chan PlayerEvent
+	hub *PlayerEventHub
+}
+
+// Dropped is bufferが一杯で捨てたEventの数を返す
+func (s *PlayerSubscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Close is Subscriptionを終了して、Cをcloseする
+func (s *PlayerSubscription) Close() {
+	s.hub.unsubscribe(s)
+}
+
+// PlayerEventHub is Subscriptionを管理して、PlayerEventを配る
+// PlayerStoreの実装から利用する
+type PlayerEventHub struct {
+	mu   sync.Mutex
+	subs map[*PlayerSubscription]struct{}
+}
+
+// NewPlayerEventHub is PlayerEventHubを生成する
+func NewPlayerEventHub() *PlayerEventHub {
+	return &PlayerEventHub{
+		subs: make(map[*PlayerSubscription]struct{}),
+	}
+}
+
+// Subscribe is Subscriptionを追加する
+// bufferSizeが0以下の場合は DefaultPlayerEventBufferSize を使う
+func (h *PlayerEventHub) Subscribe(bufferSize int) *PlayerSubscription {
+	if bufferSize < 1 {
+		bufferSize = DefaultPlayerEventBufferSize
+	}
+	c := make(chan PlayerEvent, bufferSize)
+	s := &PlayerSubscription{C: c, c: c, hub: h}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.subs[s] = struct{}{}
+	return s
+}
+
+func (h *PlayerEventHub) unsubscribe(s *PlayerSubscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.subs[s]; !ok {
+		return
+	}
+	delete(h.subs, s)
+	close(s.c)
+}
+
+// Publish is 全てのSubscriptionにEventを送る
+// bufferが一杯のSubscriptionには送らずに、Droppedを増やす
+func (h *PlayerEventHub) Publish(e PlayerEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for s := range h.subs {
+		select {
+		case s.c <- e:
+		default:
+			atomic.AddUint64(&s.dropped, 1)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package firedb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sinmetal/stime"
+)
+
+// receiveEvents is Subscriptionのbufferに溜まっているEventを全て受け取る
+func receiveEvents(sub *PlayerSubscription) []PlayerEvent {
+	var l []PlayerEvent
+	for {
+		select {
+		case e, ok := <-sub.C:
+			if !ok {
+				return l
+			}
+			l = append(l, e)
+		default:
+			return l
+		}
+	}
+}
+
+func TestDefaultPlayerStore_Subscribe(t *testing.T) {
+	start := time.Date(2019, 2, 1, 10, 0, 0, 0, time.UTC)
+	stime.SetPermafrost(start)
+	defer stime.SetPermafrost(time.Time{})
+
+	s := newDefaultPlayerStore(DefaultWorld(), DefaultPresenceThresholds())
+	sub := s.Subscribe(0)
+	defer sub.Close()
+
+	candidates := []struct {
+		elapsed time.Duration
+		move    bool
+		types   []PlayerEventType
+	}{
+		{elapsed: 0, move: true, types: []PlayerEventType{PlayerJoined, PlayerMoved}},
+		{elapsed: 10 * time.Second, move: true, types: []PlayerEventType{PlayerMoved}},
+		{elapsed: 30 * time.Second, types: nil},
+		{elapsed: 2 * time.Minute, types: []PlayerEventType{PlayerIdle}},
+		{elapsed: 3 * time.Minute, move: true, types: []PlayerEventType{PlayerMoved}},
+		{elapsed: 20 * time.Minute, types: []PlayerEventType{PlayerIdle, PlayerIdle}},
+		{elapsed: 2 * time.Hour, types: []PlayerEventType{PlayerLeft}},
+		{elapsed: 3 * time.Hour, move: true, types: []PlayerEventType{PlayerJoined, PlayerMoved}},
+	}
+
+	for i, v := range candidates {
+		stime.SetPermafrost(start.Add(v.elapsed))
+		if v.move {
+			s.updatePosition(&PlayerPosition{ID: "sinmetal", X: float64(i)})
+		} else {
+			s.evaluatePresence()
+		}
+
+		l := receiveEvents(sub)
+		if e, g := len(v.types), len(l); e != g {
+			t.Fatalf("%d : expected %d events; got %+v", i, e, l)
+		}
+		for j, ev := range l {
+			if e, g := v.types[j], ev.Type; e != g {
+				t.Fatalf("%d : expected event[%d] %s; got %s", i, j, e, g)
+			}
+			if e, g := "sinmetal", ev.ID; e != g {
+				t.Fatalf("%d : expected id %s; got %s", i, e, g)
+			}
+			if ev.Type == PlayerMoved || ev.Type == PlayerJoined {
+				if ev.Position == nil {
+					t.Fatalf("%d : expected position in %s event", i, ev.Type)
+				}
+				if e, g := float64(i), ev.Position.X; e != g {
+					t.Fatalf("%d : expected position X %f; got %f", i, e, g)
+				}
+			}
+		}
+	}
+}
+
+func TestPlayerEventHub_SlowConsumer(t *testing.T) {
+	h := NewPlayerEventHub()
+	slow := h.Subscribe(2)
+	fast := h.Subscribe(10)
+
+	for i := 0; i < 5; i++ {
+		h.Publish(PlayerEvent{Type: PlayerMoved, ID: "sinmetal"})
+	}
+
+	if e, g := 2, len(receiveEvents(slow)); e != g {
+		t.Fatalf("expected slow consumer receives %d events; got %d", e, g)
+	}
+	if e, g := uint64(3), slow.Dropped(); e != g {
+		t.Fatalf("expected slow consumer dropped %d events; got %d", e, g)
+	}
+	if e, g := 5, len(receiveEvents(fast)); e != g {
+		t.Fatalf("expected fast consumer receives %d events; got %d", e, g)
+	}
+	if e, g := uint64(0), fast.Dropped(); e != g {
+		t.Fatalf("expected fast consumer dropped %d events; got %d", e, g)
+	}
+
+	// 読み終わった後は、また受け取れる
+	h.Publish(PlayerEvent{Type: PlayerLeft, ID: "sinmetal"})
+	if e, g := 1, len(receiveEvents(slow)); e != g {
+		t.Fatalf("expected slow consumer receives %d events; got %d", e, g)
+	}
+}
+
+func TestPlayerSubscription_Close(t *testing.T) {
+	h := NewPlayerEventHub()
+	sub := h.Subscribe(1)
+	sub.Close()
+	// 2回Closeしてもpanicしない
+	sub.Close()
+
+	h.Publish(PlayerEvent{Type: PlayerMoved, ID: "sinmetal"})
+	if _, ok := <-sub.C; ok {
+		t.Fatalf("expected closed channel")
+	}
+}
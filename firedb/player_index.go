@@ -0,0 +1,87 @@
+package firedb
+
+// ConvertXYToRowCol XY座標からマップの座標を割り出す
+// x -> col
+// y -> row
+func ConvertXYToRowCol(x float64, y float64, scale float64) (row int, col int) {
+	col = int(x / (MapChipWidth * scale))
+	row = int(y / (MapChipHeight * scale))
+
+	return
+}
+
+// TileOf is XY座標から、MapChipWidth, MapChipHeight単位のTileのRow, Colを返す
+// x -> col
+// y -> row
+func TileOf(x float64, y float64) (row int, col int) {
+	return ConvertXYToRowCol(x, y, 1.0)
+}
+
+type tileKey struct {
+	land string
+	row  int
+	col  int
+}
+
+// playerIndex is プレイヤーのIDをいるLandのTileごとにまとめた空間Index
+// MonsterのSense Rangeのような狭い範囲にいるプレイヤーを、全プレイヤーを見ずに探すために使う
+// goroutine safeではないので、呼び出し側でLockを取る
+type playerIndex struct {
+	tiles map[tileKey]map[string]struct{}
+	ids   map[string]tileKey
+}
+
+func newPlayerIndex() *playerIndex {
+	return &playerIndex{
+		tiles: make(map[tileKey]map[string]struct{}),
+		ids:   make(map[string]tileKey),
+	}
+}
+
+// update is プレイヤーのいるLandとTileを更新する
+func (idx *playerIndex) update(id string, land string, x float64, y float64) {
+	row, col := TileOf(x, y)
+	k := tileKey{land: land, row: row, col: col}
+	if old, ok := idx.ids[id]; ok {
+		if old == k {
+			return
+		}
+		idx.remove(id)
+	}
+
+	ids, ok := idx.tiles[k]
+	if !ok {
+		ids = make(map[string]struct{})
+		idx.tiles[k] = ids
+	}
+	ids[id] = struct{}{}
+	idx.ids[id] = k
+}
+
+// remove is プレイヤーをIndexから取り除く
+func (idx *playerIndex) remove(id string) {
+	k, ok := idx.ids[id]
+	if !ok {
+		return
+	}
+	delete(idx.ids, id)
+	ids := idx.tiles[k]
+	delete(ids, id)
+	if len(ids) == 0 {
+		delete(idx.tiles, k)
+	}
+}
+
+// queryRect is landの (row, col) を左上として、rows x cols の範囲のTileにいるプレイヤーのIDを返す
+// 範囲のTileの数と、範囲にいるプレイヤーの数に比例した時間で終わる
+func (idx *playerIndex) queryRect(land string, row int, col int, rows int, cols int) []string {
+	var l []string
+	for r := row; r < row+rows; r++ {
+		for c := col; c < col+cols; c++ {
+			for id := range idx.tiles[tileKey{land: land, row: r, col: c}] {
+				l = append(l, id)
+			}
+		}
+	}
+	return l
+}
@@ -0,0 +1,123 @@
+package firedb
+
+import (
+	"sort"
+	"testing"
+)
+
+func TestTileOf(t *testing.T) {
+	candidates := []struct {
+		x   float64
+		y   float64
+		row int
+		col int
+	}{
+		{x: 0, y: 0, row: 0, col: 0},
+		{x: 31.9, y: 32, row: 1, col: 0},
+		{x: 950, y: 1000, row: 31, col: 29},
+	}
+
+	for i, v := range candidates {
+		row, col := TileOf(v.x, v.y)
+		if e, g := v.row, row; e != g {
+			t.Fatalf("%d : expected row %d; got %d", i, e, g)
+		}
+		if e, g := v.col, col; e != g {
+			t.Fatalf("%d : expected col %d; got %d", i, e, g)
+		}
+	}
+}
+
+func TestDefaultPlayerStore_QueryRect(t *testing.T) {
+	s := newDefaultPlayerStore(DefaultWorld(), DefaultPresenceThresholds())
+	// row=31, col=29
+	s.updatePosition(&PlayerPosition{ID: "a", X: 950, Y: 1000})
+	// row=31, col=29 と同じTile
+	s.updatePosition(&PlayerPosition{ID: "b", X: 940, Y: 1010})
+	// row=35, col=33
+	s.updatePosition(&PlayerPosition{ID: "c", X: 33 * 32, Y: 35 * 32})
+	// 遠く
+	s.updatePosition(&PlayerPosition{ID: "d", X: 5000, Y: 5000})
+	// 別のLandの row=31, col=29
+	s.updatePosition(&PlayerPosition{ID: "e", X: 950, Y: 1000, Land: "dungeon1"})
+	// Landを明示した row=35, col=33
+	s.updatePosition(&PlayerPosition{ID: "f", X: 33 * 32, Y: 35 * 32, Land: DefaultLandID})
+
+	candidates := []struct {
+		land string
+		row  int
+		col  int
+		rows int
+		cols int
+		ids  []string
+	}{
+		{land: DefaultLandID, row: 31, col: 29, rows: 1, cols: 1, ids: []string{"a", "b"}},
+		{land: "", row: 31, col: 29, rows: 1, cols: 1, ids: []string{"a", "b"}},
+		{land: DefaultLandID, row: 27, col: 25, rows: 8, cols: 8, ids: []string{"a", "b"}},
+		{land: DefaultLandID, row: 28, col: 26, rows: 8, cols: 8, ids: []string{"a", "b", "c", "f"}},
+		{land: DefaultLandID, row: 32, col: 30, rows: 8, cols: 8, ids: []string{"c", "f"}},
+		{land: DefaultLandID, row: 0, col: 0, rows: 8, cols: 8, ids: []string{}},
+		{land: DefaultLandID, row: 31, col: 29, rows: 0, cols: 8, ids: []string{}},
+		{land: "dungeon1", row: 31, col: 29, rows: 1, cols: 1, ids: []string{"e"}},
+		{land: "dungeon1", row: 28, col: 26, rows: 8, cols: 8, ids: []string{"e"}},
+		{land: "dungeon2", row: 28, col: 26, rows: 8, cols: 8, ids: []string{}},
+	}
+
+	for i, v := range candidates {
+		l := s.QueryRect(v.land, v.row, v.col, v.rows, v.cols)
+		ids := make([]string, 0, len(l))
+		for _, p := range l {
+			ids = append(ids, p.ID)
+		}
+		sort.Strings(ids)
+		if e, g := len(v.ids), len(ids); e != g {
+			t.Fatalf("%d : expected %v; got %v", i, v.ids, ids)
+		}
+		for j := range v.ids {
+			if e, g := v.ids[j], ids[j]; e != g {
+				t.Fatalf("%d : expected %v; got %v", i, v.ids, ids)
+			}
+		}
+	}
+
+	// 移動すると、元のTileからはいなくなる
+	s.updatePosition(&PlayerPosition{ID: "a", X: 33 * 32, Y: 35 * 32})
+	if e, g := 1, len(s.QueryRect("", 31, 29, 1, 1)); e != g {
+		t.Fatalf("expected %d players in old tile; got %d", e, g)
+	}
+	l := s.QueryRect("", 35, 33, 1, 1)
+	if e, g := 3, len(l); e != g {
+		t.Fatalf("expected %d players in new tile; got %d", e, g)
+	}
+
+	// 別のLandに移ると、元のLandからはいなくなる
+	s.updatePosition(&PlayerPosition{ID: "e", X: 950, Y: 1000, Land: "dungeon2"})
+	if e, g := 0, len(s.QueryRect("dungeon1", 31, 29, 1, 1)); e != g {
+		t.Fatalf("expected %d players in old land; got %d", e, g)
+	}
+	if e, g := 1, len(s.QueryRect("dungeon2", 31, 29, 1, 1)); e != g {
+		t.Fatalf("expected %d players in new land; got %d", e, g)
+	}
+
+	// 返した値を書き換えてもStoreには影響しない
+	l[0].X = -1
+	for _, p := range s.QueryRect("", 35, 33, 1, 1) {
+		if p.X < 0 {
+			t.Fatalf("%s is modified by QueryRect caller", p.ID)
+		}
+	}
+}
+
+func TestPlayerIndex_Remove(t *testing.T) {
+	idx := newPlayerIndex()
+	idx.update("a", DefaultLandID, 0, 0)
+	idx.remove("a")
+	idx.remove("unknown")
+
+	if e, g := 0, len(idx.queryRect(DefaultLandID, 0, 0, 1, 1)); e != g {
+		t.Fatalf("expected %d; got %d", e, g)
+	}
+	if e, g := 0, len(idx.tiles); e != g {
+		t.Fatalf("expected empty tiles are removed. got %d", g)
+	}
+}
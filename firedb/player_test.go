@@ -2,13 +2,17 @@ package firedb
 
 import (
 	"context"
+	"fmt"
 	"sync"
 	"testing"
 	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sinmetal/stime"
 )
 
 type dummyPlayerStore struct {
-	UpdateActiveUserCount int
+	CheckPresenceCount int
 }
 
 func (s *dummyPlayerStore) Watch(ctx context.Context, path string) error {
@@ -23,9 +27,9 @@ func (s *dummyPlayerStore) GetPositionMap() *sync.Map {
 	return nil
 }
 
-func (s *dummyPlayerStore) UpdateActiveUser(ctx context.Context, id string, active bool) error {
-	s.UpdateActiveUserCount++
-	return nil
+func (s *dummyPlayerStore) CheckPresence(ctx context.Context) ([]PresenceTransition, error) {
+	s.CheckPresenceCount++
+	return nil, nil
 }
 
 func TestExistsActivePlayer(t *testing.T) {
@@ -54,37 +58,221 @@ func TestExistsActivePlayer(t *testing.T) {
 	}
 }
 
-func TestIsChangeActiveStatus(t *testing.T) {
-	candidates := []struct {
-		id        string
-		playerMap map[string]*User
-		change    bool
-	}{
-		{
-			id:        "hogeUserID1",
-			playerMap: map[string]*User{},
-			change:    true,
-		},
-		{
-			id:        "hogeUserID2",
-			playerMap: map[string]*User{"hogeUserID2": &User{Active: false, UpdatedAt: time.Now()}},
-			change:    true,
-		},
-		{
-			id:        "hogeUserID2",
-			playerMap: map[string]*User{"hogeUserID2": &User{Active: true, UpdatedAt: time.Now()}},
-			change:    false,
-		},
-		{
-			id:        "hogeUserID3",
-			playerMap: map[string]*User{"hogeUserID3": &User{Active: true, UpdatedAt: time.Now().Add(time.Minute * -11)}},
-			change:    true,
-		},
+// TestDefaultPlayerStore_Concurrent is Watchと同じ更新をしながら、Snapshotを並行して読む
+// go test -race で実行して、Data Raceが起きないことを確認する
+func TestDefaultPlayerStore_Concurrent(t *testing.T) {
+	s := newDefaultPlayerStore(DefaultWorld(), DefaultPresenceThresholds())
+
+	const writers = 4
+	const readers = 4
+	const loop = 500
+
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < loop; j++ {
+				id := fmt.Sprintf("player-%d", j%10)
+				pp := &PlayerPosition{ID: id, X: float64(i), Y: float64(j), FirestoreUpdateAt: time.Now()}
+				s.updatePosition(pp)
+				if j%7 == 0 {
+					s.evaluatePresence()
+				}
+			}
+		}(i)
+	}
+	for i := 0; i < readers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < loop; j++ {
+				// Snapshotの値を書き換えてもStoreには影響しない
+				for _, v := range s.GetPositionMapSnapshot() {
+					v.X = -1
+				}
+				for _, v := range s.GetPlayerMapSnapshot() {
+					v.Active = !v.Active
+				}
+				if pp := s.GetPosition(fmt.Sprintf("player-%d", j%10)); pp != nil {
+					pp.Y = -1
+				}
+				ExistsActivePlayer(s.GetPlayerMapSnapshot())
+			}
+		}()
 	}
+	wg.Wait()
 
-	for i, v := range candidates {
-		if e, g := v.change, isChangeActiveStatus(v.playerMap, v.id); e != g {
-			t.Fatalf("%d : expected %t; got %t", i, e, g)
+	pm := s.GetPositionMapSnapshot()
+	if e, g := 10, len(pm); e != g {
+		t.Fatalf("expected len(positionMap) is %d; got %d", e, g)
+	}
+	for k, v := range pm {
+		if v.X < 0 || v.Y < 0 {
+			t.Fatalf("%s is modified by snapshot reader. %+v", k, v)
+		}
+	}
+}
+
+func TestDefaultPlayerStore_SnapshotIsCopy(t *testing.T) {
+	s := newDefaultPlayerStore(DefaultWorld(), DefaultPresenceThresholds())
+	s.updatePosition(&PlayerPosition{ID: "sinmetal", X: 100, Y: 200})
+
+	s.GetPositionMapSnapshot()["sinmetal"].X = 0
+	s.GetPosition("sinmetal").Y = 0
+	s.GetPlayerMapSnapshot()["sinmetal"].Active = false
+
+	pp := s.GetPosition("sinmetal")
+	if e, g := 100.0, pp.X; e != g {
+		t.Fatalf("expected X is %f; got %f", e, g)
+	}
+	if e, g := 200.0, pp.Y; e != g {
+		t.Fatalf("expected Y is %f; got %f", e, g)
+	}
+	if e, g := true, s.GetPlayerMapSnapshot()["sinmetal"].Active; e != g {
+		t.Fatalf("expected Active is %t; got %t", e, g)
+	}
+	if s.GetPosition("unknown") != nil {
+		t.Fatalf("expected unknown player position is nil")
+	}
+}
+
+func TestDefaultPlayerStore_EvictOffline(t *testing.T) {
+	start := time.Date(2019, 2, 1, 10, 0, 0, 0, time.UTC)
+	stime.SetPermafrost(start)
+	defer stime.SetPermafrost(time.Time{})
+
+	s := newDefaultPlayerStore(DefaultWorld(), DefaultPresenceThresholds())
+	s.updatePosition(&PlayerPosition{ID: "sinmetal", X: 100, Y: 200})
+	s.updatePosition(&PlayerPosition{ID: "vvakame", X: 300, Y: 400})
+
+	// vvakameだけ動き続ける
+	stime.SetPermafrost(start.Add(50 * time.Minute))
+	s.updatePosition(&PlayerPosition{ID: "vvakame", X: 300, Y: 400})
+
+	stime.SetPermafrost(start.Add(1 * time.Hour))
+	offline := false
+	for _, tr := range s.evaluatePresence() {
+		if tr.ID == "sinmetal" && tr.To == PresenceOffline {
+			offline = true
 		}
 	}
+	if !offline {
+		t.Fatalf("expected sinmetal is offline")
+	}
+
+	if _, ok := s.GetPlayerMapSnapshot()["sinmetal"]; ok {
+		t.Fatalf("expected sinmetal is evicted from playerMap")
+	}
+	if s.GetPosition("sinmetal") != nil {
+		t.Fatalf("expected sinmetal is evicted from positionMap")
+	}
+	if e, g := PresenceUnknown, s.presence.State("sinmetal"); e != g {
+		t.Fatalf("expected presence is %s; got %s", e, g)
+	}
+	row, col := TileOf(100, 200)
+	if l := s.QueryRect(DefaultLandID, row, col, 1, 1); len(l) != 0 {
+		t.Fatalf("expected sinmetal is evicted from positionIndex; got %+v", l)
+	}
+	if e, g := 1, len(s.GetPositionMapSnapshot()); e != g {
+		t.Fatalf("expected len(positionMap) is %d; got %d", e, g)
+	}
+
+	// vvakameもofflineになると、全てのMapが空になる
+	stime.SetPermafrost(start.Add(2 * time.Hour))
+	s.evaluatePresence()
+	if e, g := 0, len(s.GetPlayerMapSnapshot()); e != g {
+		t.Fatalf("expected len(playerMap) is %d; got %d", e, g)
+	}
+	if e, g := 0, len(s.GetPositionMapSnapshot()); e != g {
+		t.Fatalf("expected len(positionMap) is %d; got %d", e, g)
+	}
+	if e, g := 0, len(s.presence.players); e != g {
+		t.Fatalf("expected len(presence) is %d; got %d", e, g)
+	}
+	if e, g := 0, len(s.positionIndex.tiles); e != g {
+		t.Fatalf("expected len(positionIndex) is %d; got %d", e, g)
+	}
+
+	// 次に動いた時は、初めて見たプレイヤーとして扱う
+	tr, ok := s.updatePosition(&PlayerPosition{ID: "sinmetal", X: 100, Y: 200})
+	if !ok || tr.From != PresenceUnknown || tr.To != PresenceActive {
+		t.Fatalf("expected unknown -> active; got %+v", tr)
+	}
+	if l := s.QueryRect(DefaultLandID, row, col, 1, 1); len(l) != 1 {
+		t.Fatalf("expected sinmetal is in positionIndex; got %+v", l)
+	}
+}
+
+func TestDefaultPlayerStore_RetryPresence(t *testing.T) {
+	start := time.Date(2019, 2, 1, 10, 0, 0, 0, time.UTC)
+	stime.SetPermafrost(start)
+	defer stime.SetPermafrost(time.Time{})
+
+	ctx := context.Background()
+	s := newDefaultPlayerStore(DefaultWorld(), DefaultPresenceThresholds())
+	var written []PresenceTransition
+	var failed error
+	s.updatePresence = func(ctx context.Context, t PresenceTransition) error {
+		if failed != nil {
+			return failed
+		}
+		written = append(written, t)
+		return nil
+	}
+
+	// 動いた時の書き込みに失敗しても、PresenceMachineは既にactiveになっている
+	failed = errors.New("unavailable")
+	tr, ok := s.updatePosition(&PlayerPosition{ID: "sinmetal", X: 100, Y: 200})
+	if !ok {
+		t.Fatalf("expected unknown -> active")
+	}
+	if err := s.writePresence(ctx, tr); err == nil {
+		t.Fatalf("expected error")
+	}
+	if _, ok := s.updatePosition(&PlayerPosition{ID: "sinmetal", X: 100, Y: 200}); ok {
+		t.Fatalf("expected no transition while active")
+	}
+
+	// 次のCheckPresenceでも失敗した場合は、その次にもう一度書き込む
+	if _, err := s.CheckPresence(ctx); err == nil {
+		t.Fatalf("expected error")
+	}
+	failed = nil
+	if _, err := s.CheckPresence(ctx); err != nil {
+		t.Fatalf("failed CheckPresence. err=%+v", err)
+	}
+	if e, g := 1, len(written); e != g {
+		t.Fatalf("expected %d written; got %d", e, g)
+	}
+	if written[0].ID != "sinmetal" || written[0].To != PresenceActive {
+		t.Fatalf("expected sinmetal is active; got %+v", written[0])
+	}
+
+	// 書き込めた後は、もう一度書き込まない
+	if _, err := s.CheckPresence(ctx); err != nil {
+		t.Fatalf("failed CheckPresence. err=%+v", err)
+	}
+	if e, g := 1, len(written); e != g {
+		t.Fatalf("expected %d written; got %d", e, g)
+	}
+
+	// 失敗した後に在席状態が進んだ場合は、今の状態を書き込む
+	failed = errors.New("unavailable")
+	stime.SetPermafrost(start.Add(1 * time.Minute))
+	if _, err := s.CheckPresence(ctx); err == nil {
+		t.Fatalf("expected error")
+	}
+	failed = nil
+	stime.SetPermafrost(start.Add(15 * time.Minute))
+	l, err := s.CheckPresence(ctx)
+	if err != nil {
+		t.Fatalf("failed CheckPresence. err=%+v", err)
+	}
+	if e, g := 2, len(l); e != g {
+		t.Fatalf("expected %d transitions; got %d", e, g)
+	}
+	if e, g := PresencePassive, written[len(written)-1].To; e != g {
+		t.Fatalf("expected last written is %s; got %s", e, g)
+	}
 }
@@ -0,0 +1,176 @@
+package firedb
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/sinmetal/stime"
+)
+
+// PresenceState is プレイヤーの在席状態
+// unknown → active → idle → passive → offline の順に、最後に動いてからの時間で遷移する
+// どの状態からでも、動けば active に戻る
+type PresenceState string
+
+const (
+	// PresenceUnknown is まだ一度も動いているのを見ていない状態
+	PresenceUnknown PresenceState = "unknown"
+
+	// PresenceActive is 動いている状態
+	PresenceActive PresenceState = "active"
+
+	// PresenceIdle is ログインしているが、しばらく動いていない状態
+	PresenceIdle PresenceState = "idle"
+
+	// PresencePassive is 長い間動いていないので、Monsterを動かす対象にしない状態
+	PresencePassive PresenceState = "passive"
+
+	// PresenceOffline is いなくなったと判断した状態
+	PresenceOffline PresenceState = "offline"
+)
+
+// Active is `world-{world}-users` の active として扱うかどうかを返す
+// idle の間はまだ近くにいるかもしれないので、activeとして扱う
+func (s PresenceState) Active() bool {
+	return s == PresenceActive || s == PresenceIdle
+}
+
+// PresenceThresholds is 最後に動いてから、各状態に遷移するまでの時間
+type PresenceThresholds struct {
+	Idle    time.Duration
+	Passive time.Duration
+	Offline time.Duration
+}
+
+// DefaultPresenceThresholds is デフォルトの遷移時間
+func DefaultPresenceThresholds() PresenceThresholds {
+	return PresenceThresholds{
+		Idle:    1 * time.Minute,
+		Passive: 15 * time.Minute,
+		Offline: 1 * time.Hour,
+	}
+}
+
+// Validate is Idle < Passive < Offline の順になっているかを確認する
+func (t PresenceThresholds) Validate() error {
+	if t.Idle <= 0 {
+		return fmt.Errorf("idle threshold must be positive. idle = %s", t.Idle)
+	}
+	if t.Passive <= t.Idle {
+		return fmt.Errorf("passive threshold must be longer than idle. idle = %s, passive = %s", t.Idle, t.Passive)
+	}
+	if t.Offline <= t.Passive {
+		return fmt.Errorf("offline threshold must be longer than passive. passive = %s, offline = %s", t.Passive, t.Offline)
+	}
+	return nil
+}
+
+// PresenceTransition is プレイヤーの在席状態が変わったEvent
+type PresenceTransition struct {
+	ID   string
+	From PresenceState
+	To   PresenceState
+	At   time.Time
+}
+
+type presence struct {
+	state    PresenceState
+	lastSeen time.Time
+}
+
+// PresenceMachine is プレイヤーごとの在席状態を管理するState Machine
+// 時刻はstimeから取るので、Testでは stime.SetPermafrost で時間を進められる
+// goroutine safeではないので、呼び出し側でLockを取る
+type PresenceMachine struct {
+	thresholds PresenceThresholds
+	players    map[string]*presence
+}
+
+// NewPresenceMachine is PresenceMachineを生成する
+func NewPresenceMachine(thresholds PresenceThresholds) *PresenceMachine {
+	return &PresenceMachine{
+		thresholds: thresholds,
+		players:    make(map[string]*presence),
+	}
+}
+
+// State is プレイヤーの在席状態を返す
+func (m *PresenceMachine) State(id string) PresenceState {
+	p, ok := m.players[id]
+	if !ok {
+		return PresenceUnknown
+	}
+	return p.state
+}
+
+// Seen is プレイヤーが動いたことを記録する
+// active 以外の状態から active に変わった場合は、その遷移を返す
+func (m *PresenceMachine) Seen(id string) (PresenceTransition, bool) {
+	now := stime.Now()
+	p, ok := m.players[id]
+	if !ok {
+		p = &presence{state: PresenceUnknown}
+		m.players[id] = p
+	}
+	p.lastSeen = now
+	if p.state == PresenceActive {
+		return PresenceTransition{}, false
+	}
+
+	t := PresenceTransition{ID: id, From: p.state, To: PresenceActive, At: now}
+	p.state = PresenceActive
+	return t, true
+}
+
+// Forget is プレイヤーの在席状態を捨てる
+// 次に Seen した時は unknown から active に遷移する
+func (m *PresenceMachine) Forget(id string) {
+	delete(m.players, id)
+}
+
+// Evaluate is 最後に動いてからの時間で、全プレイヤーの在席状態を進める
+// 長い間呼ばれなかった場合も、途中の状態を飛ばさずに1つずつ遷移を返す
+// 遷移はプレイヤーのID順に並べる
+func (m *PresenceMachine) Evaluate() []PresenceTransition {
+	now := stime.Now()
+	ids := make([]string, 0, len(m.players))
+	for id := range m.players {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var l []PresenceTransition
+	for _, id := range ids {
+		p := m.players[id]
+		for {
+			next, ok := m.next(p, now)
+			if !ok {
+				break
+			}
+			l = append(l, PresenceTransition{ID: id, From: p.state, To: next, At: now})
+			p.state = next
+		}
+	}
+	return l
+}
+
+// next is 時間経過で次に遷移する状態を返す
+func (m *PresenceMachine) next(p *presence, now time.Time) (PresenceState, bool) {
+	elapsed := now.Sub(p.lastSeen)
+	switch p.state {
+	case PresenceActive:
+		if elapsed >= m.thresholds.Idle {
+			return PresenceIdle, true
+		}
+	case PresenceIdle:
+		if elapsed >= m.thresholds.Passive {
+			return PresencePassive, true
+		}
+	case PresencePassive:
+		if elapsed >= m.thresholds.Offline {
+			return PresenceOffline, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,191 @@
+package firedb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sinmetal/stime"
+)
+
+func TestPresenceMachine(t *testing.T) {
+	th := PresenceThresholds{
+		Idle:    1 * time.Minute,
+		Passive: 15 * time.Minute,
+		Offline: 1 * time.Hour,
+	}
+
+	// step is 開始時刻からの経過時間と、その時刻に動いたかどうか
+	type step struct {
+		elapsed     time.Duration
+		seen        bool
+		state       PresenceState
+		transitions []PresenceState
+	}
+
+	candidates := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "unknown",
+			steps: []step{
+				{elapsed: 0, state: PresenceUnknown},
+				{elapsed: 2 * time.Hour, state: PresenceUnknown},
+			},
+		},
+		{
+			name: "active to offline",
+			steps: []step{
+				{elapsed: 0, seen: true, state: PresenceActive, transitions: []PresenceState{PresenceActive}},
+				{elapsed: 59 * time.Second, state: PresenceActive},
+				{elapsed: 1 * time.Minute, state: PresenceIdle, transitions: []PresenceState{PresenceIdle}},
+				{elapsed: 15 * time.Minute, state: PresencePassive, transitions: []PresenceState{PresencePassive}},
+				{elapsed: 59 * time.Minute, state: PresencePassive},
+				{elapsed: 1 * time.Hour, state: PresenceOffline, transitions: []PresenceState{PresenceOffline}},
+				{elapsed: 2 * time.Hour, state: PresenceOffline},
+			},
+		},
+		{
+			name: "keep active while moving",
+			steps: []step{
+				{elapsed: 0, seen: true, state: PresenceActive, transitions: []PresenceState{PresenceActive}},
+				{elapsed: 50 * time.Second, seen: true, state: PresenceActive},
+				{elapsed: 100 * time.Second, state: PresenceActive},
+				{elapsed: 110 * time.Second, state: PresenceIdle, transitions: []PresenceState{PresenceIdle}},
+			},
+		},
+		{
+			name: "back to active",
+			steps: []step{
+				{elapsed: 0, seen: true, state: PresenceActive, transitions: []PresenceState{PresenceActive}},
+				{elapsed: 20 * time.Minute, state: PresencePassive, transitions: []PresenceState{PresenceIdle, PresencePassive}},
+				{elapsed: 21 * time.Minute, seen: true, state: PresenceActive, transitions: []PresenceState{PresenceActive}},
+			},
+		},
+		{
+			name: "skip evaluate for a long time",
+			steps: []step{
+				{elapsed: 0, seen: true, state: PresenceActive, transitions: []PresenceState{PresenceActive}},
+				{elapsed: 3 * time.Hour, state: PresenceOffline, transitions: []PresenceState{PresenceIdle, PresencePassive, PresenceOffline}},
+				{elapsed: 4 * time.Hour, seen: true, state: PresenceActive, transitions: []PresenceState{PresenceActive}},
+			},
+		},
+	}
+
+	start := time.Date(2019, 2, 1, 10, 0, 0, 0, time.UTC)
+	defer stime.SetPermafrost(time.Time{})
+	for _, c := range candidates {
+		m := NewPresenceMachine(th)
+		for i, v := range c.steps {
+			stime.SetPermafrost(start.Add(v.elapsed))
+
+			var got []PresenceTransition
+			if v.seen {
+				if tr, ok := m.Seen("sinmetal"); ok {
+					got = append(got, tr)
+				}
+			} else {
+				got = m.Evaluate()
+			}
+
+			if e, g := v.state, m.State("sinmetal"); e != g {
+				t.Fatalf("%s %d : expected state %s; got %s", c.name, i, e, g)
+			}
+			if e, g := len(v.transitions), len(got); e != g {
+				t.Fatalf("%s %d : expected %d transitions; got %+v", c.name, i, e, got)
+			}
+			for j, tr := range got {
+				if e, g := v.transitions[j], tr.To; e != g {
+					t.Fatalf("%s %d : expected transition[%d] to %s; got %s", c.name, i, j, e, g)
+				}
+				if j > 0 && tr.From != got[j-1].To {
+					t.Fatalf("%s %d : transition[%d] from %s is not continuous", c.name, i, j, tr.From)
+				}
+				if e, g := start.Add(v.elapsed), tr.At; !e.Equal(g) {
+					t.Fatalf("%s %d : expected transition at %s; got %s", c.name, i, e, g)
+				}
+			}
+		}
+	}
+}
+
+func TestPresenceState_Active(t *testing.T) {
+	candidates := []struct {
+		state  PresenceState
+		active bool
+	}{
+		{state: PresenceUnknown, active: false},
+		{state: PresenceActive, active: true},
+		{state: PresenceIdle, active: true},
+		{state: PresencePassive, active: false},
+		{state: PresenceOffline, active: false},
+	}
+
+	for i, v := range candidates {
+		if e, g := v.active, v.state.Active(); e != g {
+			t.Fatalf("%d : expected %t; got %t", i, e, g)
+		}
+	}
+}
+
+func TestPresenceThresholds_Validate(t *testing.T) {
+	candidates := []struct {
+		thresholds PresenceThresholds
+		valid      bool
+	}{
+		{thresholds: DefaultPresenceThresholds(), valid: true},
+		{thresholds: PresenceThresholds{Idle: 0, Passive: time.Minute, Offline: time.Hour}, valid: false},
+		{thresholds: PresenceThresholds{Idle: time.Minute, Passive: time.Minute, Offline: time.Hour}, valid: false},
+		{thresholds: PresenceThresholds{Idle: time.Minute, Passive: time.Hour, Offline: time.Hour}, valid: false},
+	}
+
+	for i, v := range candidates {
+		err := v.thresholds.Validate()
+		if e, g := v.valid, err == nil; e != g {
+			t.Fatalf("%d : expected valid %t; got %v", i, e, err)
+		}
+	}
+}
+
+func TestDefaultPlayerStore_Presence(t *testing.T) {
+	start := time.Date(2019, 2, 1, 10, 0, 0, 0, time.UTC)
+	stime.SetPermafrost(start)
+	defer stime.SetPermafrost(time.Time{})
+
+	s := newDefaultPlayerStore(DefaultWorld(), DefaultPresenceThresholds())
+	if _, ok := s.updatePosition(&PlayerPosition{ID: "sinmetal"}); !ok {
+		t.Fatalf("expected transition to active")
+	}
+	if _, ok := s.updatePosition(&PlayerPosition{ID: "sinmetal"}); ok {
+		t.Fatalf("expected no transition while active")
+	}
+	u := s.GetPlayerMapSnapshot()["sinmetal"]
+	if e, g := PresenceActive, u.Presence; e != g {
+		t.Fatalf("expected presence %s; got %s", e, g)
+	}
+	if !ExistsActivePlayer(s.GetPlayerMapSnapshot()) {
+		t.Fatalf("expected active player exists")
+	}
+
+	stime.SetPermafrost(start.Add(2 * time.Minute))
+	l := s.evaluatePresence()
+	if e, g := 1, len(l); e != g {
+		t.Fatalf("expected %d transitions; got %+v", e, l)
+	}
+	if !ExistsActivePlayer(s.GetPlayerMapSnapshot()) {
+		t.Fatalf("expected idle player is active")
+	}
+
+	stime.SetPermafrost(start.Add(16 * time.Minute))
+	s.evaluatePresence()
+	u = s.GetPlayerMapSnapshot()["sinmetal"]
+	if e, g := PresencePassive, u.Presence; e != g {
+		t.Fatalf("expected presence %s; got %s", e, g)
+	}
+	if e, g := start.Add(16*time.Minute), u.UpdatedAt; !e.Equal(g) {
+		t.Fatalf("expected UpdatedAt %s; got %s", e, g)
+	}
+	if ExistsActivePlayer(s.GetPlayerMapSnapshot()) {
+		t.Fatalf("expected no active player")
+	}
+}
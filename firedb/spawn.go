@@ -0,0 +1,135 @@
+package firedb
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"google.golang.org/api/iterator"
+)
+
+// SpawnStore is Monsterの出現地点に関するFirestoreとのやりとりの役割を持つ
+type SpawnStore interface {
+	ListPoints(ctx context.Context) ([]*SpawnPoint, error)
+	ListAreas(ctx context.Context) ([]*SpawnArea, error)
+}
+
+// SpawnPoint is Monsterの出現地点
+// Documentは `world-{world}{revision}-land-{land}-spawn-point` に、Fieldと並べて置く
+type SpawnPoint struct {
+	ID  string `firestore:"-" json:"id"`
+	Row int    `firestore:"row" json:"row"`
+	Col int    `firestore:"col" json:"col"`
+
+	// MonsterType is 出現させるMonsterの種類
+	MonsterType string `firestore:"monsterType" json:"monsterType"`
+
+	// MaxAlive is この地点から出現して、同時に生きていられるMonsterの数
+	MaxAlive int `firestore:"maxAlive" json:"maxAlive"`
+
+	// RespawnDelaySec is Monsterがいなくなってから、次を出現させるまでの秒数
+	RespawnDelaySec int `firestore:"respawnDelaySec" json:"respawnDelaySec"`
+
+	// Area is 出現地点が属するSpawnAreaのID。空の場合はAreaの上限を受けない
+	Area string `firestore:"area" json:"area"`
+}
+
+// RespawnDelay is RespawnDelaySecをtime.Durationで返す
+func (p *SpawnPoint) RespawnDelay() time.Duration {
+	return time.Duration(p.RespawnDelaySec) * time.Second
+}
+
+// Validate is rows x cols のFieldに置くSpawnPointとして使えるかを確認する
+func (p *SpawnPoint) Validate(rows int, cols int) error {
+	if p.ID == "" {
+		return fmt.Errorf("spawn point id is required")
+	}
+	if p.Row < 0 || p.Row >= rows || p.Col < 0 || p.Col >= cols {
+		return fmt.Errorf("spawn point %s is out of field. row = %d, col = %d", p.ID, p.Row, p.Col)
+	}
+	if p.MaxAlive < 0 {
+		return fmt.Errorf("spawn point %s maxAlive must not be negative. maxAlive = %d", p.ID, p.MaxAlive)
+	}
+	if p.RespawnDelaySec < 0 {
+		return fmt.Errorf("spawn point %s respawnDelaySec must not be negative. respawnDelaySec = %d", p.ID, p.RespawnDelaySec)
+	}
+	return nil
+}
+
+// SpawnArea is 複数のSpawnPointをまとめて、同時に生きていられるMonsterの数を制限する範囲
+// Documentは `world-{world}{revision}-land-{land}-spawn-area` に置く
+type SpawnArea struct {
+	ID string `firestore:"-" json:"id"`
+
+	// MaxAlive is Area内のSpawnPointから出現して、同時に生きていられるMonsterの数
+	MaxAlive int `firestore:"maxAlive" json:"maxAlive"`
+}
+
+type spawnStoreImple struct {
+	world *World
+}
+
+var spawnStore SpawnStore
+
+// NewSpawnStoreWithWorld is 指定したWorldのCollectionを扱うSpawnStoreを生成する
+// SetSpawnStoreで差し替えられている場合は、差し替えた実装を返す
+func NewSpawnStoreWithWorld(w *World) SpawnStore {
+	if spawnStore != nil {
+		return spawnStore
+	}
+	return &spawnStoreImple{world: w}
+}
+
+// SetSpawnStore is SpawnStoreの実装を差し替える
+// Unit Testのために利用する
+func SetSpawnStore(s SpawnStore) {
+	spawnStore = s
+}
+
+// ListPoints is Landの全てのSpawnPointを取得する
+func (s *spawnStoreImple) ListPoints(ctx context.Context) ([]*SpawnPoint, error) {
+	iter := db.Collection(s.world.SpawnPointPath()).Documents(ctx)
+	defer iter.Stop()
+
+	var l []*SpawnPoint
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var p SpawnPoint
+		if err := doc.DataTo(&p); err != nil {
+			return nil, err
+		}
+		p.ID = doc.Ref.ID
+		l = append(l, &p)
+	}
+	return l, nil
+}
+
+// ListAreas is Landの全てのSpawnAreaを取得する
+func (s *spawnStoreImple) ListAreas(ctx context.Context) ([]*SpawnArea, error) {
+	iter := db.Collection(s.world.SpawnAreaPath()).Documents(ctx)
+	defer iter.Stop()
+
+	var l []*SpawnArea
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var a SpawnArea
+		if err := doc.DataTo(&a); err != nil {
+			return nil, err
+		}
+		a.ID = doc.Ref.ID
+		l = append(l, &a)
+	}
+	return l, nil
+}
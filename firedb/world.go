@@ -0,0 +1,157 @@
+package firedb
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// DefaultWorldID is 既存のデータが入っているWorldのID
+	DefaultWorldID = "default"
+
+	// DefaultLandID is 既存のデータが入っているLandのID
+	DefaultLandID = "home"
+
+	// DefaultFieldRevision is 既存のField Collectionの名前に付いているRevision
+	DefaultFieldRevision = "20170908"
+)
+
+// World is Firestoreの各Collectionのpathを決めるための、WorldとLandの設定
+// 全てのStoreはWorldからpathを組み立てるので、Worldを変えるだけで別のWorldを扱ったり、Test用のCollectionに分けたりできる
+type World struct {
+	ID     string
+	LandID string
+
+	// FieldRevision is Field Collectionの名前に付くRevision
+	// `world-default20170908-land-home` のようにWorld IDの直後に付く。空の場合は付けない
+	FieldRevision string
+
+	// Rows, Cols is LandのFieldの縦幅と横幅
+	// LandのメタデータのDocumentから読み込む。0の場合は MapSizeRow, MapSizeCol を使う
+	Rows int
+	Cols int
+}
+
+// DefaultWorld is 既存のCollectionを指すWorldを返す
+func DefaultWorld() *World {
+	return &World{
+		ID:            DefaultWorldID,
+		LandID:        DefaultLandID,
+		FieldRevision: DefaultFieldRevision,
+	}
+}
+
+// Validate is Collectionのpathとして使えるかを確認する
+func (w *World) Validate() error {
+	if err := w.ValidateWorld(); err != nil {
+		return err
+	}
+	if w.LandID == "" {
+		return fmt.Errorf("land id is required")
+	}
+	if strings.Contains(w.LandID, "/") {
+		return fmt.Errorf("world config must not contain '/'. value = %s", w.LandID)
+	}
+	if w.Rows < 0 || w.Cols < 0 {
+		return fmt.Errorf("field size must not be negative. rows = %d, cols = %d", w.Rows, w.Cols)
+	}
+	return nil
+}
+
+// ValidateWorld is Landに依らないCollectionのpathとして使えるかを確認する
+// PlayerStore, MonsterTypeStoreのように、全てのLandで共有するStoreに渡すWorldはLandIDが無くても良い
+func (w *World) ValidateWorld() error {
+	if w.ID == "" {
+		return fmt.Errorf("world id is required")
+	}
+	for _, v := range []string{w.ID, w.FieldRevision} {
+		if strings.Contains(v, "/") {
+			return fmt.Errorf("world config must not contain '/'. value = %s", v)
+		}
+	}
+	return nil
+}
+
+// ForLand is 同じWorldの、指定したLandを指すWorldのCopyを返す
+// Landの大きさはLandごとに違うので、Copyには引き継がない
+func (w *World) ForLand(landID string) *World {
+	return &World{
+		ID:            w.ID,
+		LandID:        landID,
+		FieldRevision: w.FieldRevision,
+	}
+}
+
+// FieldSize is LandのFieldの縦幅と横幅を返す
+// Rows, Colsが指定されていない場合は MapSizeRow, MapSizeCol を返す
+func (w *World) FieldSize() (rows int, cols int) {
+	rows, cols = w.Rows, w.Cols
+	if rows == 0 {
+		rows = MapSizeRow
+	}
+	if cols == 0 {
+		cols = MapSizeCol
+	}
+	return rows, cols
+}
+
+// FieldPath is Field Collectionのpath `world-{world}{revision}-land-{land}`
+func (w *World) FieldPath() string {
+	return fmt.Sprintf("world-%s%s-land-%s", w.ID, w.FieldRevision, w.LandID)
+}
+
+// PlayerPositionPath is Player Position Collectionのpath `world-{world}-player-position`
+func (w *World) PlayerPositionPath() string {
+	return fmt.Sprintf("world-%s-player-position", w.ID)
+}
+
+// UsersPath is User Collectionのpath `world-{world}-users`
+func (w *World) UsersPath() string {
+	return fmt.Sprintf("world-%s-users", w.ID)
+}
+
+// UserDocPath is User Documentのpath `world-{world}-users/{id}`
+func (w *World) UserDocPath(id string) string {
+	return fmt.Sprintf("%s/%s", w.UsersPath(), id)
+}
+
+// LandsPath is Landのメタデータのcollectionのpath `world-{world}-lands`
+func (w *World) LandsPath() string {
+	return fmt.Sprintf("world-%s-lands", w.ID)
+}
+
+// LandDocPath is Landのメタデータのdocumentのpath `world-{world}-lands/{land}`
+func (w *World) LandDocPath() string {
+	return fmt.Sprintf("%s/%s", w.LandsPath(), w.LandID)
+}
+
+// MonsterPositionPath is Monster Position Collectionのpath `world-{world}-land-{land}-monster-position`
+func (w *World) MonsterPositionPath() string {
+	return fmt.Sprintf("%s-position", w.MonsterDefinitionPath())
+}
+
+// MonsterDefinitionPath is Land起動時に配置するMonsterの定義のCollectionのpath `world-{world}-land-{land}-monster`
+func (w *World) MonsterDefinitionPath() string {
+	return fmt.Sprintf("world-%s-land-%s-monster", w.ID, w.LandID)
+}
+
+// DamagePath is DamageEventのCollectionのpath `world-{world}-land-{land}-damage`
+func (w *World) DamagePath() string {
+	return fmt.Sprintf("world-%s-land-%s-damage", w.ID, w.LandID)
+}
+
+// MonsterTypePath is Monsterの種類の定義のCollectionのpath `world-{world}-monster-type`
+// 全てのLandで同じ定義を使う
+func (w *World) MonsterTypePath() string {
+	return fmt.Sprintf("world-%s-monster-type", w.ID)
+}
+
+// SpawnPointPath is Monsterの出現地点のCollectionのpath `world-{world}{revision}-land-{land}-spawn-point`
+func (w *World) SpawnPointPath() string {
+	return fmt.Sprintf("%s-spawn-point", w.FieldPath())
+}
+
+// SpawnAreaPath is Monsterの出現数を制限する範囲のCollectionのpath `world-{world}{revision}-land-{land}-spawn-area`
+func (w *World) SpawnAreaPath() string {
+	return fmt.Sprintf("%s-spawn-area", w.FieldPath())
+}
@@ -0,0 +1,161 @@
+package firedb
+
+import "testing"
+
+func TestWorld_Path(t *testing.T) {
+	candidates := []struct {
+		world           *World
+		field           string
+		playerPosition  string
+		userDoc         string
+		monsterPosition string
+		monster         string
+		spawnPoint      string
+		spawnArea       string
+		monsterType     string
+		damage          string
+		landDoc         string
+	}{
+		{
+			world:           DefaultWorld(),
+			field:           "world-default20170908-land-home",
+			playerPosition:  "world-default-player-position",
+			userDoc:         "world-default-users/sinmetal",
+			monsterPosition: "world-default-land-home-monster-position",
+			monster:         "world-default-land-home-monster",
+			spawnPoint:      "world-default20170908-land-home-spawn-point",
+			spawnArea:       "world-default20170908-land-home-spawn-area",
+			monsterType:     "world-default-monster-type",
+			damage:          "world-default-land-home-damage",
+			landDoc:         "world-default-lands/home",
+		},
+		{
+			world:           &World{ID: "test", LandID: "dungeon"},
+			field:           "world-test-land-dungeon",
+			playerPosition:  "world-test-player-position",
+			userDoc:         "world-test-users/sinmetal",
+			monsterPosition: "world-test-land-dungeon-monster-position",
+			monster:         "world-test-land-dungeon-monster",
+			spawnPoint:      "world-test-land-dungeon-spawn-point",
+			spawnArea:       "world-test-land-dungeon-spawn-area",
+			monsterType:     "world-test-monster-type",
+			damage:          "world-test-land-dungeon-damage",
+			landDoc:         "world-test-lands/dungeon",
+		},
+	}
+
+	for i, v := range candidates {
+		if e, g := v.field, v.world.FieldPath(); e != g {
+			t.Fatalf("%d : expected FieldPath %s; got %s", i, e, g)
+		}
+		if e, g := v.playerPosition, v.world.PlayerPositionPath(); e != g {
+			t.Fatalf("%d : expected PlayerPositionPath %s; got %s", i, e, g)
+		}
+		if e, g := v.userDoc, v.world.UserDocPath("sinmetal"); e != g {
+			t.Fatalf("%d : expected UserDocPath %s; got %s", i, e, g)
+		}
+		if e, g := v.monsterPosition, v.world.MonsterPositionPath(); e != g {
+			t.Fatalf("%d : expected MonsterPositionPath %s; got %s", i, e, g)
+		}
+		if e, g := v.monster, v.world.MonsterDefinitionPath(); e != g {
+			t.Fatalf("%d : expected MonsterDefinitionPath %s; got %s", i, e, g)
+		}
+		if e, g := v.spawnPoint, v.world.SpawnPointPath(); e != g {
+			t.Fatalf("%d : expected SpawnPointPath %s; got %s", i, e, g)
+		}
+		if e, g := v.spawnArea, v.world.SpawnAreaPath(); e != g {
+			t.Fatalf("%d : expected SpawnAreaPath %s; got %s", i, e, g)
+		}
+		if e, g := v.monsterType, v.world.MonsterTypePath(); e != g {
+			t.Fatalf("%d : expected MonsterTypePath %s; got %s", i, e, g)
+		}
+		if e, g := v.damage, v.world.DamagePath(); e != g {
+			t.Fatalf("%d : expected DamagePath %s; got %s", i, e, g)
+		}
+		if e, g := v.landDoc, v.world.LandDocPath(); e != g {
+			t.Fatalf("%d : expected LandDocPath %s; got %s", i, e, g)
+		}
+	}
+}
+
+func TestWorld_Validate(t *testing.T) {
+	candidates := []struct {
+		world *World
+		valid bool
+	}{
+		{world: DefaultWorld(), valid: true},
+		{world: &World{ID: "test", LandID: "home"}, valid: true},
+		{world: &World{ID: "", LandID: "home"}, valid: false},
+		{world: &World{ID: "test", LandID: ""}, valid: false},
+		{world: &World{ID: "test/a", LandID: "home"}, valid: false},
+		{world: &World{ID: "test", LandID: "home/a"}, valid: false},
+		{world: &World{ID: "test", LandID: "home", Rows: 30, Cols: 40}, valid: true},
+		{world: &World{ID: "test", LandID: "home", Rows: -1}, valid: false},
+		{world: &World{ID: "test", LandID: "home", Cols: -1}, valid: false},
+	}
+
+	for i, v := range candidates {
+		err := v.world.Validate()
+		if e, g := v.valid, err == nil; e != g {
+			t.Fatalf("%d : expected valid %t; got %t. err = %v", i, e, g, err)
+		}
+	}
+}
+
+func TestWorld_ValidateWorld(t *testing.T) {
+	candidates := []struct {
+		world *World
+		valid bool
+	}{
+		{world: DefaultWorld(), valid: true},
+		{world: &World{ID: "test"}, valid: true},
+		{world: &World{ID: "test", FieldRevision: "20170908"}, valid: true},
+		{world: &World{ID: ""}, valid: false},
+		{world: &World{ID: "test/a"}, valid: false},
+		{world: &World{ID: "test", FieldRevision: "a/b"}, valid: false},
+	}
+
+	for i, v := range candidates {
+		err := v.world.ValidateWorld()
+		if e, g := v.valid, err == nil; e != g {
+			t.Fatalf("%d : expected valid %t; got %t. err = %v", i, e, g, err)
+		}
+	}
+}
+
+func TestWorld_ForLand(t *testing.T) {
+	w := &World{ID: "test", FieldRevision: "20170908"}
+	home := w.ForLand("home")
+	dungeon := w.ForLand("dungeon1")
+	if e, g := "world-test20170908-land-home", home.FieldPath(); e != g {
+		t.Fatalf("expected %s; got %s", e, g)
+	}
+	if e, g := "world-test20170908-land-dungeon1", dungeon.FieldPath(); e != g {
+		t.Fatalf("expected %s; got %s", e, g)
+	}
+	if e, g := "", w.LandID; e != g {
+		t.Fatalf("expected original world is not modified; got %s", g)
+	}
+}
+
+func TestWorld_FieldSize(t *testing.T) {
+	candidates := []struct {
+		world *World
+		rows  int
+		cols  int
+	}{
+		{world: DefaultWorld(), rows: MapSizeRow, cols: MapSizeCol},
+		{world: &World{ID: "test", LandID: "home", Rows: 30, Cols: 40}, rows: 30, cols: 40},
+		{world: &World{ID: "test", LandID: "home", Rows: 30}, rows: 30, cols: MapSizeCol},
+	}
+
+	for i, v := range candidates {
+		rows, cols := v.world.FieldSize()
+		if e, g := v.rows, rows; e != g {
+			t.Fatalf("%d : expected rows %d; got %d", i, e, g)
+		}
+		if e, g := v.cols, cols; e != g {
+			t.Fatalf("%d : expected cols %d; got %d", i, e, g)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+
+	"github.com/metal-tile/land/firedb"
+)
+
+// DummyDamageStore is UnitTestのためのDamageStore Dummy実装
+type DummyDamageStore struct {
+	Events []*firedb.DamageEvent
+	Err    error
+
+	// Committed is Errを返す時に、先頭から書き込めたことにする数
+	Committed int
+}
+
+func (s *DummyDamageStore) AddEvents(ctx context.Context, l []*firedb.DamageEvent) (int, error) {
+	if s.Err != nil {
+		n := s.Committed
+		if n > len(l) {
+			n = len(l)
+		}
+		s.Events = append(s.Events, l[:n]...)
+		return n, s.Err
+	}
+	s.Events = append(s.Events, l...)
+	return len(l), nil
+}
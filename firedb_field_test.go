@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+
+	"github.com/metal-tile/land/firedb"
+)
+
+// DummyFieldStore is UnitTestのためのFieldStore Dummy実装
+type DummyFieldStore struct {
+	Field map[int]map[int]*firedb.FieldValue
+
+	// Rows, Cols is Fieldの大きさ。0の場合は firedb.MapSizeRow, firedb.MapSizeCol として扱う
+	Rows int
+	Cols int
+}
+
+func (s *DummyFieldStore) Size() (rows int, cols int) {
+	rows, cols = s.Rows, s.Cols
+	if rows == 0 {
+		rows = firedb.MapSizeRow
+	}
+	if cols == 0 {
+		cols = firedb.MapSizeCol
+	}
+	return rows, cols
+}
+
+func (s *DummyFieldStore) SetValue(row int, col int, v *firedb.FieldValue) error {
+	rows, cols := s.Size()
+	if err := firedb.CheckBounds(row, col, rows, cols); err != nil {
+		return err
+	}
+	if s.Field == nil {
+		s.Field = make(map[int]map[int]*firedb.FieldValue)
+	}
+	if _, ok := s.Field[row]; !ok {
+		s.Field[row] = make(map[int]*firedb.FieldValue)
+	}
+	s.Field[row][col] = v
+	return nil
+}
+
+func (s *DummyFieldStore) GetValue(row int, col int) (*firedb.FieldValue, error) {
+	rows, cols := s.Size()
+	if err := firedb.CheckBounds(row, col, rows, cols); err != nil {
+		return nil, err
+	}
+	return s.Field[row][col], nil
+}
+
+func (s *DummyFieldStore) Watch(ctx context.Context, path string) error {
+	return nil
+}
+
+func (s *DummyFieldStore) DamageChip(ctx context.Context, path string, row int, col int, amount float64, replacement firedb.ChipReplacement) (*firedb.FieldValue, error) {
+	v, err := s.GetValue(row, col)
+	if err != nil {
+		return nil, err
+	}
+	if v == nil {
+		return nil, firedb.ErrChipNotFound
+	}
+	fv := *v
+	if _, err := fv.ApplyDamage(amount, replacement); err != nil {
+		return nil, err
+	}
+	if err := s.SetValue(row, col, &fv); err != nil {
+		return nil, err
+	}
+	return &fv, nil
+}
+
+func (s *DummyFieldStore) WriteValue(ctx context.Context, path string, v *firedb.FieldValue) error {
+	return s.SetValue(v.Row, v.Col, v)
+}
+
+func (s *DummyFieldStore) FillRect(ctx context.Context, path string, row int, col int, rows int, cols int, v *firedb.FieldValue) error {
+	fieldRows, fieldCols := s.Size()
+	if row < 0 || col < 0 || row+rows > fieldRows || col+cols > fieldCols {
+		return firedb.ErrOutOfBounds
+	}
+	for r := row; r < row+rows; r++ {
+		for c := col; c < col+cols; c++ {
+			if err := s.SetValue(r, c, &firedb.FieldValue{Row: r, Col: c, ChipID: v.ChipID, HitPoint: v.HitPoint}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
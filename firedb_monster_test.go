@@ -10,6 +10,9 @@ import (
 type DummyMonsterStore struct {
 	UpdatePositionCount int
 	MonsterPosition     *firedb.MonsterPosition
+	Definitions         []*firedb.MonsterPosition
+	Positions           []*firedb.MonsterPosition
+	Deleted             []string
 }
 
 func (s *DummyMonsterStore) UpdatePosition(ctx context.Context, p *firedb.MonsterPosition) error {
@@ -18,3 +21,31 @@ func (s *DummyMonsterStore) UpdatePosition(ctx context.Context, p *firedb.Monste
 
 	return nil
 }
+
+func (s *DummyMonsterStore) Delete(ctx context.Context, id string) error {
+	s.Deleted = append(s.Deleted, id)
+	for i, v := range s.Positions {
+		if v.ID == id {
+			s.Positions = append(s.Positions[:i], s.Positions[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (s *DummyMonsterStore) Load(ctx context.Context, id string) (*firedb.MonsterPosition, error) {
+	for _, v := range s.Positions {
+		if v.ID == id {
+			return v, nil
+		}
+	}
+	return nil, firedb.ErrMonsterNotFound
+}
+
+func (s *DummyMonsterStore) List(ctx context.Context) ([]*firedb.MonsterPosition, error) {
+	return s.Positions, nil
+}
+
+func (s *DummyMonsterStore) ListDefinition(ctx context.Context) ([]*firedb.MonsterPosition, error) {
+	return s.Definitions, nil
+}
@@ -0,0 +1,16 @@
+package main
+
+import (
+	"context"
+
+	"github.com/metal-tile/land/firedb"
+)
+
+// DummyMonsterTypeStore is UnitTestのためのMonsterTypeStore Dummy実装
+type DummyMonsterTypeStore struct {
+	Types []*firedb.MonsterType
+}
+
+func (s *DummyMonsterTypeStore) ListTypes(ctx context.Context) ([]*firedb.MonsterType, error) {
+	return s.Types, nil
+}
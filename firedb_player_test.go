@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+
+	"github.com/metal-tile/land/firedb"
+)
+
+// DummyPlayerStore is UnitTestのためのPlayerStore Dummy実装
+// EventsにPublishすると、SubscribeしたSubscriptionにEventを送れる
+type DummyPlayerStore struct {
+	PlayerMap   map[string]*firedb.User
+	PositionMap map[string]*firedb.PlayerPosition
+	Events      *firedb.PlayerEventHub
+}
+
+func (s *DummyPlayerStore) Watch(ctx context.Context, path string) error {
+	return nil
+}
+
+func (s *DummyPlayerStore) GetPosition(id string) *firedb.PlayerPosition {
+	return s.PositionMap[id]
+}
+
+func (s *DummyPlayerStore) GetPlayerMapSnapshot() map[string]*firedb.User {
+	return s.PlayerMap
+}
+
+func (s *DummyPlayerStore) GetPositionMapSnapshot() map[string]*firedb.PlayerPosition {
+	return s.PositionMap
+}
+
+// QueryRect is PositionMapの全てのプレイヤーから、landIDのLandの範囲にいるプレイヤーを探す
+func (s *DummyPlayerStore) QueryRect(landID string, row int, col int, rows int, cols int) []*firedb.PlayerPosition {
+	var l []*firedb.PlayerPosition
+	for _, v := range s.PositionMap {
+		if v.LandID() != firedb.LandIDOrDefault(landID) {
+			continue
+		}
+		r, c := ConvertXYToRowCol(v.X, v.Y, 1.0)
+		if r < row || r >= row+rows || c < col || c >= col+cols {
+			continue
+		}
+		l = append(l, v)
+	}
+	return l
+}
+
+func (s *DummyPlayerStore) CheckPresence(ctx context.Context) ([]firedb.PresenceTransition, error) {
+	return nil, nil
+}
+
+func (s *DummyPlayerStore) Subscribe(bufferSize int) *firedb.PlayerSubscription {
+	if s.Events == nil {
+		s.Events = firedb.NewPlayerEventHub()
+	}
+	return s.Events.Subscribe(bufferSize)
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"context"
+
+	"github.com/metal-tile/land/firedb"
+)
+
+// DummySpawnStore is UnitTestのためのSpawnStore Dummy実装
+type DummySpawnStore struct {
+	Points []*firedb.SpawnPoint
+	Areas  []*firedb.SpawnArea
+}
+
+func (s *DummySpawnStore) ListPoints(ctx context.Context) ([]*firedb.SpawnPoint, error) {
+	return s.Points, nil
+}
+
+func (s *DummySpawnStore) ListAreas(ctx context.Context) ([]*firedb.SpawnArea, error) {
+	return s.Areas, nil
+}
@@ -10,4 +10,6 @@ require (
 	github.com/sinmetal/stime v0.0.0-20180521010126-afbbb0eaca13
 	github.com/tenntenn/sync v0.0.0-20180624231837-38c46c280d9d
 	go.opencensus.io v0.18.0
+	google.golang.org/api v0.1.0
+	gopkg.in/yaml.v2 v2.4.0
 )
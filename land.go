@@ -0,0 +1,227 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/metal-tile/land/dqn"
+	"github.com/metal-tile/land/firedb"
+)
+
+// ErrLandAlreadyExists is 既にLandManagerに存在するLandを追加しようとした時に利用する
+var ErrLandAlreadyExists = errors.New("land: already exists")
+
+// MonsterSeedLandPlaceholder is Monster Seed Fileのpathの中で、LandのIDに置き換える文字列
+const MonsterSeedLandPlaceholder = "{land}"
+
+// Land is 1つのLand(Map)を動かすための状態
+// FieldStore, MonsterRegistry, Monster ControlのTickはLandごとに独立している
+type Land struct {
+	World        *firedb.World
+	FieldStore   firedb.FieldStore
+	MonsterStore firedb.MonsterStore
+	Monsters     *MonsterRegistry
+	Client       *MonsterClient
+	Spawner      *MonsterSpawner
+	Combat       *MonsterCombat
+
+	// Writer is MonsterのPositionの書き込みをBufferしている場合に設定される
+	// RunでFlushし続ける必要がある
+	Writer *firedb.BufferedMonsterStore
+}
+
+// ID is LandのIDを返す
+func (l *Land) ID() string {
+	return l.World.LandID
+}
+
+// LandManager is 1つのプロセスで複数のLandを動かすための管理役
+// Firestore Client, DQN Client, PlayerStore, MonsterTypeは全てのLandで共有する
+// PlayerStoreは全てのLandのプレイヤーを持っているので、各LandのMonsterはPlayerPositionのLandで自分のLandのプレイヤーだけを探す
+type LandManager struct {
+	DQN         dqn.Client
+	PlayerStore firedb.PlayerStore
+	Passability firedb.ChipPassability
+
+	// ChipReplacement is 壊れたChipを置き換えるChipIDのTable
+	ChipReplacement firedb.ChipReplacement
+
+	// Types is 全てのLandで共有するMonsterTypeの一覧
+	Types *MonsterTypeCatalog
+
+	// MonsterWakeRadius, MonsterWakeRecency is 各LandのMonsterClientに設定する
+	MonsterWakeRadius  int
+	MonsterWakeRecency time.Duration
+
+	// MonsterWriteInterval is MonsterのPositionをまとめて書き込む間隔
+	// 0の場合はBufferせずに、毎回書き込む
+	MonsterWriteInterval time.Duration
+
+	// MonsterAttackCooldown is 各LandのMonsterCombatに設定する
+	MonsterAttackCooldown time.Duration
+
+	// MonsterSpawnInterval is 各LandのMonsterSpawnerがSpawnPointを確認する間隔
+	MonsterSpawnInterval time.Duration
+
+	// NewFieldStore is Landを追加する時に、World.FieldSizeの大きさのFieldStoreを生成する
+	// 指定しない場合は firedb.NewSizedFieldStore を利用する
+	NewFieldStore func(rows int, cols int) (firedb.FieldStore, error)
+
+	mu    *sync.RWMutex
+	lands map[string]*Land
+}
+
+// NewLandManager is 空のLandManagerを生成する
+func NewLandManager(dqnClient dqn.Client, playerStore firedb.PlayerStore, passability firedb.ChipPassability) *LandManager {
+	return &LandManager{
+		DQN:           dqnClient,
+		PlayerStore:   playerStore,
+		Passability:   passability,
+		NewFieldStore: firedb.NewSizedFieldStore,
+		mu:            &sync.RWMutex{},
+		lands:         make(map[string]*Land),
+	}
+}
+
+// AddLand is Worldで指定したLandを追加する
+func (m *LandManager) AddLand(w *firedb.World) (*Land, error) {
+	if err := w.Validate(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.lands[w.LandID]; ok {
+		return nil, ErrLandAlreadyExists
+	}
+
+	fs, err := m.NewFieldStore(w.FieldSize())
+	if err != nil {
+		return nil, err
+	}
+	ms := firedb.NewMonsterStoreWithWorld(w)
+	var writer *firedb.BufferedMonsterStore
+	if m.MonsterWriteInterval > 0 {
+		writer = firedb.NewBufferedMonsterStore(ms, m.MonsterWriteInterval)
+		ms = writer
+	}
+	registry := NewMonsterRegistry()
+	spawner := NewMonsterSpawner(firedb.NewSpawnStoreWithWorld(w), ms, registry)
+	spawner.FieldStore = fs
+	spawner.Interval = m.MonsterSpawnInterval
+	spawner.Types = m.Types
+	l := &Land{
+		World:        w,
+		FieldStore:   fs,
+		MonsterStore: ms,
+		Monsters:     registry,
+		Writer:       writer,
+		Spawner:      spawner,
+		Combat: &MonsterCombat{
+			LandID:       w.LandID,
+			PlayerStore:  m.PlayerStore,
+			DamageStore:  firedb.NewDamageStoreWithWorld(w),
+			MonsterStore: ms,
+			Monsters:     registry,
+			Types:        m.Types,
+			Spawner:      spawner,
+			Cooldown:     m.MonsterAttackCooldown,
+			Recency:      m.MonsterWakeRecency,
+		},
+		Client: &MonsterClient{
+			LandID:       w.LandID,
+			DQN:          m.DQN,
+			PlayerStore:  m.PlayerStore,
+			FieldStore:   fs,
+			MonsterStore: ms,
+			Passability:  m.Passability,
+			Monsters:     registry,
+			Types:        m.Types,
+			WakeRadius:   m.MonsterWakeRadius,
+			WakeRecency:  m.MonsterWakeRecency,
+		},
+	}
+	m.lands[w.LandID] = l
+	return l, nil
+}
+
+// Get is 指定したIDのLandを返す
+func (m *LandManager) Get(id string) (*Land, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	l, ok := m.lands[id]
+	return l, ok
+}
+
+// Lands is 全てのLandをID順に並べて返す
+func (m *LandManager) Lands() []*Land {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	l := make([]*Land, 0, len(m.lands))
+	for _, v := range m.lands {
+		l = append(l, v)
+	}
+	sort.Slice(l, func(i, j int) bool {
+		return l[i].ID() < l[j].ID()
+	})
+	return l
+}
+
+// Resolve is Debug HTTP Handler用に、IDからLandを返す
+// IDが空でLandが1つしか無い場合は、そのLandを返す
+func (m *LandManager) Resolve(id string) (*Land, error) {
+	if id != "" {
+		l, ok := m.Get(id)
+		if !ok {
+			return nil, fmt.Errorf("land %s is not found", id)
+		}
+		return l, nil
+	}
+	lands := m.Lands()
+	if len(lands) != 1 {
+		return nil, fmt.Errorf("land is required. there are %d lands", len(lands))
+	}
+	return lands[0], nil
+}
+
+// MonsterSeedFiles is Landごとに読み込むMonster Seed Fileのpathを、LandのIDをKeyにして返す
+// patternの {land} はLandのIDに置き換える。patternが空の場合は、全てのLandでFirestoreから定義を読み込むので空のpathを返す
+// 同じFileを複数のLandで読み込むと、全てのLandに同じIDのMonsterがいることになるので、複数のLandの場合は {land} を必須にする
+func MonsterSeedFiles(pattern string, landIDs []string) (map[string]string, error) {
+	files := make(map[string]string, len(landIDs))
+	if pattern != "" && len(landIDs) > 1 && !strings.Contains(pattern, MonsterSeedLandPlaceholder) {
+		return nil, fmt.Errorf("monster seed must contain %s to run several lands. seed = %s", MonsterSeedLandPlaceholder, pattern)
+	}
+	for _, id := range landIDs {
+		files[id] = strings.Replace(pattern, MonsterSeedLandPlaceholder, id, -1)
+	}
+	return files, nil
+}
+
+// ParseLandIDs is カンマ区切りのLand IDを分解する
+func ParseLandIDs(s string) ([]string, error) {
+	var ids []string
+	exists := make(map[string]bool)
+	for _, v := range strings.Split(s, ",") {
+		id := strings.TrimSpace(v)
+		if id == "" {
+			continue
+		}
+		if exists[id] {
+			return nil, fmt.Errorf("land id is duplicated. id = %s", id)
+		}
+		exists[id] = true
+		ids = append(ids, id)
+	}
+	if len(ids) < 1 {
+		return nil, fmt.Errorf("land id is required")
+	}
+	return ids, nil
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/metal-tile/land/firedb"
+)
+
+func TestLandManager_AddLand(t *testing.T) {
+	m := NewLandManager(&DQNDummyClient{}, &DummyPlayerStore{}, nil)
+	m.NewFieldStore = func(rows int, cols int) (firedb.FieldStore, error) {
+		return &DummyFieldStore{Rows: rows, Cols: cols}, nil
+	}
+
+	home, err := m.AddLand(&firedb.World{ID: "test", LandID: "home"})
+	if err != nil {
+		t.Fatalf("failed AddLand. err=%+v", err)
+	}
+	dungeon, err := m.AddLand(&firedb.World{ID: "test", LandID: "dungeon"})
+	if err != nil {
+		t.Fatalf("failed AddLand. err=%+v", err)
+	}
+	if _, err := m.AddLand(&firedb.World{ID: "test", LandID: "home"}); err != ErrLandAlreadyExists {
+		t.Fatalf("expected ErrLandAlreadyExists; got %+v", err)
+	}
+	if _, err := m.AddLand(&firedb.World{ID: "test", LandID: ""}); err == nil {
+		t.Fatalf("expected error for invalid world")
+	}
+
+	// FieldStoreとMonsterRegistryはLandごとに独立している
+	if home.FieldStore == dungeon.FieldStore {
+		t.Fatalf("expected FieldStore is not shared")
+	}
+	if home.Monsters == dungeon.Monsters {
+		t.Fatalf("expected MonsterRegistry is not shared")
+	}
+	if err := home.Monsters.Add(&firedb.MonsterPosition{ID: "mob1"}); err != nil {
+		t.Fatalf("failed MonsterRegistry.Add. err=%+v", err)
+	}
+	if e, g := 0, dungeon.Monsters.Len(); e != g {
+		t.Fatalf("expected dungeon monsters is %d; got %d", e, g)
+	}
+	if home.Client.FieldStore != home.FieldStore || home.Client.Monsters != home.Monsters {
+		t.Fatalf("expected MonsterClient uses land's stores")
+	}
+
+	// DQN ClientとPlayerStoreは共有する
+	if home.Client.DQN != dungeon.Client.DQN {
+		t.Fatalf("expected DQN Client is shared")
+	}
+	if home.Client.PlayerStore != dungeon.Client.PlayerStore {
+		t.Fatalf("expected PlayerStore is shared")
+	}
+	// PlayerStoreを共有していても、各LandのMonsterは自分のLandのプレイヤーだけを探す
+	if home.Client.LandID != "home" || home.Combat.LandID != "home" {
+		t.Fatalf("expected home monsters look for players in home; got %s, %s", home.Client.LandID, home.Combat.LandID)
+	}
+	if dungeon.Client.LandID != "dungeon" || dungeon.Combat.LandID != "dungeon" {
+		t.Fatalf("expected dungeon monsters look for players in dungeon; got %s, %s", dungeon.Client.LandID, dungeon.Combat.LandID)
+	}
+
+	lands := m.Lands()
+	if e, g := 2, len(lands); e != g {
+		t.Fatalf("expected len(lands) is %d; got %d", e, g)
+	}
+	if e, g := "dungeon", lands[0].ID(); e != g {
+		t.Fatalf("expected first land is %s; got %s", e, g)
+	}
+}
+
+func TestLandManager_AddLandWithSize(t *testing.T) {
+	m := NewLandManager(&DQNDummyClient{}, &DummyPlayerStore{}, nil)
+
+	small, err := m.AddLand(&firedb.World{ID: "test", LandID: "small", Rows: 30, Cols: 40})
+	if err != nil {
+		t.Fatalf("failed AddLand. err=%+v", err)
+	}
+	home, err := m.AddLand(&firedb.World{ID: "test", LandID: "home"})
+	if err != nil {
+		t.Fatalf("failed AddLand. err=%+v", err)
+	}
+
+	candidates := []struct {
+		land *Land
+		rows int
+		cols int
+	}{
+		{land: small, rows: 30, cols: 40},
+		{land: home, rows: firedb.MapSizeRow, cols: firedb.MapSizeCol},
+	}
+	for i, v := range candidates {
+		rows, cols := v.land.FieldStore.Size()
+		if e, g := v.rows, rows; e != g {
+			t.Fatalf("%d : expected rows is %d; got %d", i, e, g)
+		}
+		if e, g := v.cols, cols; e != g {
+			t.Fatalf("%d : expected cols is %d; got %d", i, e, g)
+		}
+		if v.land.Spawner.FieldStore != v.land.FieldStore {
+			t.Fatalf("%d : expected MonsterSpawner uses land's FieldStore", i)
+		}
+	}
+}
+
+func TestLandManager_Resolve(t *testing.T) {
+	m := NewLandManager(&DQNDummyClient{}, &DummyPlayerStore{}, nil)
+	if _, err := m.Resolve(""); err == nil {
+		t.Fatalf("expected error for empty LandManager")
+	}
+
+	if _, err := m.AddLand(&firedb.World{ID: "test", LandID: "home"}); err != nil {
+		t.Fatalf("failed AddLand. err=%+v", err)
+	}
+	l, err := m.Resolve("")
+	if err != nil {
+		t.Fatalf("failed Resolve. err=%+v", err)
+	}
+	if e, g := "home", l.ID(); e != g {
+		t.Fatalf("expected %s; got %s", e, g)
+	}
+
+	if _, err := m.AddLand(&firedb.World{ID: "test", LandID: "dungeon"}); err != nil {
+		t.Fatalf("failed AddLand. err=%+v", err)
+	}
+	if _, err := m.Resolve(""); err == nil {
+		t.Fatalf("expected error when land is not specified")
+	}
+	l, err = m.Resolve("dungeon")
+	if err != nil {
+		t.Fatalf("failed Resolve. err=%+v", err)
+	}
+	if e, g := "dungeon", l.ID(); e != g {
+		t.Fatalf("expected %s; got %s", e, g)
+	}
+	if _, err := m.Resolve("unknown"); err == nil {
+		t.Fatalf("expected error for unknown land")
+	}
+}
+
+func TestLand_LoadDefinition(t *testing.T) {
+	ctx := context.Background()
+
+	ids := []string{"home", "dungeon"}
+	seeds, err := MonsterSeedFiles("testdata/monster-seed-{land}.json", ids)
+	if err != nil {
+		t.Fatalf("failed MonsterSeedFiles. err=%+v", err)
+	}
+	m := NewLandManager(&DQNDummyClient{}, &DummyPlayerStore{}, nil)
+	for _, id := range ids {
+		l, err := m.AddLand(&firedb.World{ID: "test", LandID: id})
+		if err != nil {
+			t.Fatalf("failed AddLand. err=%+v", err)
+		}
+		if err := l.Monsters.LoadDefinition(ctx, l.MonsterStore, seeds[id]); err != nil {
+			t.Fatalf("failed LoadDefinition. err=%+v", err)
+		}
+	}
+
+	// LandごとにSeedが違うので、Monsterは自分のLandにしかいない
+	candidates := []struct {
+		land    string
+		monster string
+		exists  bool
+	}{
+		{land: "home", monster: "home-slime", exists: true},
+		{land: "home", monster: "dungeon-bat", exists: false},
+		{land: "dungeon", monster: "dungeon-bat", exists: true},
+		{land: "dungeon", monster: "home-slime", exists: false},
+	}
+	for i, v := range candidates {
+		l, ok := m.Get(v.land)
+		if !ok {
+			t.Fatalf("%d : %s is not found", i, v.land)
+		}
+		if _, ok := l.Monsters.Get(v.monster); ok != v.exists {
+			t.Fatalf("%d : expected %s exists in %s is %t; got %t", i, v.monster, v.land, v.exists, ok)
+		}
+	}
+}
+
+func TestMonsterSeedFiles(t *testing.T) {
+	candidates := []struct {
+		pattern string
+		ids     []string
+		files   map[string]string
+		valid   bool
+	}{
+		{pattern: "", ids: []string{"home", "dungeon"}, files: map[string]string{"home": "", "dungeon": ""}, valid: true},
+		{pattern: "seed.json", ids: []string{"home"}, files: map[string]string{"home": "seed.json"}, valid: true},
+		{pattern: "seed-{land}.json", ids: []string{"home", "dungeon"}, files: map[string]string{"home": "seed-home.json", "dungeon": "seed-dungeon.json"}, valid: true},
+		{pattern: "{land}/{land}.json", ids: []string{"home"}, files: map[string]string{"home": "home/home.json"}, valid: true},
+		// 全てのLandに同じIDのMonsterがいることになる
+		{pattern: "seed.json", ids: []string{"home", "dungeon"}, valid: false},
+	}
+
+	for i, v := range candidates {
+		files, err := MonsterSeedFiles(v.pattern, v.ids)
+		if e, g := v.valid, err == nil; e != g {
+			t.Fatalf("%d : expected valid %t; got %t. err = %v", i, e, g, err)
+		}
+		if !v.valid {
+			continue
+		}
+		if e, g := len(v.files), len(files); e != g {
+			t.Fatalf("%d : expected %v; got %v", i, v.files, files)
+		}
+		for id, e := range v.files {
+			if g := files[id]; e != g {
+				t.Fatalf("%d : expected %s seed is %s; got %s", i, id, e, g)
+			}
+		}
+	}
+}
+
+func TestParseLandIDs(t *testing.T) {
+	candidates := []struct {
+		s   string
+		ids []string
+		err bool
+	}{
+		{s: "home", ids: []string{"home"}},
+		{s: "home, dungeon1,", ids: []string{"home", "dungeon1"}},
+		{s: "", err: true},
+		{s: "home,home", err: true},
+	}
+
+	for i, v := range candidates {
+		ids, err := ParseLandIDs(v.s)
+		if e, g := v.err, err != nil; e != g {
+			t.Fatalf("%d : expected err %t; got %v", i, e, err)
+		}
+		if e, g := len(v.ids), len(ids); e != g {
+			t.Fatalf("%d : expected len(ids) is %d; got %d", i, e, g)
+		}
+		for j := range v.ids {
+			if e, g := v.ids[j], ids[j]; e != g {
+				t.Fatalf("%d : expected ids[%d] is %s; got %s", i, j, e, g)
+			}
+		}
+	}
+}
@@ -6,31 +6,72 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"cloud.google.com/go/profiler"
-	"contrib.go.opencensus.io/exporter/stackdriver"
 	"github.com/metal-tile/land/dqn"
 	"github.com/metal-tile/land/firedb"
-	"github.com/sinmetal/gcpmetadata"
+	"github.com/sinmetal/slog"
 	"go.opencensus.io/trace"
 )
 
 func main() {
-	projectID, err := gcpmetadata.GetProjectID()
+	onlyFuncActivate := flag.String("onlyFuncActivate", "", "Activate only specified function")
+	chipPassability := flag.String("chipPassability", "", "ChipID to passability table. e.g. 1:false,2:true")
+	chipReplacement := flag.String("chipReplacement", "", "ChipID to replacement ChipID table used when a chip is destroyed. e.g. 10:1,11:1")
+	monsterSeed := flag.String("monsterSeed", "", "Monster seed file path. {land} is replaced with the land ID and is required to run several lands. If empty, load monster definitions from Firestore")
+	monsterTypes := flag.String("monsterTypes", "", "Monster type definition YAML file path. If empty, load monster types from Firestore")
+	monsterWakeRadius := flag.Int("monsterWakeRadius", 0, "Monsters sleep when no player is within this many tiles. 0 means DQN sense range")
+	monsterWakeRecency := flag.Duration("monsterWakeRecency", 10*time.Second, "Only players moved within this duration wake monsters")
+	monsterWriteInterval := flag.Duration("monsterWriteInterval", firedb.DefaultMonsterWriteInterval, "Interval to write buffered monster positions to Firestore. 0 writes every update")
+	monsterSpawnInterval := flag.Duration("monsterSpawnInterval", DefaultSpawnInterval, "Interval to check spawn points and spawn monsters. 0 disables spawner")
+	monsterAttackCooldown := flag.Duration("monsterAttackCooldown", DefaultAttackCooldown, "Duration until the same monster damages the same player again")
+	monsterRestore := flag.Bool("monsterRestore", true, "Restore monster state from the monster position collection at startup")
+	dqnEndpoint := flag.String("dqnEndpoint", envString("DQN_ENDPOINT", dqn.DefaultEndpoint), "DQN API URL. env DQN_ENDPOINT")
+	dqnTimeout := flag.Duration("dqnTimeout", envDuration("DQN_TIMEOUT", dqn.DefaultTimeout), "DQN API request timeout. env DQN_TIMEOUT")
+	dqnHeaders := flag.String("dqnHeaders", envString("DQN_HEADERS", ""), "DQN API request headers. e.g. Key=Value,Key=Value. env DQN_HEADERS")
+	dqnSelector := flag.String("dqnSelector", envString("DQN_SELECTOR", "argmax"), "Action selection from DQN Q scores. argmax, epsilon:{epsilon} or softmax:{temperature}. Monster types can override it. env DQN_SELECTOR")
+	dqnModel := flag.String("dqnModel", envString("DQN_MODEL", ""), "Q-Network model file path. If specified, evaluate DQN in process instead of DQN API. env DQN_MODEL")
+	dqnFallback := flag.String("dqnFallback", envString("DQN_FALLBACK", "chase"), "Fallback policy when DQN API is unavailable. chase, wander, idle or none. env DQN_FALLBACK")
+	dqnBreakerThreshold := flag.Int("dqnBreakerThreshold", 3, "Number of consecutive DQN API errors to switch to fallback policy")
+	dqnBreakerRetry := flag.Duration("dqnBreakerRetry", 5*time.Second, "Interval to retry DQN API while using fallback policy")
+	local := flag.Bool("local", envBool("LAND_LOCAL"), "Run without GCP metadata server. Project ID is taken from GOOGLE_CLOUD_PROJECT. env LAND_LOCAL")
+	worldID := flag.String("world", envString("LAND_WORLD", firedb.DefaultWorldID), "World ID. env LAND_WORLD")
+	landIDs := flag.String("land", envString("LAND_ID", firedb.DefaultLandID), "Land IDs. Comma separated to run several lands in a process. e.g. home,dungeon1. env LAND_ID")
+	fieldRevision := flag.String("fieldRevision", envString("LAND_FIELD_REVISION", firedb.DefaultFieldRevision), "Revision suffix of field collection. e.g. world-default20170908-land-home. env LAND_FIELD_REVISION")
+	presenceIdle := flag.Duration("presenceIdle", firedb.DefaultPresenceThresholds().Idle, "Duration without movement until a player becomes idle")
+	presencePassive := flag.Duration("presencePassive", firedb.DefaultPresenceThresholds().Passive, "Duration without movement until a player becomes passive")
+	presenceOffline := flag.Duration("presenceOffline", firedb.DefaultPresenceThresholds().Offline, "Duration without movement until a player becomes offline")
+	traceExporterKind := flag.String("traceExporter", envString("LAND_TRACE_EXPORTER", ""), "Trace exporter. stackdriver, stdout or none. Default is stackdriver, stdout in local mode. env LAND_TRACE_EXPORTER")
+	flag.Parse()
+
+	projectID, err := resolveProjectID(*local)
 	if err != nil {
 		panic(err)
 	}
 
-	if err := profiler.Start(profiler.Config{Service: "land", ServiceVersion: "0.0.1"}); err != nil {
-		fmt.Printf("failed stackdriver.profiler.Start %+v", err)
+	if *local {
+		fmt.Println("Run in local mode. stackdriver.profiler is disabled.")
+	} else {
+		if err := profiler.Start(profiler.Config{Service: "land", ServiceVersion: "0.0.1"}); err != nil {
+			fmt.Printf("failed stackdriver.profiler.Start %+v", err)
+		}
 	}
-	exporter, err := stackdriver.NewExporter(stackdriver.Options{
-		ProjectID: projectID,
-	})
+	if *traceExporterKind == "" {
+		*traceExporterKind = TraceExporterStackdriver
+		if *local {
+			*traceExporterKind = TraceExporterStdout
+		}
+	}
+	exporter, err := newTraceExporter(*traceExporterKind, projectID)
 	if err != nil {
 		panic(err)
 	}
-	trace.RegisterExporter(exporter)
+	if exporter != nil {
+		trace.RegisterExporter(exporter)
+	}
 
 	hs, err := os.Hostname()
 	if err != nil {
@@ -40,62 +81,207 @@ func main() {
 	fmt.Println("")
 	fmt.Println(os.Environ())
 
-	onlyFuncActivate := flag.String("onlyFuncActivate", "", "Activate only specified function")
-	flag.Parse()
 	fmt.Printf("onlyFuncActivate is %s\n", *onlyFuncActivate)
+	lids, err := ParseLandIDs(*landIDs)
+	if err != nil {
+		panic(err)
+	}
+	// worldはPlayerStore, MonsterTypeStoreのような全てのLandで共有するものに使い、Landのものは各LandのWorldから組み立てる
+	world := &firedb.World{ID: *worldID, FieldRevision: *fieldRevision}
+	if err := world.ValidateWorld(); err != nil {
+		panic(err)
+	}
+	seeds, err := MonsterSeedFiles(*monsterSeed, lids)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("world is %s, lands are %v\n", world.ID, lids)
+	if v := os.Getenv(firedb.EmulatorHostEnv); v != "" {
+		fmt.Printf("Use Firestore Emulator %s\n", v)
+	}
+	fmt.Printf("dqnEndpoint is %s, dqnTimeout is %s\n", *dqnEndpoint, *dqnTimeout)
+
+	passability, err := ParseChipPassability(*chipPassability)
+	if err != nil {
+		panic(err)
+	}
+	replacement, err := ParseChipReplacement(*chipReplacement)
+	if err != nil {
+		panic(err)
+	}
+	dqnOptions, err := BuildDQNOptions(*dqnEndpoint, *dqnTimeout, *dqnHeaders, *dqnSelector)
+	if err != nil {
+		panic(err)
+	}
+	dqnClient := dqn.NewClient(dqnOptions...)
+	if *dqnModel != "" {
+		m, err := dqn.LoadModelFile(*dqnModel)
+		if err != nil {
+			panic(err)
+		}
+		lc, err := dqn.NewLocalClient(m, dqnOptions...)
+		if err != nil {
+			panic(err)
+		}
+		dqnClient = lc
+	}
+	if *dqnFallback != "none" {
+		fallback, err := dqn.NewPolicyClient(*dqnFallback)
+		if err != nil {
+			panic(err)
+		}
+		dqnClient = dqn.NewCircuitBreakerClient(dqnClient, fallback, *dqnBreakerThreshold, *dqnBreakerRetry)
+	}
 
-	ctx := context.Background()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 	if err := firedb.SetUp(ctx, projectID); err != nil {
 		panic(err)
 	}
 
-	ch := make(chan error)
+	sv := NewSupervisor()
 
-	fieldStore := firedb.NewFieldStore()
-	if *onlyFuncActivate == "" || *onlyFuncActivate == "field" {
-		fmt.Println("Start WatchField")
-		go func() {
-			ch <- fieldStore.Watch(ctx, "world-default20170908-land-home")
-		}()
+	playerStore, err := firedb.NewPlayerStoreWithWorld(world, firedb.PresenceThresholds{
+		Idle:    *presenceIdle,
+		Passive: *presencePassive,
+		Offline: *presenceOffline,
+	})
+	if err != nil {
+		panic(err)
 	}
-
-	playerStore := firedb.NewPlayerStore()
 	if *onlyFuncActivate == "" || *onlyFuncActivate == "playerPosition" {
 		fmt.Println("Start WatchPlayerPositions")
-		go func() {
-			ch <- playerStore.Watch(ctx, "world-default-player-position")
-		}()
+		sv.Add("WatchPlayerPositions", func(ctx context.Context) error {
+			return playerStore.Watch(ctx, world.PlayerPositionPath())
+		})
 	}
 
+	// Firestore Client, DQN Client, PlayerStore, MonsterTypeは全てのLandで共有する
+	lands := NewLandManager(dqnClient, playerStore, passability)
 	if *onlyFuncActivate == "" || *onlyFuncActivate == "monster" {
-		fmt.Println("Start Monster Control")
-		go func() {
-			c := &MonsterClient{
-				DQN:         dqn.NewClient(),
-				PlayerStore: playerStore,
+		types, err := LoadMonsterTypeCatalog(ctx, firedb.NewMonsterTypeStoreWithWorld(world), *monsterTypes)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Printf("Load %d monster types\n", types.Len())
+		lands.Types = types
+	}
+	lands.ChipReplacement = replacement
+	lands.MonsterWakeRadius = *monsterWakeRadius
+	lands.MonsterWakeRecency = *monsterWakeRecency
+	lands.MonsterWriteInterval = *monsterWriteInterval
+	lands.MonsterSpawnInterval = *monsterSpawnInterval
+	lands.MonsterAttackCooldown = *monsterAttackCooldown
+	for _, id := range lids {
+		w := world.ForLand(id)
+		// Landの大きさはLandのメタデータから決める。Documentが無い場合は既定の大きさを使う
+		meta, err := firedb.NewLandStoreWithWorld(w).GetMetadata(ctx)
+		if err != nil {
+			panic(err)
+		}
+		w.Rows = meta.Rows
+		w.Cols = meta.Cols
+		rows, cols := w.FieldSize()
+		fmt.Printf("land %s is %d x %d\n", id, rows, cols)
+		if _, err := lands.AddLand(w); err != nil {
+			panic(err)
+		}
+	}
+	for _, land := range lands.Lands() {
+		land := land
+		if *onlyFuncActivate == "" || *onlyFuncActivate == "field" {
+			fmt.Printf("Start WatchField %s\n", land.ID())
+			sv.Add(fmt.Sprintf("WatchField:%s", land.ID()), func(ctx context.Context) error {
+				return land.FieldStore.Watch(ctx, land.World.FieldPath())
+			})
+		}
+
+		if *onlyFuncActivate == "" || *onlyFuncActivate == "monster" {
+			if err := land.Monsters.LoadDefinition(ctx, land.MonsterStore, seeds[land.ID()]); err != nil {
+				panic(err)
 			}
-			ch <- RunControlMonster(c)
-		}()
+			if *monsterRestore {
+				n, err := land.Monsters.Restore(ctx, land.MonsterStore)
+				if err != nil {
+					panic(err)
+				}
+				fmt.Printf("Restore %d monsters in %s\n", n, land.ID())
+			}
+			land.Monsters.FillHitPoint(lands.Types)
+			fmt.Printf("Load %d monsters in %s\n", land.Monsters.Len(), land.ID())
+
+			fmt.Printf("Start Monster Control %s\n", land.ID())
+			sv.Add(fmt.Sprintf("MonsterControl:%s", land.ID()), func(ctx context.Context) error {
+				return RunControlMonster(ctx, land.Client)
+			})
+			if land.Writer != nil {
+				sv.Add(fmt.Sprintf("MonsterWriter:%s", land.ID()), land.Writer.Run)
+			}
+			sv.Add(fmt.Sprintf("MonsterCombat:%s", land.ID()), land.Combat.Run)
+			if *monsterSpawnInterval > 0 {
+				fmt.Printf("Start Monster Spawner %s\n", land.ID())
+				sv.Add(fmt.Sprintf("MonsterSpawner:%s", land.ID()), land.Spawner.Run)
+			}
+		}
 	}
 
-	if *onlyFuncActivate == "" || *onlyFuncActivate == "watchPassivePlayer" {
-		fmt.Println("Start WatchPassivePlayer")
-		go func() {
-			ch <- WatchPassivePlayer()
-		}()
+	// watchPassivePlayer は以前の名前で、互換性のために残している
+	if *onlyFuncActivate == "" || *onlyFuncActivate == "watchPresence" || *onlyFuncActivate == "watchPassivePlayer" {
+		fmt.Println("Start WatchPlayerPresence")
+		sv.Add("WatchPlayerPresence", WatchPlayerPresence)
 	}
 
 	// Debug HTTP Handler
+	http.HandleFunc("/", helthHandler)
+	http.HandleFunc("/field", fieldHandler(lands))
+	http.HandleFunc("/field/damage", fieldDamageHandler(lands))
+	http.HandleFunc("/player", playerHandler)
+	http.HandleFunc("/monster", monsterHandler(lands))
+	http.HandleFunc("/monster/damage", monsterDamageHandler(lands))
+	http.HandleFunc("/monster/writer", monsterWriterHandler(lands))
+	http.HandleFunc("/healthz", helthHandler)
+	server := &http.Server{Addr: ":8080"}
 	go func() {
-		http.HandleFunc("/", helthHandler)
-		http.HandleFunc("/field", fieldHandler)
-		http.HandleFunc("/player", playerHandler)
-		http.HandleFunc("/healthz", helthHandler)
-		if err := http.ListenAndServe(":8080", nil); err != nil {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			panic(err)
 		}
 	}()
 
-	err = <-ch
-	fmt.Printf("%+v", err)
+	done := make(chan struct{})
+	go func() {
+		sv.Run(ctx)
+		close(done)
+	}()
+
+	// KubernetesはPodを止める時にSIGTERMを送ってくるので、処理中のものを終わらせてから終了する
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGTERM, os.Interrupt)
+	fmt.Printf("Receive signal %s. Start shutdown.\n", <-sig)
+
+	shutdown(cancel, server, done, exporter)
+}
+
+// shutdown is HTTP Serverと全てのSubsystemを止めて、Traceを書き出す
+// KubernetesのterminationGracePeriodSeconds(30s)に収まるように待ち時間を決めている
+func shutdown(cancel context.CancelFunc, server *http.Server, done <-chan struct{}, exporter traceExporter) {
+	ctx := slog.WithLog(context.Background())
+	defer slog.Flush(ctx)
+
+	sctx, scancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer scancel()
+	if err := server.Shutdown(sctx); err != nil {
+		slog.Warning(ctx, "FailedHTTPServerShutdown", fmt.Sprintf("%+v", err))
+	}
+
+	cancel()
+	select {
+	case <-done:
+		slog.Info(ctx, "SubsystemsStopped", "all subsystems are stopped.")
+	case <-time.After(15 * time.Second):
+		slog.Warning(ctx, "SubsystemsStopTimeout", "some subsystems are not stopped in time.")
+	}
+
+	if exporter != nil {
+		exporter.Flush()
+	}
 }
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/metal-tile/land/firedb"
+	"github.com/sinmetal/slog"
+	"github.com/sinmetal/stime"
+)
+
+const (
+	// DefaultCombatInterval is MonsterCombatがMonsterとプレイヤーの接触を確認する間隔
+	DefaultCombatInterval = 500 * time.Millisecond
+
+	// DefaultAttackCooldown is 同じMonsterが同じプレイヤーに、次にダメージを与えられるまでの時間
+	DefaultAttackCooldown = 1 * time.Second
+)
+
+// ErrMonsterInvulnerable is HitPointを持たないMonsterにダメージを与えようとした時に利用する
+var ErrMonsterInvulnerable = errors.New("monster: invulnerable")
+
+// ErrInvalidDamage is 0以下のダメージを与えようとした時に利用する
+var ErrInvalidDamage = errors.New("monster: invalid damage")
+
+// MonsterCombat is Monsterとプレイヤーの間のダメージを判定して、DamageEventを書き込む
+// Monsterは隣接するTile(斜めを含む)にいるプレイヤーに、MonsterTypeのAttack分のダメージを与える
+// HitPointが0以下になったMonsterは、Spawnerを通して取り除く
+type MonsterCombat struct {
+	PlayerStore  firedb.PlayerStore
+	DamageStore  firedb.DamageStore
+	MonsterStore firedb.MonsterStore
+	Monsters     *MonsterRegistry
+	Types        *MonsterTypeCatalog
+	Spawner      *MonsterSpawner
+
+	// LandID is MonsterがいるLandのID。このLandにいるプレイヤーだけにダメージを与える
+	// 空の場合は firedb.DefaultLandID にいるものとして扱う
+	LandID string
+
+	// Interval is 接触を確認する間隔。0の場合は DefaultCombatInterval を使う
+	Interval time.Duration
+
+	// Cooldown is 同じMonsterが同じプレイヤーに、次にダメージを与えられるまでの時間
+	// 0の場合は DefaultAttackCooldown を使う
+	Cooldown time.Duration
+
+	// Recency is この時間内に位置が更新されたプレイヤーだけを、ダメージを与える対象にする
+	// 0の場合は playerRecentDuration を使う
+	Recency time.Duration
+
+	mu sync.Mutex
+
+	// lastAttack is `{monster}/{player}` ごとの、最後にダメージを与えた時刻
+	lastAttack map[string]time.Time
+}
+
+// Contacts is Monsterと同じTileか、隣接するTileにいる最近動いたプレイヤーを返す
+func (c *MonsterCombat) Contacts(mob *firedb.MonsterPosition) []*firedb.PlayerPosition {
+	if c.PlayerStore == nil {
+		return nil
+	}
+	recency := c.Recency
+	if recency <= 0 {
+		recency = playerRecentDuration
+	}
+
+	row, col := ConvertXYToRowCol(mob.X, mob.Y, 1.0)
+	now := stime.Now()
+	var l []*firedb.PlayerPosition
+	for _, p := range c.PlayerStore.QueryRect(c.LandID, row-1, col-1, 3, 3) {
+		if stime.InTime(now, p.FirestoreUpdateAt, recency) == false {
+			continue
+		}
+		l = append(l, p)
+	}
+	return l
+}
+
+// Tick is 全てのMonsterについてプレイヤーとの接触を確認して、ダメージを与えたDamageEventを書き込む
+// 書き込んだDamageEventを返す。書き込みに失敗した場合も、それより前に書き込めたDamageEventを返す
+func (c *MonsterCombat) Tick(ctx context.Context) ([]*firedb.DamageEvent, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.lastAttack == nil {
+		c.lastAttack = make(map[string]time.Time)
+	}
+	cooldown := c.Cooldown
+	if cooldown <= 0 {
+		cooldown = DefaultAttackCooldown
+	}
+	now := stime.Now()
+	for k, at := range c.lastAttack {
+		if now.Sub(at) >= cooldown {
+			delete(c.lastAttack, k)
+		}
+	}
+
+	var l []*firedb.DamageEvent
+	for _, mob := range c.Monsters.Snapshot() {
+		t, ok := c.Types.Get(mob.Type)
+		if !ok || t.Attack <= 0 {
+			continue
+		}
+		for _, p := range c.Contacts(mob) {
+			k := fmt.Sprintf("%s/%s", mob.ID, p.ID)
+			if _, ok := c.lastAttack[k]; ok {
+				continue
+			}
+			c.lastAttack[k] = now
+
+			row, col := ConvertXYToRowCol(p.X, p.Y, 1.0)
+			l = append(l, &firedb.DamageEvent{
+				Attacker:   mob.ID,
+				Target:     p.ID,
+				TargetKind: firedb.DamageTargetPlayer,
+				Amount:     t.Attack,
+				Row:        row,
+				Col:        col,
+				CreatedAt:  now,
+			})
+		}
+	}
+	if len(l) < 1 {
+		return nil, nil
+	}
+	if n, err := c.DamageStore.AddEvents(ctx, l); err != nil {
+		// 書き込めなかったダメージだけ、次のTickでもう一度判定する
+		// 書き込めたダメージはCooldownを守らないと、同じダメージが二重に書き込まれる
+		for _, e := range l[n:] {
+			delete(c.lastAttack, fmt.Sprintf("%s/%s", e.Attacker, e.Target))
+		}
+		return l[:n], err
+	}
+	return l, nil
+}
+
+// DamageMonster is Monsterにダメージを与えて、DamageEventを書き込む
+// HitPointが0以下になった場合は、Spawnerを通してMonsterを取り除く
+// Registryに存在しない場合や、同時に与えられた別のダメージで既に倒されている場合は firedb.ErrMonsterNotFound を返す
+func (c *MonsterCombat) DamageMonster(ctx context.Context, id string, amount float64, attacker string) (*firedb.DamageEvent, error) {
+	if amount <= 0 {
+		return nil, ErrInvalidDamage
+	}
+	v, killed, err := c.Spawner.Damage(ctx, id, amount)
+	if err != nil {
+		return nil, err
+	}
+	row, col := ConvertXYToRowCol(v.X, v.Y, 1.0)
+	e := &firedb.DamageEvent{
+		Attacker:   attacker,
+		Target:     id,
+		TargetKind: firedb.DamageTargetMonster,
+		Amount:     amount,
+		HitPoint:   v.HitPoint,
+		Killed:     killed,
+		Row:        row,
+		Col:        col,
+		CreatedAt:  stime.Now(),
+	}
+	if killed {
+		slog.Info(ctx, "MonsterKilled", fmt.Sprintf("%s is killed by %s.", id, attacker))
+	}
+
+	if _, err := c.DamageStore.AddEvents(ctx, []*firedb.DamageEvent{e}); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Run is Intervalごとに Tick する
+func (c *MonsterCombat) Run(ctx context.Context) error {
+	interval := c.Interval
+	if interval <= 0 {
+		interval = DefaultCombatInterval
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+			tctx := slog.WithLog(context.Background())
+			if _, err := c.Tick(tctx); err != nil {
+				slog.Warning(tctx, "FailedMonsterCombat", fmt.Sprintf("%+v", err))
+			}
+			slog.Flush(tctx)
+		}
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/metal-tile/land/firedb"
+	"github.com/sinmetal/slog"
+	"github.com/sinmetal/stime"
+)
+
+func newTestMonsterCombat(t *testing.T, players map[string]*firedb.PlayerPosition) *MonsterCombat {
+	types, err := NewMonsterTypeCatalog([]*firedb.MonsterType{
+		{ID: "slime", HitPoint: 10, Attack: 2},
+		{ID: "statue"},
+	})
+	if err != nil {
+		t.Fatalf("failed NewMonsterTypeCatalog. err=%+v", err)
+	}
+	ms := &DummyMonsterStore{}
+	registry := NewMonsterRegistry()
+	spawner := NewMonsterSpawner(&DummySpawnStore{}, ms, registry)
+	return &MonsterCombat{
+		PlayerStore:  &DummyPlayerStore{PositionMap: players},
+		DamageStore:  &DummyDamageStore{},
+		MonsterStore: ms,
+		Monsters:     registry,
+		Types:        types,
+		Spawner:      spawner,
+	}
+}
+
+func TestMonsterCombat_Tick(t *testing.T) {
+	ctx := slog.WithLog(context.Background())
+	start := time.Date(2019, 2, 1, 10, 0, 0, 0, time.UTC)
+	stime.SetPermafrost(start)
+	defer stime.SetPermafrost(time.Time{})
+
+	// Monsterは row=31, col=29 にいる
+	c := newTestMonsterCombat(t, map[string]*firedb.PlayerPosition{
+		// 斜めに隣接している
+		"adjacent": &firedb.PlayerPosition{ID: "adjacent", X: 30 * 32, Y: 32 * 32, FirestoreUpdateAt: start},
+		// 2Tile離れている
+		"far": &firedb.PlayerPosition{ID: "far", X: 31 * 32, Y: 31 * 32, FirestoreUpdateAt: start},
+		// 最近動いていない
+		"stale": &firedb.PlayerPosition{ID: "stale", X: 29 * 32, Y: 31 * 32, FirestoreUpdateAt: start.Add(-time.Minute)},
+		// 同じTileにいるが、別のLandにいる
+		"otherLand": &firedb.PlayerPosition{ID: "otherLand", X: 950, Y: 1000, FirestoreUpdateAt: start, Land: "dungeon1"},
+	})
+	for _, mob := range []*firedb.MonsterPosition{
+		{ID: "slime", X: 950, Y: 1000, Type: "slime"},
+		// Attackが無いのでダメージを与えない
+		{ID: "statue", X: 950, Y: 1000, Type: "statue"},
+	} {
+		if err := c.Monsters.Add(mob); err != nil {
+			t.Fatalf("failed MonsterRegistry.Add. err=%+v", err)
+		}
+	}
+
+	candidates := []struct {
+		elapsed time.Duration
+		events  int
+	}{
+		{elapsed: 0, events: 1},
+		// Cooldownの間はダメージを与えない
+		{elapsed: 500 * time.Millisecond, events: 0},
+		{elapsed: 1 * time.Second, events: 1},
+	}
+	for i, v := range candidates {
+		stime.SetPermafrost(start.Add(v.elapsed))
+		l, err := c.Tick(ctx)
+		if err != nil {
+			t.Fatalf("%d : failed Tick. err=%+v", i, err)
+		}
+		if e, g := v.events, len(l); e != g {
+			t.Fatalf("%d : expected %d events; got %+v", i, e, l)
+		}
+		for _, e := range l {
+			if e.Attacker != "slime" || e.Target != "adjacent" || e.TargetKind != firedb.DamageTargetPlayer || e.Amount != 2 {
+				t.Fatalf("%d : unexpected event %+v", i, e)
+			}
+		}
+	}
+	if e, g := 2, len(c.DamageStore.(*DummyDamageStore).Events); e != g {
+		t.Fatalf("expected %d events are written; got %d", e, g)
+	}
+}
+
+func TestMonsterCombat_TickWriteFailed(t *testing.T) {
+	ctx := slog.WithLog(context.Background())
+	now := time.Date(2019, 2, 1, 10, 0, 0, 0, time.UTC)
+	stime.SetPermafrost(now)
+	defer stime.SetPermafrost(time.Time{})
+
+	c := newTestMonsterCombat(t, map[string]*firedb.PlayerPosition{
+		"sinmetal": &firedb.PlayerPosition{ID: "sinmetal", X: 950, Y: 1000, FirestoreUpdateAt: now},
+	})
+	if err := c.Monsters.Add(&firedb.MonsterPosition{ID: "slime", X: 950, Y: 1000, Type: "slime"}); err != nil {
+		t.Fatalf("failed MonsterRegistry.Add. err=%+v", err)
+	}
+	ds := &DummyDamageStore{Err: errors.New("dummy")}
+	c.DamageStore = ds
+	if _, err := c.Tick(ctx); err == nil {
+		t.Fatalf("expected error")
+	}
+
+	// 書き込めなかったダメージは、Cooldownを待たずにもう一度判定する
+	ds.Err = nil
+	l, err := c.Tick(ctx)
+	if err != nil {
+		t.Fatalf("failed Tick. err=%+v", err)
+	}
+	if e, g := 1, len(l); e != g {
+		t.Fatalf("expected %d events; got %d", e, g)
+	}
+}
+
+func TestMonsterCombat_TickPartiallyFailed(t *testing.T) {
+	ctx := slog.WithLog(context.Background())
+	now := time.Date(2019, 2, 1, 10, 0, 0, 0, time.UTC)
+	stime.SetPermafrost(now)
+	defer stime.SetPermafrost(time.Time{})
+
+	c := newTestMonsterCombat(t, map[string]*firedb.PlayerPosition{
+		"sinmetal": &firedb.PlayerPosition{ID: "sinmetal", X: 950, Y: 1000, FirestoreUpdateAt: now},
+		"vvakame":  &firedb.PlayerPosition{ID: "vvakame", X: 950, Y: 1000, FirestoreUpdateAt: now},
+	})
+	if err := c.Monsters.Add(&firedb.MonsterPosition{ID: "slime", X: 950, Y: 1000, Type: "slime"}); err != nil {
+		t.Fatalf("failed MonsterRegistry.Add. err=%+v", err)
+	}
+	// 2つのDamageEventのうち、先頭の1つだけ書き込めた
+	ds := &DummyDamageStore{Err: errors.New("dummy"), Committed: 1}
+	c.DamageStore = ds
+	written, err := c.Tick(ctx)
+	if err == nil {
+		t.Fatalf("expected error")
+	}
+	if e, g := 1, len(written); e != g {
+		t.Fatalf("expected %d written events; got %d", e, g)
+	}
+
+	// 書き込めたダメージはCooldownの間は二重に書き込まず、書き込めなかったダメージだけもう一度判定する
+	ds.Err = nil
+	l, err := c.Tick(ctx)
+	if err != nil {
+		t.Fatalf("failed Tick. err=%+v", err)
+	}
+	if e, g := 1, len(l); e != g {
+		t.Fatalf("expected %d events; got %d", e, g)
+	}
+	if l[0].Target == written[0].Target {
+		t.Fatalf("expected %s is not damaged twice", l[0].Target)
+	}
+	if e, g := 2, len(ds.Events); e != g {
+		t.Fatalf("expected %d events are written; got %d", e, g)
+	}
+}
+
+func TestMonsterCombat_DamageMonster(t *testing.T) {
+	ctx := slog.WithLog(context.Background())
+	c := newTestMonsterCombat(t, nil)
+	for _, mob := range []*firedb.MonsterPosition{
+		{ID: "slime", X: 950, Y: 1000, Type: "slime"},
+		{ID: "statue", X: 950, Y: 1000, Type: "statue"},
+	} {
+		if err := c.Monsters.Add(mob); err != nil {
+			t.Fatalf("failed MonsterRegistry.Add. err=%+v", err)
+		}
+	}
+	c.Monsters.FillHitPoint(c.Types)
+
+	candidates := []struct {
+		id       string
+		amount   float64
+		err      error
+		hitPoint float64
+		killed   bool
+	}{
+		{id: "slime", amount: 0, err: ErrInvalidDamage},
+		{id: "statue", amount: 1, err: ErrMonsterInvulnerable},
+		{id: "unknown", amount: 1, err: firedb.ErrMonsterNotFound},
+		{id: "slime", amount: 4, hitPoint: 6},
+		{id: "slime", amount: 4, hitPoint: 2},
+		{id: "slime", amount: 4, hitPoint: 0, killed: true},
+		{id: "slime", amount: 4, err: firedb.ErrMonsterNotFound},
+	}
+	for i, v := range candidates {
+		e, err := c.DamageMonster(ctx, v.id, v.amount, "sinmetal")
+		if v.err != nil {
+			if err != v.err {
+				t.Fatalf("%d : expected err %v; got %v", i, v.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%d : failed DamageMonster. err=%+v", i, err)
+		}
+		if e, g := v.hitPoint, e.HitPoint; e != g {
+			t.Fatalf("%d : expected HitPoint %f; got %f", i, e, g)
+		}
+		if e, g := v.killed, e.Killed; e != g {
+			t.Fatalf("%d : expected Killed %t; got %t", i, e, g)
+		}
+		if e.TargetKind != firedb.DamageTargetMonster || e.Attacker != "sinmetal" {
+			t.Fatalf("%d : unexpected event %+v", i, e)
+		}
+	}
+
+	if _, ok := c.Monsters.Get("slime"); ok {
+		t.Fatalf("expected killed slime is removed")
+	}
+	ms := c.MonsterStore.(*DummyMonsterStore)
+	if e, g := []string{"slime"}, ms.Deleted; len(g) != 1 || e[0] != g[0] {
+		t.Fatalf("expected deleted %v; got %v", e, g)
+	}
+	if e, g := 2, ms.UpdatePositionCount; e != g {
+		t.Fatalf("expected UpdatePositionCount is %d; got %d", e, g)
+	}
+	if e, g := 3, len(c.DamageStore.(*DummyDamageStore).Events); e != g {
+		t.Fatalf("expected %d events are written; got %d", e, g)
+	}
+}
+
+func TestMonsterCombat_DamageMonsterConcurrently(t *testing.T) {
+	ctx := slog.WithLog(context.Background())
+	c := newTestMonsterCombat(t, nil)
+	if err := c.Monsters.Add(&firedb.MonsterPosition{ID: "slime", X: 950, Y: 1000, Type: "slime"}); err != nil {
+		t.Fatalf("failed MonsterRegistry.Add. err=%+v", err)
+	}
+	c.Monsters.FillHitPoint(c.Types)
+
+	// どちらのダメージでも倒れるので、倒すのは1回だけで、もう1回は倒された後のダメージになる
+	const n = 2
+	var wg sync.WaitGroup
+	events := make(chan *firedb.DamageEvent, n)
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			e, err := c.DamageMonster(ctx, "slime", 10, "sinmetal")
+			if err != nil {
+				errs <- err
+				return
+			}
+			events <- e
+		}()
+	}
+	wg.Wait()
+	close(events)
+	close(errs)
+
+	if e, g := 1, len(events); e != g {
+		t.Fatalf("expected %d events; got %d", e, g)
+	}
+	if e := <-events; !e.Killed || e.HitPoint != 0 {
+		t.Fatalf("expected killed event; got %+v", e)
+	}
+	if err := <-errs; err != firedb.ErrMonsterNotFound {
+		t.Fatalf("expected ErrMonsterNotFound; got %+v", err)
+	}
+	// 倒されたMonsterのPositionを書き込んで、削除したDocumentを復活させない
+	ms := c.MonsterStore.(*DummyMonsterStore)
+	if e, g := 0, ms.UpdatePositionCount; e != g {
+		t.Fatalf("expected UpdatePositionCount is %d; got %d", e, g)
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"fmt"
+	"sort"
 	"time"
 
 	"github.com/metal-tile/land/dqn"
@@ -14,87 +15,175 @@ import (
 	"go.opencensus.io/trace"
 )
 
-var monsterPositionMap map[string]*firedb.MonsterPosition
-
-func init() {
-	monsterPositionMap = make(map[string]*firedb.MonsterPosition)
-}
+// playerRecentDuration is この時間内に位置が更新されたプレイヤーを、Monsterが感知する対象にする
+const playerRecentDuration = 10 * time.Second
 
 // MonsterClient is Monsterに関連する処理を行うClient
 type MonsterClient struct {
 	DQN dqn.Client
 	firedb.PlayerStore
+	FieldStore   firedb.FieldStore
+	MonsterStore firedb.MonsterStore
+	Passability  firedb.ChipPassability
+	Monsters     *MonsterRegistry
+
+	// LandID is MonsterがいるLandのID。このLandにいるプレイヤーだけを感知する
+	// 空の場合は firedb.DefaultLandID にいるものとして扱う
+	LandID string
+
+	// Types is Monsterの種類ごとの速さ、Policy、Sense Range
+	// MonsterのTypeがCatalogに無い場合は、MonsterPositionのSpeedとDQNを使う
+	Types *MonsterTypeCatalog
+
+	// WakeRadius is Monsterの周囲何Tile以内にプレイヤーがいたら動かすか
+	// 0の場合はDQNのSense Rangeと同じ範囲にする
+	WakeRadius int
+
+	// WakeRecency is この時間内に位置が更新されたプレイヤーだけを、Monsterを起こす対象にする
+	// 0の場合は playerRecentDuration を使う
+	WakeRecency time.Duration
+
+	// dormant is 休眠中のMonsterのID
+	// Monster ControlのTickからしか触らないので、Lockは取らない
+	dormant map[string]bool
 }
 
 // RunControlMonster is MonsterのControlを開始する
-func RunControlMonster(client *MonsterClient) error {
-	// TODO dummy monsterをdebugのために追加する
-	const monsterID = "dummy"
-	monsterPositionMap[monsterID] = &firedb.MonsterPosition{
-		ID:    monsterID,
-		X:     950,
-		Y:     1000,
-		Angle: 180,
-		Speed: 4,
-	}
-
+// ctxがcancelされると、処理中のTickを終えてから終了する
+func RunControlMonster(ctx context.Context, client *MonsterClient) error {
+	t := time.NewTicker(100 * time.Millisecond)
+	defer t.Stop()
 	for {
-		t := time.NewTicker(100 * time.Millisecond)
-		for {
-			select {
-			case <-t.C:
-				ctx := slog.WithLog(context.Background())
-
-				f := recoverable.Func(func() {
-					if err := handleMonster(ctx, client, monsterID); err != nil {
-						panic(err) // panicを上で拾ってもらうために投げる
-					}
-				})
-
-				// TODO recoverableの力を発揮するために、f() を go f() にする必要がある
-				if err := f(); err != nil {
-					v, ok := recoverable.RecoveredValue(err)
-					if ok {
-						slog.Info(ctx, "FailedHandleMonster:RecoveredValue", fmt.Sprintf("%+v", v))
-					} else {
-						slog.Info(ctx, "FailedHandleMonster", fmt.Sprintf("%+v", err))
-					}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+			// 処理中のTickがcancelで中断されないように、ctxは引き継がない
+			tctx := slog.WithLog(context.Background())
+
+			f := recoverable.Func(func() {
+				if err := handleMonsters(tctx, client); err != nil {
+					panic(err) // panicを上で拾ってもらうために投げる
 				}
+			})
 
-				slog.Flush(ctx)
+			// TODO recoverableの力を発揮するために、f() を go f() にする必要がある
+			if err := f(); err != nil {
+				v, ok := recoverable.RecoveredValue(err)
+				if ok {
+					slog.Info(tctx, "FailedHandleMonster:RecoveredValue", fmt.Sprintf("%+v", v))
+				} else {
+					slog.Info(tctx, "FailedHandleMonster", fmt.Sprintf("%+v", err))
+				}
 			}
+
+			slog.Flush(tctx)
 		}
 	}
 }
 
-func handleMonster(ctx context.Context, client *MonsterClient, monsterID string) error {
-	if firedb.ExistsActivePlayer(client.PlayerStore.GetPlayerMapSnapshot()) == false {
-		return nil
-	}
-
-	ctx, span := trace.StartSpan(ctx, "/monster/handleMonster")
+// handleMonsters is Registryに登録されている全てのMonsterを1Tick分動かす
+// 全MonsterのInstanceをMonsterTypeのPolicyごとのPayloadにまとめて、DQN APIへのRequestは1Tickで1回にする
+// アクティブなプレイヤーがいない時も、動いている途中のMonsterを止めるために休眠の判定は毎Tick行う
+func handleMonsters(ctx context.Context, client *MonsterClient) error {
+	ctx, span := trace.StartSpan(ctx, "/monster/handleMonsters")
 	defer span.End()
 
-	mob, ok := monsterPositionMap[monsterID]
-	if !ok {
-		slog.Info(ctx, "NotFoundMonster", fmt.Sprintf("%s is not found monsterPositionMap.", monsterID))
+	mobs := client.Monsters.Snapshot()
+	client.pruneDormancy(mobs)
+	if len(mobs) < 1 {
 		return nil
 	}
-	ppm := client.PlayerStore.GetPositionMapSnapshot()
-	dp, err := BuildDQNPayload(ctx, mob, ppm)
-	if err != nil {
-		slog.Warning(ctx, "FailedBuildDQNPayload", fmt.Sprintf("failed BuildDQNPayload. %+v,%+v,%+v", mob, ppm, err))
-		return nil
+
+	batches := make(map[string]*dqnBatch)
+	awake := 0
+	for i, mob := range mobs {
+		// 近くにプレイヤーがいないMonsterは、DQNにもFirestoreにもRequestしない
+		if client.updateDormancy(ctx, mob) {
+			continue
+		}
+		instance, err := client.BuildDQNInstance(ctx, mob)
+		if err != nil {
+			slog.Warning(ctx, "FailedBuildDQNInstance", fmt.Sprintf("failed BuildDQNInstance. %+v,%+v", mob, err))
+			continue
+		}
+		// KeyはmobsのIndexにして、Answerを元のMonsterに戻せるようにする
+		instance.Key = i
+		name, dc := client.policyOf(mob)
+		b, ok := batches[name]
+		if !ok {
+			b = &dqnBatch{client: dc, payload: &dqn.Payload{}}
+			batches[name] = b
+		}
+		b.payload.Instances = append(b.payload.Instances, *instance)
+		awake++
 	}
-	err = client.UpdateMonster(ctx, mob, dp)
-	if err != nil {
-		slog.Warning(ctx, "FailedUpdateMonster", fmt.Sprintf("failed UpdateMonster. %+v", err))
-		return nil
+	span.AddAttributes(trace.Int64Attribute("monsters", int64(len(mobs))), trace.Int64Attribute("awake", int64(awake)))
+
+	names := make([]string, 0, len(batches))
+	for name := range batches {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		b := batches[name]
+		answers, err := b.client.BatchPrediction(ctx, b.payload)
+		if err != nil {
+			slog.Info(ctx, "DQNPayload", slog.KV{Key: "DQNPayload", Value: b.payload})
+			slog.Warning(ctx, "FailedDQNBatchPrediction", fmt.Sprintf("failed %s BatchPrediction. %+v", name, err))
+			continue
+		}
+
+		for _, instance := range b.payload.Instances {
+			mob := mobs[instance.Key]
+			ans, ok := answers[instance.Key]
+			if !ok {
+				slog.Warning(ctx, "NotFoundDQNAnswer", fmt.Sprintf("%s is not found DQN answers.", mob.ID))
+				continue
+			}
+			if err := client.MoveMonster(ctx, mob, ans); err != nil {
+				slog.Warning(ctx, "FailedMoveMonster", fmt.Sprintf("failed MoveMonster. %+v", err))
+			}
+		}
 	}
 
 	return nil
 }
 
+// dqnBatch is 同じPolicyで行動を決めるMonsterのInstanceをまとめたもの
+type dqnBatch struct {
+	client  dqn.Client
+	payload *dqn.Payload
+}
+
+// policyOf is Monsterの行動を決めるPolicyの名前とClientを返す
+// MonsterTypeにPolicyが指定されていない場合は、DQNを使う
+func (client *MonsterClient) policyOf(mob *firedb.MonsterPosition) (string, dqn.Client) {
+	name, c, ok := client.Types.Policy(mob.Type)
+	if !ok {
+		return PolicyDQN, client.DQN
+	}
+	return name, c
+}
+
+// speedOf is Monsterが1Tickで移動する量を返す
+// MonsterTypeにSpeedが指定されている場合は、MonsterPositionのSpeedより優先する
+func (client *MonsterClient) speedOf(mob *firedb.MonsterPosition) float64 {
+	if t, ok := client.Types.Get(mob.Type); ok && t.Speed > 0 {
+		return t.Speed
+	}
+	return mob.Speed
+}
+
+// senseRangeOf is MonsterTypeのSense Rangeを返す
+// 指定されていない場合は0を返すので、DQNのSense Range全体を使う
+func (client *MonsterClient) senseRangeOf(mob *firedb.MonsterPosition) int {
+	if t, ok := client.Types.Get(mob.Type); ok {
+		return t.SenseRange
+	}
+	return 0
+}
+
 // UpdateMonster is DQN Predictionに基づき、Firestore上のMonsterの位置を更新する
 func (client *MonsterClient) UpdateMonster(ctx context.Context, mob *firedb.MonsterPosition, dp *dqn.Payload) error {
 	ctx, span := trace.StartSpan(ctx, "/monster/updateMonster")
@@ -102,35 +191,85 @@ func (client *MonsterClient) UpdateMonster(ctx context.Context, mob *firedb.Mons
 
 	ans, err := client.DQN.Prediction(ctx, dp)
 	if err != nil {
-		slog.Info(ctx, "DQNPayload", slog.KV{"DQNPayload", dp})
+		slog.Info(ctx, "DQNPayload", slog.KV{Key: "DQNPayload", Value: dp})
 		return errors.Wrap(err, "failed DQN.Prediction")
 	}
-	slog.Info(ctx, "DQNAnswer", slog.KV{"DQNAnswer", ans})
+	return client.MoveMonster(ctx, mob, ans)
+}
+
+// MoveMonster is DQN Answerに基づき、Monsterを動かしてFirestore上の位置を更新する
+func (client *MonsterClient) MoveMonster(ctx context.Context, mob *firedb.MonsterPosition, ans *dqn.Answer) error {
+	ctx, span := trace.StartSpan(ctx, "/monster/moveMonster")
+	defer span.End()
 
-	ms := firedb.NewMonsterStore()
+	slog.Info(ctx, "DQNAnswer", slog.KV{Key: "DQNAnswer", Value: ans})
 
-	mob.X += ans.X * mob.Speed
-	mob.Y += ans.Y * mob.Speed
-	mob.IsMove = ans.IsMove
+	ms := client.monsterStore()
+
+	mr := &MovementResolver{
+		FieldStore:  client.FieldStore,
+		Passability: client.Passability,
+	}
+	mob.Speed = client.speedOf(mob)
+	x, y, moved, err := mr.Resolve(mob.X, mob.Y, ans.X*mob.Speed, ans.Y*mob.Speed)
+	if err != nil {
+		return errors.Wrap(err, "failed MovementResolver.Resolve")
+	}
+	if ans.IsMove && !moved {
+		slog.Info(ctx, "MonsterBlocked", fmt.Sprintf("%s is blocked. x=%f,y=%f,answer=%+v", mob.ID, mob.X, mob.Y, ans))
+	}
+	mob.X = x
+	mob.Y = y
+	mob.IsMove = ans.IsMove && moved
 	mob.Angle = ans.Angle
-	monsterPositionMap[mob.ID] = mob
-	return ms.UpdatePosition(ctx, mob)
+	v, ok := client.Monsters.UpdateMovement(mob)
+	if !ok {
+		// Tickの処理中にRemoveされたMonsterなので、Firestoreには書き込まない
+		slog.Info(ctx, "MonsterRemoved", fmt.Sprintf("%s is removed from MonsterRegistry.", mob.ID))
+		return nil
+	}
+	return ms.UpdatePosition(ctx, v)
 }
 
 // BuildDQNPayload is DQNに渡すPayloadを構築する
-func BuildDQNPayload(ctx context.Context, mp *firedb.MonsterPosition, playerPositionMap map[string]*firedb.PlayerPosition) (*dqn.Payload, error) {
-	payload := &dqn.Payload{
-		Instances: []dqn.Instance{
-			dqn.Instance{},
-		},
+func (client *MonsterClient) BuildDQNPayload(ctx context.Context, mp *firedb.MonsterPosition) (*dqn.Payload, error) {
+	instance, err := client.BuildDQNInstance(ctx, mp)
+	if err != nil {
+		return nil, err
 	}
+	return &dqn.Payload{
+		Instances: []dqn.Instance{*instance},
+	}, nil
+}
+
+// BuildDQNInstance is 1体のMonsterについてDQNに渡すInstanceを構築する
+// プレイヤーはPlayerStoreの空間Indexから、Sense Rangeの中にいる分だけを取得する
+// MonsterTypeにSense Rangeが指定されている場合は、DQNのSense Rangeの中でさらに狭い範囲だけを感知する
+func (client *MonsterClient) BuildDQNInstance(ctx context.Context, mp *firedb.MonsterPosition) (*dqn.Instance, error) {
+	instance := &dqn.Instance{Selector: client.Types.Selector(mp.Type)}
 	// Monsterが中心ぐらいにいる状態
-	payload.Instances[0].State[(dqn.SenseRangeRow / 2)][(dqn.SenseRangeCol / 2)][dqn.MonsterLayer] = 1
+	instance.State[(dqn.SenseRangeRow / 2)][(dqn.SenseRangeCol / 2)][dqn.MonsterLayer] = 1
 
 	mobRow, mobCol := ConvertXYToRowCol(mp.X, mp.Y, 1.0)
-	slog.Info(ctx, "StartPlayerPositionMapRange", "Start playerPositionMap.Range.")
-	for _, p := range playerPositionMap {
-		if stime.InTime(stime.Now(), p.FirestoreUpdateAt, 10*time.Second) == false {
+	if client.FieldStore != nil {
+		if err := client.setObstacleLayer(instance, mobRow, mobCol); err != nil {
+			return nil, err
+		}
+	}
+
+	if client.PlayerStore == nil {
+		return instance, nil
+	}
+	top := mobRow - (dqn.SenseRangeRow / 2)
+	left := mobCol - (dqn.SenseRangeCol / 2)
+	rows, cols := dqn.SenseRangeRow, dqn.SenseRangeCol
+	if r := client.senseRangeOf(mp); r > 0 {
+		// MonsterTypeのSense Rangeが狭い場合は、その範囲のプレイヤーだけを感知する
+		top, left = mobRow-r, mobCol-r
+		rows, cols = r*2+1, r*2+1
+	}
+	for _, p := range client.PlayerStore.QueryRect(client.LandID, top, left, rows, cols) {
+		if stime.InTime(stime.Now(), p.FirestoreUpdateAt, playerRecentDuration) == false {
 			continue
 		}
 		plyRow, plyCol := ConvertXYToRowCol(p.X, p.Y, 1.0)
@@ -138,19 +277,52 @@ func BuildDQNPayload(ctx context.Context, mp *firedb.MonsterPosition, playerPosi
 		row := plyRow - mobRow + (dqn.SenseRangeRow / 2)
 		if row < 0 || row >= dqn.SenseRangeRow {
 			// 索敵範囲外にいる
-			slog.Info(ctx, "DQN.TargetIsFarAway", slog.KV{"row", row})
+			slog.Info(ctx, "DQN.TargetIsFarAway", slog.KV{Key: "row", Value: row})
 			continue
 		}
 		col := plyCol - mobCol + (dqn.SenseRangeCol / 2)
 		if col < 0 || col >= dqn.SenseRangeCol {
-			slog.Info(ctx, "DQN.TargetIsFarAway", slog.KV{"col", col})
+			slog.Info(ctx, "DQN.TargetIsFarAway", slog.KV{Key: "col", Value: col})
 			// 索敵範囲外にいる
 			continue
 		}
 
 		slog.Info(ctx, "DQNPayloadPlayerPosition", fmt.Sprintf("DQN.Payload.PlayerPosition row=%d,col=%d", row, col))
-		payload.Instances[0].State[row][col][dqn.PlayerLayer] = 1
+		instance.State[row][col][dqn.PlayerLayer] = 1
 	}
 
-	return payload, nil
+	return instance, nil
+}
+
+// setObstacleLayer is Monsterの周囲の障害物をDQN PayloadのObstacleLayerに設定する
+// Mapの外側には移動できないので、障害物として扱う
+func (client *MonsterClient) setObstacleLayer(instance *dqn.Instance, mobRow int, mobCol int) error {
+	rows, cols := client.FieldStore.Size()
+	for row := 0; row < dqn.SenseRangeRow; row++ {
+		for col := 0; col < dqn.SenseRangeCol; col++ {
+			fieldRow := mobRow + row - (dqn.SenseRangeRow / 2)
+			fieldCol := mobCol + col - (dqn.SenseRangeCol / 2)
+			if fieldRow < 0 || fieldRow >= rows || fieldCol < 0 || fieldCol >= cols {
+				instance.State[row][col][dqn.ObstacleLayer] = 1
+				continue
+			}
+
+			v, err := client.FieldStore.GetValue(fieldRow, fieldCol)
+			if err != nil {
+				return errors.Wrapf(err, "failed FieldStore.GetValue. row=%d,col=%d", fieldRow, fieldCol)
+			}
+			if client.Passability.IsObstacle(v) {
+				instance.State[row][col][dqn.ObstacleLayer] = 1
+			}
+		}
+	}
+	return nil
+}
+
+// monsterStore is Monsterの位置を書き込むMonsterStoreを返す
+func (client *MonsterClient) monsterStore() firedb.MonsterStore {
+	if client.MonsterStore == nil {
+		return firedb.NewMonsterStore()
+	}
+	return client.MonsterStore
 }
@@ -28,10 +28,10 @@ func TestMonsterClient_UpdateMonster(t *testing.T) {
 	d := dqn.NewClient()
 	ctx := slog.WithLog(context.Background())
 	client := MonsterClient{
-		DQN: d,
+		DQN:      d,
+		Monsters: NewMonsterRegistry(),
 	}
 
-	playerPositionMap := make(map[string]*firedb.PlayerPosition)
 	mob := &firedb.MonsterPosition{
 		ID:    "dummy",
 		X:     950,
@@ -39,7 +39,10 @@ func TestMonsterClient_UpdateMonster(t *testing.T) {
 		Angle: 180,
 		Speed: 4,
 	}
-	dp, err := BuildDQNPayload(ctx, mob, playerPositionMap)
+	if err := client.Monsters.Add(mob); err != nil {
+		t.Fatalf("failed MonsterRegistry.Add. err=%+v", err)
+	}
+	dp, err := client.BuildDQNPayload(ctx, mob)
 	if err != nil {
 		t.Fatalf("failed BuildDQNPayload. err=%+v", err)
 	}
@@ -55,6 +58,53 @@ func TestMonsterClient_UpdateMonster(t *testing.T) {
 	if e, g := 1, msDummy.UpdatePositionCount; e != g {
 		t.Fatalf("expected MonsterStore.UpdatePositionCount is %d; gpt %d", e, g)
 	}
+	v, ok := client.Monsters.Get(mob.ID)
+	if !ok {
+		t.Fatalf("%s is not found MonsterRegistry", mob.ID)
+	}
+	if e, g := 946.0, v.X; e != g {
+		t.Fatalf("expected X is %f; got %f", e, g)
+	}
+}
+
+func TestMonsterClient_UpdateMonster_Removed(t *testing.T) {
+	dqn.SetDummyClient(&DQNDummyClient{
+		DummyAnswer: &dqn.Answer{
+			X:      -1,
+			IsMove: true,
+			Speed:  4,
+		},
+	})
+
+	msDummy := &DummyMonsterStore{}
+	firedb.SetMonsterStore(msDummy)
+
+	ctx := slog.WithLog(context.Background())
+	client := MonsterClient{
+		DQN:      dqn.NewClient(),
+		Monsters: NewMonsterRegistry(),
+	}
+
+	// Registryに存在しない(Tick中にRemoveされた)Monster
+	mob := &firedb.MonsterPosition{
+		ID:    "removed",
+		X:     950,
+		Y:     1000,
+		Speed: 4,
+	}
+	dp, err := client.BuildDQNPayload(ctx, mob)
+	if err != nil {
+		t.Fatalf("failed BuildDQNPayload. err=%+v", err)
+	}
+	if err := client.UpdateMonster(ctx, mob, dp); err != nil {
+		t.Fatalf("failed UpdateMonster. err=%+v", err)
+	}
+	if e, g := 0, msDummy.UpdatePositionCount; e != g {
+		t.Fatalf("expected MonsterStore.UpdatePositionCount is %d; got %d", e, g)
+	}
+	if e, g := 0, client.Monsters.Len(); e != g {
+		t.Fatalf("expected MonsterRegistry.Len is %d; got %d", e, g)
+	}
 }
 
 func TestBuildDQNPayload(t *testing.T) {
@@ -112,7 +162,10 @@ func TestBuildDQNPayload(t *testing.T) {
 
 	ctx := slog.WithLog(context.Background())
 	for i, v := range candidates {
-		dp, err := BuildDQNPayload(ctx, v.monsterPosition, v.playerPositionMap)
+		client := MonsterClient{
+			PlayerStore: &DummyPlayerStore{PositionMap: v.playerPositionMap},
+		}
+		dp, err := client.BuildDQNPayload(ctx, v.monsterPosition)
 		if err != nil {
 			t.Fatalf("failed BuildDQNPayload. err=%+v", err)
 		}
@@ -131,3 +184,130 @@ func TestBuildDQNPayload(t *testing.T) {
 		}
 	}
 }
+
+func TestBuildDQNPayload_ObstacleLayer(t *testing.T) {
+	fs := &DummyFieldStore{}
+	// Monsterは row=31, col=29 にいる
+	fs.SetValue(31, 30, &firedb.FieldValue{Row: 31, Col: 30, ChipID: 2})
+	fs.SetValue(30, 29, &firedb.FieldValue{Row: 30, Col: 29, ChipID: 1, HitPoint: 10})
+	fs.SetValue(32, 29, &firedb.FieldValue{Row: 32, Col: 29, ChipID: 1})
+
+	client := MonsterClient{
+		FieldStore:  fs,
+		Passability: firedb.ChipPassability{1: true, 2: false},
+	}
+	mob := &firedb.MonsterPosition{
+		ID: "dummy",
+		X:  950,
+		Y:  1000,
+	}
+	ctx := slog.WithLog(context.Background())
+	dp, err := client.BuildDQNPayload(ctx, mob)
+	if err != nil {
+		t.Fatalf("failed BuildDQNPayload. err=%+v", err)
+	}
+
+	candidates := []struct {
+		row      int
+		col      int
+		obstacle float64
+	}{
+		{row: 0, col: 1, obstacle: 1.0},  // 通行不可のChip
+		{row: -1, col: 0, obstacle: 1.0}, // HitPointが残っているChip
+		{row: 1, col: 0, obstacle: 0.0},  // 通行可能なChip
+		{row: 0, col: -1, obstacle: 0.0}, // まだ同期されていないChip
+	}
+	for i, v := range candidates {
+		if e, g := v.obstacle, dp.Instances[0].State[dqn.SenseRangeRow/2+v.row][dqn.SenseRangeCol/2+v.col][dqn.ObstacleLayer]; e != g {
+			t.Fatalf("%d : expected obstacle = %f; got %f", i, e, g)
+		}
+	}
+}
+
+func TestBuildDQNPayload_ObstacleLayerOutsideMap(t *testing.T) {
+	client := MonsterClient{
+		FieldStore: &DummyFieldStore{},
+	}
+	// Mapの左上の角にいるMonster
+	mob := &firedb.MonsterPosition{
+		ID: "dummy",
+		X:  0,
+		Y:  0,
+	}
+	ctx := slog.WithLog(context.Background())
+	dp, err := client.BuildDQNPayload(ctx, mob)
+	if err != nil {
+		t.Fatalf("failed BuildDQNPayload. err=%+v", err)
+	}
+	for row := 0; row < dqn.SenseRangeRow; row++ {
+		for col := 0; col < dqn.SenseRangeCol; col++ {
+			e := 0.0
+			if row < dqn.SenseRangeRow/2 || col < dqn.SenseRangeCol/2 {
+				e = 1.0
+			}
+			if g := dp.Instances[0].State[row][col][dqn.ObstacleLayer]; e != g {
+				t.Fatalf("expected [%d][%d] = %f; got %f", row, col, e, g)
+			}
+		}
+	}
+}
+
+func TestHandleMonsters(t *testing.T) {
+	dqnDummy := &DQNDummyClient{
+		DummyAnswer: &dqn.Answer{
+			X:      1,
+			Y:      0,
+			IsMove: true,
+			Angle:  dqn.AngleRight,
+			Speed:  4,
+		},
+	}
+	dqn.SetDummyClient(dqnDummy)
+
+	msDummy := &DummyMonsterStore{}
+	firedb.SetMonsterStore(msDummy)
+
+	client := &MonsterClient{
+		DQN: dqn.NewClient(),
+		PlayerStore: &DummyPlayerStore{
+			PlayerMap: map[string]*firedb.User{
+				"sinmetal": &firedb.User{Active: true},
+			},
+			PositionMap: map[string]*firedb.PlayerPosition{
+				"sinmetal": &firedb.PlayerPosition{
+					ID:                "sinmetal",
+					X:                 900,
+					Y:                 1000,
+					FirestoreUpdateAt: stime.Now(),
+				},
+			},
+		},
+		Monsters: NewMonsterRegistry(),
+	}
+	for _, id := range []string{"mob1", "mob2", "mob3"} {
+		if err := client.Monsters.Add(&firedb.MonsterPosition{ID: id, X: 950, Y: 1000, Speed: 4}); err != nil {
+			t.Fatalf("failed MonsterRegistry.Add. err=%+v", err)
+		}
+	}
+
+	ctx := slog.WithLog(context.Background())
+	if err := handleMonsters(ctx, client); err != nil {
+		t.Fatalf("failed handleMonsters. err=%+v", err)
+	}
+
+	// 全Monsterを1回のRequestで判断している
+	if e, g := 1, dqnDummy.BatchPredictionCount; e != g {
+		t.Fatalf("expected DQN.BatchPredictionCount is %d; got %d", e, g)
+	}
+	if e, g := 3, len(dqnDummy.Body.Instances); e != g {
+		t.Fatalf("expected len(Instances) is %d; got %d", e, g)
+	}
+	if e, g := 3, msDummy.UpdatePositionCount; e != g {
+		t.Fatalf("expected MonsterStore.UpdatePositionCount is %d; got %d", e, g)
+	}
+	for _, mob := range client.Monsters.Snapshot() {
+		if e, g := 954.0, mob.X; e != g {
+			t.Fatalf("expected %s X is %f; got %f", mob.ID, e, g)
+		}
+	}
+}
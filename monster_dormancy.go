@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/metal-tile/land/dqn"
+	"github.com/metal-tile/land/firedb"
+	"github.com/sinmetal/slog"
+	"github.com/sinmetal/stime"
+)
+
+// IsAwake is Monsterの周囲WakeRadius Tile以内に、最近動いたプレイヤーがいるかどうかを返す
+// MonsterTypeにSense Rangeが指定されている場合は、WakeRadiusの代わりにSense Rangeを使う
+func (client *MonsterClient) IsAwake(mob *firedb.MonsterPosition) bool {
+	if client.PlayerStore == nil {
+		return false
+	}
+	radius := client.senseRangeOf(mob)
+	if radius <= 0 {
+		radius = client.WakeRadius
+	}
+	if radius <= 0 {
+		radius = dqn.SenseRangeRow / 2
+		if dqn.SenseRangeCol/2 > radius {
+			radius = dqn.SenseRangeCol / 2
+		}
+	}
+	recency := client.WakeRecency
+	if recency <= 0 {
+		recency = playerRecentDuration
+	}
+
+	row, col := ConvertXYToRowCol(mob.X, mob.Y, 1.0)
+	size := radius*2 + 1
+	now := stime.Now()
+	for _, p := range client.PlayerStore.QueryRect(client.LandID, row-radius, col-radius, size, size) {
+		if stime.InTime(now, p.FirestoreUpdateAt, recency) {
+			return true
+		}
+	}
+	return false
+}
+
+// updateDormancy is Monsterを休眠させるかどうかを判断して、休眠している場合はtrueを返す
+// 休眠した時に動いている状態だった場合は、止まった状態を1度だけFirestoreに書き込む
+func (client *MonsterClient) updateDormancy(ctx context.Context, mob *firedb.MonsterPosition) bool {
+	if client.dormant == nil {
+		client.dormant = make(map[string]bool)
+	}
+
+	if client.IsAwake(mob) {
+		if client.dormant[mob.ID] {
+			slog.Info(ctx, "MonsterAwake", fmt.Sprintf("%s is awake.", mob.ID))
+			delete(client.dormant, mob.ID)
+		}
+		return false
+	}
+	if client.dormant[mob.ID] {
+		return true
+	}
+
+	slog.Info(ctx, "MonsterDormant", fmt.Sprintf("%s is dormant.", mob.ID))
+	client.dormant[mob.ID] = true
+	if mob.IsMove == false {
+		return true
+	}
+	mob.IsMove = false
+	v, ok := client.Monsters.UpdateMovement(mob)
+	if !ok {
+		return true
+	}
+	if err := client.monsterStore().UpdatePosition(ctx, v); err != nil {
+		slog.Warning(ctx, "FailedUpdateDormantMonster", fmt.Sprintf("failed UpdatePosition. %+v", err))
+	}
+	return true
+}
+
+// pruneDormancy is despawnや討伐でRegistryからいなくなったMonsterを、休眠中のMonsterのIDから取り除く
+func (client *MonsterClient) pruneDormancy(mobs []*firedb.MonsterPosition) {
+	if len(client.dormant) < 1 {
+		return
+	}
+	exists := make(map[string]bool, len(mobs))
+	for _, mob := range mobs {
+		exists[mob.ID] = true
+	}
+	for id := range client.dormant {
+		if !exists[id] {
+			delete(client.dormant, id)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/metal-tile/land/dqn"
+	"github.com/metal-tile/land/firedb"
+	"github.com/sinmetal/slog"
+	"github.com/sinmetal/stime"
+)
+
+func TestMonsterClient_IsAwake(t *testing.T) {
+	now := time.Date(2019, 2, 1, 10, 0, 0, 0, time.UTC)
+	stime.SetPermafrost(now)
+	defer stime.SetPermafrost(time.Time{})
+
+	// Monsterは row=31, col=29 にいる
+	mob := &firedb.MonsterPosition{ID: "mob", X: 950, Y: 1000}
+
+	candidates := []struct {
+		player     *firedb.PlayerPosition
+		landID     string
+		wakeRadius int
+		awake      bool
+	}{
+		{player: nil, awake: false},
+		{player: &firedb.PlayerPosition{ID: "p", X: 900, Y: 1000, FirestoreUpdateAt: now}, awake: true},
+		// 4Tile離れている
+		{player: &firedb.PlayerPosition{ID: "p", X: 33 * 32, Y: 31 * 32, FirestoreUpdateAt: now}, awake: true},
+		// 5Tile離れている
+		{player: &firedb.PlayerPosition{ID: "p", X: 34 * 32, Y: 31 * 32, FirestoreUpdateAt: now}, awake: false},
+		{player: &firedb.PlayerPosition{ID: "p", X: 34 * 32, Y: 31 * 32, FirestoreUpdateAt: now}, wakeRadius: 5, awake: true},
+		// 最近動いていない
+		{player: &firedb.PlayerPosition{ID: "p", X: 900, Y: 1000, FirestoreUpdateAt: now.Add(-11 * time.Second)}, awake: false},
+		// 同じ座標でも、別のLandにいる
+		{player: &firedb.PlayerPosition{ID: "p", X: 900, Y: 1000, FirestoreUpdateAt: now, Land: "dungeon1"}, awake: false},
+		{player: &firedb.PlayerPosition{ID: "p", X: 900, Y: 1000, FirestoreUpdateAt: now}, landID: "dungeon1", awake: false},
+		{player: &firedb.PlayerPosition{ID: "p", X: 900, Y: 1000, FirestoreUpdateAt: now, Land: "dungeon1"}, landID: "dungeon1", awake: true},
+		{player: &firedb.PlayerPosition{ID: "p", X: 900, Y: 1000, FirestoreUpdateAt: now}, landID: firedb.DefaultLandID, awake: true},
+	}
+
+	for i, v := range candidates {
+		ps := &DummyPlayerStore{PositionMap: map[string]*firedb.PlayerPosition{}}
+		if v.player != nil {
+			ps.PositionMap[v.player.ID] = v.player
+		}
+		client := &MonsterClient{
+			LandID:      v.landID,
+			PlayerStore: ps,
+			WakeRadius:  v.wakeRadius,
+		}
+		if e, g := v.awake, client.IsAwake(mob); e != g {
+			t.Fatalf("%d : expected awake %t; got %t", i, e, g)
+		}
+	}
+}
+
+func TestHandleMonsters_Dormant(t *testing.T) {
+	dqnDummy := &DQNDummyClient{
+		DummyAnswer: &dqn.Answer{
+			X:      1,
+			IsMove: true,
+			Angle:  dqn.AngleRight,
+			Speed:  4,
+		},
+	}
+	msDummy := &DummyMonsterStore{}
+	client := &MonsterClient{
+		DQN:          dqnDummy,
+		MonsterStore: msDummy,
+		PlayerStore: &DummyPlayerStore{
+			PlayerMap: map[string]*firedb.User{
+				"sinmetal": &firedb.User{Active: true},
+			},
+			PositionMap: map[string]*firedb.PlayerPosition{
+				"sinmetal": &firedb.PlayerPosition{
+					ID:                "sinmetal",
+					X:                 900,
+					Y:                 1000,
+					FirestoreUpdateAt: stime.Now(),
+				},
+			},
+		},
+		Monsters: NewMonsterRegistry(),
+	}
+	if err := client.Monsters.Add(&firedb.MonsterPosition{ID: "near", X: 950, Y: 1000, Speed: 4}); err != nil {
+		t.Fatalf("failed MonsterRegistry.Add. err=%+v", err)
+	}
+	// 遠くで動いている途中のMonster
+	if err := client.Monsters.Add(&firedb.MonsterPosition{ID: "far", X: 5000, Y: 5000, Speed: 4, IsMove: true}); err != nil {
+		t.Fatalf("failed MonsterRegistry.Add. err=%+v", err)
+	}
+
+	ctx := slog.WithLog(context.Background())
+	for i := 0; i < 2; i++ {
+		if err := handleMonsters(ctx, client); err != nil {
+			t.Fatalf("failed handleMonsters. err=%+v", err)
+		}
+		// 近くにプレイヤーがいるMonsterだけDQNに送る
+		if e, g := 1, len(dqnDummy.Body.Instances); e != g {
+			t.Fatalf("%d : expected len(Instances) is %d; got %d", i, e, g)
+		}
+	}
+
+	// nearは2回、farは休眠した時に止まった状態を1回だけ書き込む
+	if e, g := 3, msDummy.UpdatePositionCount; e != g {
+		t.Fatalf("expected MonsterStore.UpdatePositionCount is %d; got %d", e, g)
+	}
+	far, ok := client.Monsters.Get("far")
+	if !ok {
+		t.Fatalf("far is not found")
+	}
+	if far.IsMove {
+		t.Fatalf("expected dormant monster is not moving")
+	}
+	if e, g := 5000.0, far.X; e != g {
+		t.Fatalf("expected dormant monster X is %f; got %f", e, g)
+	}
+}
+
+func TestHandleMonsters_DormantWithoutActivePlayer(t *testing.T) {
+	dqnDummy := &DQNDummyClient{}
+	msDummy := &DummyMonsterStore{}
+	client := &MonsterClient{
+		DQN:          dqnDummy,
+		MonsterStore: msDummy,
+		PlayerStore: &DummyPlayerStore{
+			PlayerMap:   map[string]*firedb.User{},
+			PositionMap: map[string]*firedb.PlayerPosition{},
+		},
+		Monsters: NewMonsterRegistry(),
+	}
+	// プレイヤーが全員いなくなった時に動いている途中だったMonster
+	if err := client.Monsters.Add(&firedb.MonsterPosition{ID: "mob", X: 950, Y: 1000, Speed: 4, IsMove: true}); err != nil {
+		t.Fatalf("failed MonsterRegistry.Add. err=%+v", err)
+	}
+
+	ctx := slog.WithLog(context.Background())
+	if err := handleMonsters(ctx, client); err != nil {
+		t.Fatalf("failed handleMonsters. err=%+v", err)
+	}
+	if dqnDummy.Body != nil {
+		t.Fatalf("expected no DQN request; got %+v", dqnDummy.Body)
+	}
+	if e, g := 1, msDummy.UpdatePositionCount; e != g {
+		t.Fatalf("expected MonsterStore.UpdatePositionCount is %d; got %d", e, g)
+	}
+	mob, ok := client.Monsters.Get("mob")
+	if !ok {
+		t.Fatalf("mob is not found")
+	}
+	if mob.IsMove {
+		t.Fatalf("expected dormant monster is not moving")
+	}
+}
+
+func TestHandleMonsters_PruneDormancy(t *testing.T) {
+	client := &MonsterClient{
+		DQN:          &DQNDummyClient{},
+		MonsterStore: &DummyMonsterStore{},
+		PlayerStore: &DummyPlayerStore{
+			PlayerMap:   map[string]*firedb.User{},
+			PositionMap: map[string]*firedb.PlayerPosition{},
+		},
+		Monsters: NewMonsterRegistry(),
+	}
+	for _, id := range []string{"alive", "dead"} {
+		if err := client.Monsters.Add(&firedb.MonsterPosition{ID: id, X: 950, Y: 1000, Speed: 4}); err != nil {
+			t.Fatalf("failed MonsterRegistry.Add. err=%+v", err)
+		}
+	}
+
+	ctx := slog.WithLog(context.Background())
+	if err := handleMonsters(ctx, client); err != nil {
+		t.Fatalf("failed handleMonsters. err=%+v", err)
+	}
+	if e, g := 2, len(client.dormant); e != g {
+		t.Fatalf("expected len(dormant) is %d; got %d", e, g)
+	}
+
+	client.Monsters.Remove("dead")
+	if err := handleMonsters(ctx, client); err != nil {
+		t.Fatalf("failed handleMonsters. err=%+v", err)
+	}
+	if _, ok := client.dormant["dead"]; ok {
+		t.Fatalf("expected dead is removed from dormant")
+	}
+	if !client.dormant["alive"] {
+		t.Fatalf("expected alive is dormant")
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/metal-tile/land/firedb"
+)
+
+// monsterHandler is MonsterRegistryの確認、Monsterの追加・削除を行うDebug用Handler
+// GET    /monster?id={id} : 指定したMonster, idが無い場合は全Monsterを返す
+// POST   /monster         : BodyのMonsterPosition JSONを追加する
+// DELETE /monster?id={id} : 指定したMonsterを削除する。SpawnPointから出現したMonsterは、RespawnDelayの後に次が出現する
+// 複数のLandを動かしている場合は `land={land}` で対象のLandを指定する
+func monsterHandler(lands *LandManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		land, err := lands.Resolve(r.FormValue("land"))
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintf(w, "%s", err)
+			return
+		}
+		registry := land.Monsters
+
+		switch r.Method {
+		case http.MethodGet:
+			id := r.FormValue("id")
+			if id == "" {
+				writeMonsterJSON(w, registry.Snapshot())
+				return
+			}
+			mob, ok := registry.Get(id)
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				fmt.Fprintf(w, "id %s is not found", id)
+				return
+			}
+			writeMonsterJSON(w, mob)
+		case http.MethodPost:
+			var mob firedb.MonsterPosition
+			if err := json.NewDecoder(r.Body).Decode(&mob); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprintf(w, "body is %s", err)
+				return
+			}
+			if err := registry.Add(&mob); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprintf(w, "%s", err)
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+			writeMonsterJSON(w, &mob)
+		case http.MethodDelete:
+			id := r.FormValue("id")
+			ok, err := land.Spawner.Despawn(r.Context(), id)
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				fmt.Fprintf(w, "failed despawn %s. %s", id, err)
+				return
+			}
+			if ok == false {
+				w.WriteHeader(http.StatusNotFound)
+				fmt.Fprintf(w, "id %s is not found", id)
+				return
+			}
+			fmt.Fprintf(w, "id %s is removed", id)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// monsterDamageHandler is Monsterにダメージを与えるHandler
+// POST /monster/damage?id={id}&amount={amount}&attacker={attacker} : 書き込んだDamageEventを返す
+// 複数のLandを動かしている場合は `land={land}` で対象のLandを指定する
+func monsterDamageHandler(lands *LandManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		land, err := lands.Resolve(r.FormValue("land"))
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintf(w, "%s", err)
+			return
+		}
+		amount, err := strconv.ParseFloat(r.FormValue("amount"), 64)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "amount is %s", err)
+			return
+		}
+
+		id := r.FormValue("id")
+		e, err := land.Combat.DamageMonster(r.Context(), id, amount, r.FormValue("attacker"))
+		switch err {
+		case nil:
+			writeMonsterJSON(w, e)
+		case firedb.ErrMonsterNotFound:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintf(w, "id %s is not found", id)
+		case ErrMonsterInvulnerable, ErrInvalidDamage:
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "%s", err)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintf(w, "failed damage %s. %s", id, err)
+		}
+	}
+}
+
+// monsterWriterHandler is LandごとのMonster Positionの書き込みの統計を返すDebug用Handler
+// saved はBufferによって減らせた書き込みの回数
+func monsterWriterHandler(lands *LandManager) http.HandlerFunc {
+	type stats struct {
+		firedb.MonsterWriteStats
+		Saved uint64 `json:"saved"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		m := make(map[string]stats)
+		for _, land := range lands.Lands() {
+			if land.Writer == nil {
+				continue
+			}
+			v := land.Writer.Stats()
+			m[land.ID()] = stats{MonsterWriteStats: v, Saved: v.Saved()}
+		}
+		writeMonsterJSON(w, m)
+	}
+}
+
+func writeMonsterJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		fmt.Printf("failed monster json encode. %+v\n", err)
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"sort"
+	"sync"
+
+	"github.com/metal-tile/land/firedb"
+)
+
+// ErrMonsterAlreadyExists is 既にRegistryに存在するIDのMonsterを追加しようとした時に利用する
+var ErrMonsterAlreadyExists = errors.New("monster: already exists")
+
+// ErrMonsterInvalidID is IDが空のMonsterを追加しようとした時に利用する
+var ErrMonsterInvalidID = errors.New("monster: invalid id")
+
+// MonsterRegistry is Land上で動いているMonsterを管理する
+// Monster Controlの TickerとDebug HTTP Handlerの両方から触られるので、Lockを取って操作する
+// 外に渡すMonsterPositionはCopyしたものなので、変更を反映する場合は Update を呼ぶ
+type MonsterRegistry struct {
+	mu       *sync.RWMutex
+	monsters map[string]*firedb.MonsterPosition
+}
+
+// NewMonsterRegistry is 空のMonsterRegistryを生成する
+func NewMonsterRegistry() *MonsterRegistry {
+	return &MonsterRegistry{
+		mu:       &sync.RWMutex{},
+		monsters: make(map[string]*firedb.MonsterPosition),
+	}
+}
+
+// Add is Monsterを追加する
+func (r *MonsterRegistry) Add(mob *firedb.MonsterPosition) error {
+	if mob.ID == "" {
+		return ErrMonsterInvalidID
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.monsters[mob.ID]; ok {
+		return ErrMonsterAlreadyExists
+	}
+	v := *mob
+	r.monsters[mob.ID] = &v
+	return nil
+}
+
+// Update is 既に存在するMonsterの状態を更新する
+// Tickの処理中にRemoveされたMonsterを復活させないように、存在しない場合は何もせずにfalseを返す
+func (r *MonsterRegistry) Update(mob *firedb.MonsterPosition) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.monsters[mob.ID]; !ok {
+		return false
+	}
+	v := *mob
+	r.monsters[mob.ID] = &v
+	return true
+}
+
+// UpdateMovement is 既に存在するMonsterの位置、向き、速さ、移動中かどうかだけを更新して、更新後のCopyを返す
+// Tickの処理中にCombatで変わったHitPointを、Tick開始時のSnapshotで上書きしないようにする
+// 存在しない場合は何もせずにfalseを返す
+func (r *MonsterRegistry) UpdateMovement(mob *firedb.MonsterPosition) (*firedb.MonsterPosition, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	v, ok := r.monsters[mob.ID]
+	if !ok {
+		return nil, false
+	}
+	v.X = mob.X
+	v.Y = mob.Y
+	v.Angle = mob.Angle
+	v.Speed = mob.Speed
+	v.IsMove = mob.IsMove
+	c := *v
+	return &c, true
+}
+
+// Damage is MonsterのHitPointをamountだけ減らして、更新後のCopyを返す
+// HitPointが0以下になった場合は、同じLockの中でRegistryから取り除き、killedをtrueにする
+// 同時に与えられた別のダメージは、取り除かれた後なので firedb.ErrMonsterNotFound になる
+// 存在しない場合は firedb.ErrMonsterNotFound を、HitPointを持たないMonsterの場合は ErrMonsterInvulnerable を返す
+func (r *MonsterRegistry) Damage(id string, amount float64) (mob *firedb.MonsterPosition, killed bool, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	v, ok := r.monsters[id]
+	if !ok {
+		return nil, false, firedb.ErrMonsterNotFound
+	}
+	if v.HitPoint <= 0 {
+		return nil, false, ErrMonsterInvulnerable
+	}
+	v.HitPoint -= amount
+	c := *v
+	if c.HitPoint <= 0 {
+		c.HitPoint = 0
+		delete(r.monsters, id)
+		return &c, true, nil
+	}
+	return &c, false, nil
+}
+
+// FillHitPoint is HitPointが設定されていないMonsterに、MonsterTypeのHitPointを設定する
+// Seed FileやFirestoreから読み込んだMonsterは、ダメージを受けるまでHitPointを持っていないので、読み込んだ後に呼ぶ
+// 設定したMonsterの数を返す
+func (r *MonsterRegistry) FillHitPoint(types *MonsterTypeCatalog) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	count := 0
+	for _, v := range r.monsters {
+		if v.HitPoint > 0 {
+			continue
+		}
+		t, ok := types.Get(v.Type)
+		if !ok || t.HitPoint <= 0 {
+			continue
+		}
+		v.HitPoint = t.HitPoint
+		count++
+	}
+	return count
+}
+
+// Remove is Monsterを削除する
+// 存在しなかった場合はfalseを返す
+func (r *MonsterRegistry) Remove(id string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.monsters[id]; !ok {
+		return false
+	}
+	delete(r.monsters, id)
+	return true
+}
+
+// Get is 指定したIDのMonsterのCopyを返す
+func (r *MonsterRegistry) Get(id string) (*firedb.MonsterPosition, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	mob, ok := r.monsters[id]
+	if !ok {
+		return nil, false
+	}
+	v := *mob
+	return &v, true
+}
+
+// Snapshot is 全MonsterのCopyをID順に並べて返す
+func (r *MonsterRegistry) Snapshot() []*firedb.MonsterPosition {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	l := make([]*firedb.MonsterPosition, 0, len(r.monsters))
+	for _, mob := range r.monsters {
+		v := *mob
+		l = append(l, &v)
+	}
+	sort.Slice(l, func(i, j int) bool {
+		return l[i].ID < l[j].ID
+	})
+	return l
+}
+
+// Len is 登録されているMonsterの数を返す
+func (r *MonsterRegistry) Len() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return len(r.monsters)
+}
+
+// LoadDefinition is Monsterの定義を読み込んでRegistryに追加する
+// seedFileが指定されている場合はSeed Fileから、指定されていない場合はstoreを通してFirestoreから読み込む
+func (r *MonsterRegistry) LoadDefinition(ctx context.Context, store firedb.MonsterStore, seedFile string) error {
+	var l []*firedb.MonsterPosition
+	if seedFile != "" {
+		sl, err := ReadMonsterSeedFile(seedFile)
+		if err != nil {
+			return err
+		}
+		l = sl
+	} else {
+		fl, err := store.ListDefinition(ctx)
+		if err != nil {
+			return err
+		}
+		l = fl
+	}
+
+	for _, mob := range l {
+		if err := r.Add(mob); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Restore is 最後にFirestoreに書き込んだMonsterの状態をRegistryに戻す
+// 定義を読み込んだ後に呼ぶと、定義にあるMonsterは止まる前の位置や向きに戻り、定義に無いMonsterは追加される
+// 戻したMonsterの数を返す
+func (r *MonsterRegistry) Restore(ctx context.Context, store firedb.MonsterStore) (int, error) {
+	l, err := store.List(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	count := 0
+	for _, mob := range l {
+		if mob.ID == "" {
+			continue
+		}
+		v := *mob
+		r.monsters[mob.ID] = &v
+		count++
+	}
+	return count, nil
+}
+
+// ReadMonsterSeedFile is MonsterPositionのJSON ArrayのSeed Fileを読み込む
+func ReadMonsterSeedFile(path string) ([]*firedb.MonsterPosition, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var l []*firedb.MonsterPosition
+	if err := json.Unmarshal(b, &l); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/metal-tile/land/firedb"
+)
+
+func TestMonsterRegistry(t *testing.T) {
+	r := NewMonsterRegistry()
+
+	mob := &firedb.MonsterPosition{
+		ID:    "dummy",
+		X:     950,
+		Y:     1000,
+		Speed: 4,
+	}
+	if err := r.Add(mob); err != nil {
+		t.Fatalf("failed Add. err=%+v", err)
+	}
+	if e, g := ErrMonsterAlreadyExists, r.Add(mob); e != g {
+		t.Fatalf("expected err is %+v; got %+v", e, g)
+	}
+	if e, g := ErrMonsterInvalidID, r.Add(&firedb.MonsterPosition{}); e != g {
+		t.Fatalf("expected err is %+v; got %+v", e, g)
+	}
+
+	// Registryの中身は外から変更できない
+	mob.X = 0
+	v, ok := r.Get("dummy")
+	if !ok {
+		t.Fatalf("dummy is not found")
+	}
+	if e, g := 950.0, v.X; e != g {
+		t.Fatalf("expected X is %f; got %f", e, g)
+	}
+	v.X = 0
+	if e, g := 950.0, r.Snapshot()[0].X; e != g {
+		t.Fatalf("expected X is %f; got %f", e, g)
+	}
+
+	v.X = 960
+	if r.Update(v) == false {
+		t.Fatalf("failed Update")
+	}
+	if e, g := 960.0, r.Snapshot()[0].X; e != g {
+		t.Fatalf("expected X is %f; got %f", e, g)
+	}
+
+	if r.Remove("dummy") == false {
+		t.Fatalf("failed Remove")
+	}
+	if r.Remove("dummy") {
+		t.Fatalf("expected Remove is false after removed")
+	}
+	if r.Update(v) {
+		t.Fatalf("expected Update is false after removed")
+	}
+	if e, g := 0, r.Len(); e != g {
+		t.Fatalf("expected Len is %d; got %d", e, g)
+	}
+}
+
+func TestMonsterRegistry_Concurrent(t *testing.T) {
+	r := NewMonsterRegistry()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			id := fmt.Sprintf("mob%d", i)
+			for j := 0; j < 100; j++ {
+				r.Add(&firedb.MonsterPosition{ID: id})
+				for _, mob := range r.Snapshot() {
+					mob.X++
+					r.Update(mob)
+				}
+				r.Remove(id)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if e, g := 0, r.Len(); e != g {
+		t.Fatalf("expected Len is %d; got %d", e, g)
+	}
+}
+
+func TestMonsterRegistry_LoadDefinition(t *testing.T) {
+	ctx := context.Background()
+
+	r := NewMonsterRegistry()
+	if err := r.LoadDefinition(ctx, firedb.NewMonsterStore(), "testdata/monster-seed.json"); err != nil {
+		t.Fatalf("failed LoadDefinition. err=%+v", err)
+	}
+	v, ok := r.Get("dummy")
+	if !ok {
+		t.Fatalf("dummy is not found")
+	}
+	if e, g := 950.0, v.X; e != g {
+		t.Fatalf("expected X is %f; got %f", e, g)
+	}
+	if e, g := 4.0, v.Speed; e != g {
+		t.Fatalf("expected Speed is %f; got %f", e, g)
+	}
+
+	ms := &DummyMonsterStore{
+		Definitions: []*firedb.MonsterPosition{
+			&firedb.MonsterPosition{ID: "mob1"},
+			&firedb.MonsterPosition{ID: "mob2"},
+		},
+	}
+	r = NewMonsterRegistry()
+	if err := r.LoadDefinition(ctx, ms, ""); err != nil {
+		t.Fatalf("failed LoadDefinition. err=%+v", err)
+	}
+	if e, g := 2, r.Len(); e != g {
+		t.Fatalf("expected Len is %d; got %d", e, g)
+	}
+}
+
+func TestMonsterRegistry_Restore(t *testing.T) {
+	ctx := context.Background()
+
+	r := NewMonsterRegistry()
+	if err := r.LoadDefinition(ctx, firedb.NewMonsterStore(), "testdata/monster-seed.json"); err != nil {
+		t.Fatalf("failed LoadDefinition. err=%+v", err)
+	}
+
+	ms := &DummyMonsterStore{
+		Positions: []*firedb.MonsterPosition{
+			// 定義にあるMonsterは、止まる前の状態に戻す
+			&firedb.MonsterPosition{ID: "dummy", X: 1200, Y: 800, Angle: 90, Speed: 6, IsMove: true},
+			// 定義に無いMonsterは追加する
+			&firedb.MonsterPosition{ID: "spawned", X: 100, Y: 100, Speed: 4},
+			&firedb.MonsterPosition{ID: ""},
+		},
+	}
+	n, err := r.Restore(ctx, ms)
+	if err != nil {
+		t.Fatalf("failed Restore. err=%+v", err)
+	}
+	if e, g := 2, n; e != g {
+		t.Fatalf("expected restored %d; got %d", e, g)
+	}
+	if e, g := 2, r.Len(); e != g {
+		t.Fatalf("expected Len is %d; got %d", e, g)
+	}
+
+	v, ok := r.Get("dummy")
+	if !ok {
+		t.Fatalf("dummy is not found")
+	}
+	if e, g := *ms.Positions[0], *v; e != g {
+		t.Fatalf("expected %+v; got %+v", e, g)
+	}
+	if _, ok := r.Get("spawned"); !ok {
+		t.Fatalf("spawned is not found")
+	}
+
+	// Registryの中身はCopyなので、Storeの値を書き換えても影響しない
+	ms.Positions[0].X = 0
+	v, _ = r.Get("dummy")
+	if e, g := 1200.0, v.X; e != g {
+		t.Fatalf("expected X is %f; got %f", e, g)
+	}
+}
+
+func TestMonsterRegistry_UpdateMovement(t *testing.T) {
+	r := NewMonsterRegistry()
+	if err := r.Add(&firedb.MonsterPosition{ID: "mob", X: 100, Y: 100, Speed: 4, HitPoint: 10}); err != nil {
+		t.Fatalf("failed Add. err=%+v", err)
+	}
+
+	// Tick開始時のSnapshotの後にダメージを受けても、移動でHitPointを戻さない
+	snapshot, _ := r.Get("mob")
+	if _, _, err := r.Damage("mob", 3); err != nil {
+		t.Fatalf("failed Damage. err=%+v", err)
+	}
+	snapshot.X = 104
+	snapshot.IsMove = true
+	v, ok := r.UpdateMovement(snapshot)
+	if !ok {
+		t.Fatalf("failed UpdateMovement")
+	}
+	expected := firedb.MonsterPosition{ID: "mob", X: 104, Y: 100, Speed: 4, IsMove: true, HitPoint: 7}
+	if e, g := expected, *v; e != g {
+		t.Fatalf("expected %+v; got %+v", e, g)
+	}
+
+	r.Remove("mob")
+	if _, ok := r.UpdateMovement(snapshot); ok {
+		t.Fatalf("expected removed monster is not updated")
+	}
+	if _, _, err := r.Damage("mob", 3); err != firedb.ErrMonsterNotFound {
+		t.Fatalf("expected ErrMonsterNotFound; got %+v", err)
+	}
+}
+
+func TestMonsterRegistry_Damage(t *testing.T) {
+	types, err := NewMonsterTypeCatalog([]*firedb.MonsterType{
+		{ID: "slime", HitPoint: 10},
+		{ID: "statue"},
+	})
+	if err != nil {
+		t.Fatalf("failed NewMonsterTypeCatalog. err=%+v", err)
+	}
+	r := NewMonsterRegistry()
+	for _, mob := range []*firedb.MonsterPosition{
+		{ID: "slime", Type: "slime"},
+		{ID: "wounded", Type: "slime", HitPoint: 3},
+		{ID: "statue", Type: "statue"},
+	} {
+		if err := r.Add(mob); err != nil {
+			t.Fatalf("failed Add. err=%+v", err)
+		}
+	}
+	// 読み込んだ時点でダメージを受けているMonsterは、そのままのHitPointを使う
+	if e, g := 1, r.FillHitPoint(types); e != g {
+		t.Fatalf("expected FillHitPoint is %d; got %d", e, g)
+	}
+
+	candidates := []struct {
+		id       string
+		hitPoint float64
+		killed   bool
+		err      error
+	}{
+		{id: "slime", hitPoint: 6},
+		{id: "wounded", hitPoint: 0, killed: true},
+		{id: "wounded", err: firedb.ErrMonsterNotFound},
+		{id: "statue", err: ErrMonsterInvulnerable},
+	}
+	for i, v := range candidates {
+		mob, killed, err := r.Damage(v.id, 4)
+		if err != v.err {
+			t.Fatalf("%d : expected err %v; got %v", i, v.err, err)
+		}
+		if err != nil {
+			continue
+		}
+		if e, g := v.hitPoint, mob.HitPoint; e != g {
+			t.Fatalf("%d : expected HitPoint %f; got %f", i, e, g)
+		}
+		if e, g := v.killed, killed; e != g {
+			t.Fatalf("%d : expected killed %t; got %t", i, e, g)
+		}
+	}
+	// 倒されたMonsterは、Damageと同じLockの中で取り除かれている
+	if _, ok := r.Get("wounded"); ok {
+		t.Fatalf("expected killed monster is removed")
+	}
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/metal-tile/land/firedb"
+	"github.com/sinmetal/slog"
+	"github.com/sinmetal/stime"
+)
+
+// DefaultSpawnInterval is MonsterSpawnerがSpawnPointを確認する間隔
+const DefaultSpawnInterval = 1 * time.Second
+
+// MonsterSpawner is SpawnPointの定義に従って、Monsterを出現させたり消したりする
+// 出現させたMonsterはRegistryに追加して、PositionのDocumentを作る
+// 消したMonsterはRegistryから取り除いて、PositionのDocumentを削除し、RespawnDelayの後に同じSpawnPointから次を出現させる
+type MonsterSpawner struct {
+	Store        firedb.SpawnStore
+	MonsterStore firedb.MonsterStore
+	Monsters     *MonsterRegistry
+
+	// FieldStore is SpawnPointがFieldの中にあるかを、Loadで確認するために使う
+	// nilの場合は firedb.MapSizeRow x firedb.MapSizeCol のFieldとして扱う
+	FieldStore firedb.FieldStore
+
+	// Types is 出現させるMonsterの速さとHPを決めるために使う
+	// SpawnPointのMonsterTypeが定義されているかをLoadで確認する
+	Types *MonsterTypeCatalog
+
+	// Interval is SpawnPointを確認する間隔
+	// 0の場合は DefaultSpawnInterval を使う
+	Interval time.Duration
+
+	mu     sync.Mutex
+	points map[string]*firedb.SpawnPoint
+	areas  map[string]int
+
+	// respawns is SpawnPointごとの、次のMonsterを出現させられるようになる時刻
+	// 時刻になるまでは、その分だけSpawnPointのMaxAliveを減らして扱う
+	respawns map[string][]time.Time
+	seq      int
+}
+
+// NewMonsterSpawner is MonsterSpawnerを生成する
+func NewMonsterSpawner(store firedb.SpawnStore, monsterStore firedb.MonsterStore, registry *MonsterRegistry) *MonsterSpawner {
+	return &MonsterSpawner{
+		Store:        store,
+		MonsterStore: monsterStore,
+		Monsters:     registry,
+		points:       make(map[string]*firedb.SpawnPoint),
+		areas:        make(map[string]int),
+		respawns:     make(map[string][]time.Time),
+	}
+}
+
+// Load is StoreからSpawnPointとSpawnAreaを読み込む
+// 既に読み込んでいる定義は置き換える
+func (s *MonsterSpawner) Load(ctx context.Context) error {
+	pl, err := s.Store.ListPoints(ctx)
+	if err != nil {
+		return err
+	}
+	al, err := s.Store.ListAreas(ctx)
+	if err != nil {
+		return err
+	}
+
+	rows, cols := FieldSize(s.FieldStore)
+	points := make(map[string]*firedb.SpawnPoint)
+	for _, p := range pl {
+		if err := p.Validate(rows, cols); err != nil {
+			return err
+		}
+		if _, ok := s.Types.Get(p.MonsterType); !ok {
+			return fmt.Errorf("spawn point %s has unknown monster type. monsterType = %s", p.ID, p.MonsterType)
+		}
+		v := *p
+		points[p.ID] = &v
+	}
+	areas := make(map[string]int)
+	for _, a := range al {
+		if a.MaxAlive < 0 {
+			return fmt.Errorf("spawn area %s maxAlive must not be negative. maxAlive = %d", a.ID, a.MaxAlive)
+		}
+		areas[a.ID] = a.MaxAlive
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.points = points
+	s.areas = areas
+	return nil
+}
+
+// Points is 読み込んでいるSpawnPointのCopyをID順に並べて返す
+func (s *MonsterSpawner) Points() []*firedb.SpawnPoint {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.sortedPoints()
+}
+
+func (s *MonsterSpawner) sortedPoints() []*firedb.SpawnPoint {
+	l := make([]*firedb.SpawnPoint, 0, len(s.points))
+	for _, p := range s.points {
+		v := *p
+		l = append(l, &v)
+	}
+	sort.Slice(l, func(i, j int) bool {
+		return l[i].ID < l[j].ID
+	})
+	return l
+}
+
+// Tick is 全てのSpawnPointを確認して、足りない分のMonsterを出現させる
+// SpawnPointのMaxAliveと、SpawnAreaのMaxAliveの両方を超えないようにする
+// 出現させたMonsterを返す
+func (s *MonsterSpawner) Tick(ctx context.Context) ([]*firedb.MonsterPosition, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	alive := make(map[string]int)
+	for _, mob := range s.Monsters.Snapshot() {
+		if mob.SpawnPoint != "" {
+			alive[mob.SpawnPoint]++
+		}
+	}
+	areaAlive := make(map[string]int)
+	for id, n := range alive {
+		if p, ok := s.points[id]; ok && p.Area != "" {
+			areaAlive[p.Area] += n
+		}
+	}
+
+	now := stime.Now()
+	var spawned []*firedb.MonsterPosition
+	for _, p := range s.sortedPoints() {
+		waiting := s.pruneRespawns(p.ID, now)
+		n := p.MaxAlive - alive[p.ID] - waiting
+		if max, ok := s.areas[p.Area]; ok {
+			if room := max - areaAlive[p.Area]; n > room {
+				n = room
+			}
+		}
+		for i := 0; i < n; i++ {
+			mob, err := s.spawn(ctx, p)
+			if err != nil {
+				return spawned, err
+			}
+			spawned = append(spawned, mob)
+			areaAlive[p.Area]++
+		}
+	}
+	return spawned, nil
+}
+
+// pruneRespawns is 時刻を過ぎたRespawnの待ちを取り除いて、まだ待っている数を返す
+func (s *MonsterSpawner) pruneRespawns(id string, now time.Time) int {
+	var l []time.Time
+	for _, at := range s.respawns[id] {
+		if now.Before(at) {
+			l = append(l, at)
+		}
+	}
+	if len(l) < 1 {
+		delete(s.respawns, id)
+		return 0
+	}
+	s.respawns[id] = l
+	return len(l)
+}
+
+// spawn is SpawnPointのTileにMonsterを1体出現させる
+func (s *MonsterSpawner) spawn(ctx context.Context, p *firedb.SpawnPoint) (*firedb.MonsterPosition, error) {
+	t, ok := s.Types.Get(p.MonsterType)
+	if !ok {
+		return nil, fmt.Errorf("spawn point %s has unknown monster type. monsterType = %s", p.ID, p.MonsterType)
+	}
+	mob := &firedb.MonsterPosition{
+		ID:         s.nextID(p.ID),
+		Speed:      t.Speed,
+		HitPoint:   t.HitPoint,
+		Angle:      180,
+		X:          float64(p.Col) * firedb.MapChipWidth,
+		Y:          float64(p.Row) * firedb.MapChipHeight,
+		Type:       p.MonsterType,
+		SpawnPoint: p.ID,
+	}
+	if err := s.MonsterStore.UpdatePosition(ctx, mob); err != nil {
+		return nil, err
+	}
+	if err := s.Monsters.Add(mob); err != nil {
+		return nil, err
+	}
+	slog.Info(ctx, "MonsterSpawned", fmt.Sprintf("%s is spawned at %s.", mob.ID, p.ID))
+	return mob, nil
+}
+
+// nextID is Registryに存在しない `{spawnPoint}-{seq}` 形式のIDを返す
+// 再起動した後にRestoreしたMonsterと重ならないように、存在するIDは飛ばす
+func (s *MonsterSpawner) nextID(pointID string) string {
+	for {
+		s.seq++
+		id := fmt.Sprintf("%s-%d", pointID, s.seq)
+		if _, ok := s.Monsters.Get(id); !ok {
+			return id
+		}
+	}
+}
+
+// Despawn is MonsterをRegistryから取り除いて、PositionのDocumentを削除する
+// SpawnPointから出現したMonsterの場合は、RespawnDelayの後に次を出現させる
+// Registryに存在しなかった場合はfalseを返す
+func (s *MonsterSpawner) Despawn(ctx context.Context, id string) (bool, error) {
+	mob, ok := s.Monsters.Get(id)
+	if !ok {
+		return false, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.Monsters.Remove(id) == false {
+		return false, nil
+	}
+	if err := s.removed(ctx, mob); err != nil {
+		return true, err
+	}
+	return true, nil
+}
+
+// Damage is Monsterにダメージを与えて、更新後のCopyを返す
+// HitPointが0以下になった場合はRegistryから取り除かれるので、Despawnと同じようにPositionのDocumentを削除する
+// そうでない場合はPositionを書き込む。Despawnと同じLockの中で書き込むので、削除したDocumentを復活させない
+// エラーは MonsterRegistry.Damage と同じ
+func (s *MonsterSpawner) Damage(ctx context.Context, id string, amount float64) (*firedb.MonsterPosition, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	mob, killed, err := s.Monsters.Damage(id, amount)
+	if err != nil {
+		return nil, false, err
+	}
+	if killed {
+		return mob, true, s.removed(ctx, mob)
+	}
+	return mob, false, s.MonsterStore.UpdatePosition(ctx, mob)
+}
+
+// removed is Registryから取り除いたMonsterのRespawnを予約して、PositionのDocumentを削除する
+// 呼び出し側でmuのLockを取る
+func (s *MonsterSpawner) removed(ctx context.Context, mob *firedb.MonsterPosition) error {
+	if p, ok := s.points[mob.SpawnPoint]; ok {
+		s.respawns[p.ID] = append(s.respawns[p.ID], stime.Now().Add(p.RespawnDelay()))
+	}
+	return s.MonsterStore.Delete(ctx, mob.ID)
+}
+
+// Run is SpawnPointを読み込んで、Intervalごとに Tick する
+func (s *MonsterSpawner) Run(ctx context.Context) error {
+	if err := s.Load(ctx); err != nil {
+		return err
+	}
+
+	interval := s.Interval
+	if interval <= 0 {
+		interval = DefaultSpawnInterval
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+			tctx := slog.WithLog(context.Background())
+			if _, err := s.Tick(tctx); err != nil {
+				slog.Warning(tctx, "FailedSpawnMonster", fmt.Sprintf("%+v", err))
+			}
+			slog.Flush(tctx)
+		}
+	}
+}
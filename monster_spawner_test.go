@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/metal-tile/land/firedb"
+	"github.com/sinmetal/slog"
+	"github.com/sinmetal/stime"
+)
+
+// newTestMonsterSpawner is SpawnPointのMonsterTypeとして slime, bat を定義したMonsterSpawnerを生成する
+func newTestMonsterSpawner(t *testing.T, store firedb.SpawnStore, monsterStore firedb.MonsterStore, registry *MonsterRegistry) *MonsterSpawner {
+	types, err := NewMonsterTypeCatalog([]*firedb.MonsterType{
+		{ID: "slime", HitPoint: 10, Speed: 4},
+		{ID: "bat", HitPoint: 5, Speed: 8},
+	})
+	if err != nil {
+		t.Fatalf("failed NewMonsterTypeCatalog. err=%+v", err)
+	}
+	s := NewMonsterSpawner(store, monsterStore, registry)
+	s.Types = types
+	return s
+}
+
+func TestMonsterSpawner_Tick(t *testing.T) {
+	ctx := slog.WithLog(context.Background())
+
+	candidates := []struct {
+		name   string
+		points []*firedb.SpawnPoint
+		areas  []*firedb.SpawnArea
+		alive  map[string]int
+	}{
+		{
+			name: "spawn up to maxAlive",
+			points: []*firedb.SpawnPoint{
+				{ID: "slime", Row: 10, Col: 20, MonsterType: "slime", MaxAlive: 3},
+				{ID: "bat", Row: 30, Col: 40, MonsterType: "bat", MaxAlive: 1},
+			},
+			alive: map[string]int{"slime": 3, "bat": 1},
+		},
+		{
+			name: "area cap",
+			points: []*firedb.SpawnPoint{
+				{ID: "cave1", Row: 10, Col: 20, MaxAlive: 3, Area: "cave", MonsterType: "slime"},
+				{ID: "cave2", Row: 11, Col: 20, MaxAlive: 3, Area: "cave", MonsterType: "slime"},
+				{ID: "forest", Row: 50, Col: 50, MaxAlive: 2, Area: "forest", MonsterType: "slime"},
+			},
+			areas: []*firedb.SpawnArea{
+				{ID: "cave", MaxAlive: 4},
+			},
+			alive: map[string]int{"cave1": 3, "cave2": 1, "forest": 2},
+		},
+	}
+
+	for _, c := range candidates {
+		ms := &DummyMonsterStore{}
+		registry := NewMonsterRegistry()
+		s := newTestMonsterSpawner(t, &DummySpawnStore{Points: c.points, Areas: c.areas}, ms, registry)
+		if err := s.Load(ctx); err != nil {
+			t.Fatalf("%s : failed Load. err=%+v", c.name, err)
+		}
+
+		// 2回目のTickでは、既に上限まで出現しているので何も出現させない
+		for i := 0; i < 2; i++ {
+			if _, err := s.Tick(ctx); err != nil {
+				t.Fatalf("%s : failed Tick. err=%+v", c.name, err)
+			}
+		}
+
+		alive := make(map[string]int)
+		for _, mob := range registry.Snapshot() {
+			alive[mob.SpawnPoint]++
+		}
+		for id, e := range c.alive {
+			if g := alive[id]; e != g {
+				t.Fatalf("%s : expected %s alive is %d; got %d", c.name, id, e, g)
+			}
+		}
+		if e, g := registry.Len(), ms.UpdatePositionCount; e != g {
+			t.Fatalf("%s : expected UpdatePositionCount is %d; got %d", c.name, e, g)
+		}
+	}
+}
+
+func TestMonsterSpawner_Spawn(t *testing.T) {
+	ctx := slog.WithLog(context.Background())
+
+	registry := NewMonsterRegistry()
+	// Restoreした前回のMonsterとIDが重ならないようにする
+	if err := registry.Add(&firedb.MonsterPosition{ID: "slime-1", SpawnPoint: "slime"}); err != nil {
+		t.Fatalf("failed Add. err=%+v", err)
+	}
+	store := &DummySpawnStore{Points: []*firedb.SpawnPoint{
+		{ID: "slime", Row: 10, Col: 20, MonsterType: "slime", MaxAlive: 2},
+	}}
+	s := newTestMonsterSpawner(t, store, &DummyMonsterStore{}, registry)
+	if err := s.Load(ctx); err != nil {
+		t.Fatalf("failed Load. err=%+v", err)
+	}
+	l, err := s.Tick(ctx)
+	if err != nil {
+		t.Fatalf("failed Tick. err=%+v", err)
+	}
+	if e, g := 1, len(l); e != g {
+		t.Fatalf("expected %d spawned; got %d", e, g)
+	}
+	mob := l[0]
+	if e, g := "slime-2", mob.ID; e != g {
+		t.Fatalf("expected ID is %s; got %s", e, g)
+	}
+	if e, g := "slime", mob.Type; e != g {
+		t.Fatalf("expected Type is %s; got %s", e, g)
+	}
+	// 速さとHPはMonsterTypeのものを使う
+	if mob.Speed != 4 || mob.HitPoint != 10 {
+		t.Fatalf("expected Speed 4, HitPoint 10; got Speed %f, HitPoint %f", mob.Speed, mob.HitPoint)
+	}
+	row, col := ConvertXYToRowCol(mob.X, mob.Y, 1.0)
+	if row != 10 || col != 20 {
+		t.Fatalf("expected spawned at (10, 20); got (%d, %d)", row, col)
+	}
+}
+
+func TestMonsterSpawner_Despawn(t *testing.T) {
+	ctx := slog.WithLog(context.Background())
+	start := time.Date(2019, 2, 1, 10, 0, 0, 0, time.UTC)
+	stime.SetPermafrost(start)
+	defer stime.SetPermafrost(time.Time{})
+
+	ms := &DummyMonsterStore{}
+	registry := NewMonsterRegistry()
+	store := &DummySpawnStore{Points: []*firedb.SpawnPoint{
+		{ID: "slime", Row: 10, Col: 20, MonsterType: "slime", MaxAlive: 1, RespawnDelaySec: 30},
+	}}
+	s := newTestMonsterSpawner(t, store, ms, registry)
+	if err := s.Load(ctx); err != nil {
+		t.Fatalf("failed Load. err=%+v", err)
+	}
+	l, err := s.Tick(ctx)
+	if err != nil {
+		t.Fatalf("failed Tick. err=%+v", err)
+	}
+	if e, g := 1, len(l); e != g {
+		t.Fatalf("expected %d spawned; got %d", e, g)
+	}
+
+	ok, err := s.Despawn(ctx, l[0].ID)
+	if err != nil {
+		t.Fatalf("failed Despawn. err=%+v", err)
+	}
+	if !ok {
+		t.Fatalf("expected %s is despawned", l[0].ID)
+	}
+	if e, g := 0, registry.Len(); e != g {
+		t.Fatalf("expected Len is %d; got %d", e, g)
+	}
+	if e, g := 1, len(ms.Deleted); e != g {
+		t.Fatalf("expected %d deleted; got %d", e, g)
+	}
+	if ok, _ := s.Despawn(ctx, l[0].ID); ok {
+		t.Fatalf("expected %s is already despawned", l[0].ID)
+	}
+
+	candidates := []struct {
+		elapsed time.Duration
+		spawned int
+	}{
+		{elapsed: 29 * time.Second, spawned: 0},
+		{elapsed: 30 * time.Second, spawned: 1},
+		{elapsed: 60 * time.Second, spawned: 0},
+	}
+	for i, v := range candidates {
+		stime.SetPermafrost(start.Add(v.elapsed))
+		l, err := s.Tick(ctx)
+		if err != nil {
+			t.Fatalf("%d : failed Tick. err=%+v", i, err)
+		}
+		if e, g := v.spawned, len(l); e != g {
+			t.Fatalf("%d : expected %d spawned; got %d", i, e, g)
+		}
+	}
+}
+
+func TestMonsterSpawner_DespawnDuringMove(t *testing.T) {
+	ctx := slog.WithLog(context.Background())
+
+	inner := &DummyMonsterStore{}
+	ms := firedb.NewBufferedMonsterStore(inner, 0)
+	registry := NewMonsterRegistry()
+	if err := registry.Add(&firedb.MonsterPosition{ID: "mob", X: 950, Y: 1000}); err != nil {
+		t.Fatalf("failed MonsterRegistry.Add. err=%+v", err)
+	}
+	s := newTestMonsterSpawner(t, &DummySpawnStore{}, ms, registry)
+
+	// MoveMonsterがRegistryを更新した後、UpdatePositionする前にDespawnされる
+	mob, _ := registry.Get("mob")
+	mob.X = 954
+	v, ok := registry.UpdateMovement(mob)
+	if !ok {
+		t.Fatalf("expected mob is updated")
+	}
+	if ok, err := s.Despawn(ctx, "mob"); err != nil || !ok {
+		t.Fatalf("failed Despawn. ok=%t, err=%+v", ok, err)
+	}
+	if err := ms.UpdatePosition(ctx, v); err != nil {
+		t.Fatalf("failed UpdatePosition. err=%+v", err)
+	}
+	if err := ms.Flush(ctx); err != nil {
+		t.Fatalf("failed Flush. err=%+v", err)
+	}
+
+	// 削除したDocumentを書き込みで復活させない
+	if e, g := 0, inner.UpdatePositionCount; e != g {
+		t.Fatalf("expected UpdatePositionCount is %d; got %d", e, g)
+	}
+	if e, g := 1, len(inner.Deleted); e != g {
+		t.Fatalf("expected %d deleted; got %d", e, g)
+	}
+}
+
+func TestMonsterSpawner_LoadInvalid(t *testing.T) {
+	ctx := slog.WithLog(context.Background())
+
+	candidates := []*DummySpawnStore{
+		{Points: []*firedb.SpawnPoint{{ID: "out", Row: firedb.MapSizeRow, Col: 0, MaxAlive: 1, MonsterType: "slime"}}},
+		{Points: []*firedb.SpawnPoint{{ID: "negative", Row: 0, Col: 0, MaxAlive: -1, MonsterType: "slime"}}},
+		{Areas: []*firedb.SpawnArea{{ID: "cave", MaxAlive: -1}}},
+		// MonsterTypeが無いと、出現させるMonsterの速さとHPが決まらない
+		{Points: []*firedb.SpawnPoint{{ID: "untyped", Row: 0, Col: 0, MaxAlive: 1}}},
+		{Points: []*firedb.SpawnPoint{{ID: "unknown", Row: 0, Col: 0, MonsterType: "dragon", MaxAlive: 1}}},
+	}
+	for i, v := range candidates {
+		s := newTestMonsterSpawner(t, v, &DummyMonsterStore{}, NewMonsterRegistry())
+		if err := s.Load(ctx); err == nil {
+			t.Fatalf("%d : expected error", i)
+		}
+	}
+}
+
+func TestMonsterSpawner_LoadWithFieldSize(t *testing.T) {
+	ctx := slog.WithLog(context.Background())
+
+	store := &DummySpawnStore{Points: []*firedb.SpawnPoint{{ID: "corner", Row: 29, Col: 39, MaxAlive: 1, MonsterType: "slime"}}}
+	s := newTestMonsterSpawner(t, store, &DummyMonsterStore{}, NewMonsterRegistry())
+	s.FieldStore = &DummyFieldStore{Rows: 30, Cols: 40}
+	if err := s.Load(ctx); err != nil {
+		t.Fatalf("failed Load. err=%+v", err)
+	}
+
+	// Fieldより小さいLandでは、既定の大きさの中でもFieldの外として扱う
+	store.Points = []*firedb.SpawnPoint{{ID: "out", Row: 30, Col: 0, MaxAlive: 1, MonsterType: "slime"}}
+	if err := s.Load(ctx); err == nil {
+		t.Fatalf("expected error for out of field spawn point")
+	}
+}
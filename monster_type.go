@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"sort"
+
+	"github.com/metal-tile/land/dqn"
+	"github.com/metal-tile/land/firedb"
+	"gopkg.in/yaml.v2"
+)
+
+// PolicyDQN is MonsterType.Policyで、DQNで行動を決めることを表す名前
+const PolicyDQN = "dqn"
+
+// MonsterTypeCatalog is MonsterTypeのIDからMonsterTypeを引くための一覧
+// 起動時に読み込んだ後は変更しないので、Lockは取らない
+// nilの場合は空の一覧として扱う
+type MonsterTypeCatalog struct {
+	types     map[string]*firedb.MonsterType
+	policies  map[string]dqn.Client
+	selectors map[string]dqn.Selector
+}
+
+// NewMonsterTypeCatalog is MonsterTypeの一覧からCatalogを生成する
+// Policyに指定されたPolicyClientは、ここで生成して同じ名前のMonsterTypeで共有する
+func NewMonsterTypeCatalog(l []*firedb.MonsterType) (*MonsterTypeCatalog, error) {
+	c := &MonsterTypeCatalog{
+		types:     make(map[string]*firedb.MonsterType),
+		policies:  make(map[string]dqn.Client),
+		selectors: make(map[string]dqn.Selector),
+	}
+	maxSenseRange := dqn.SenseRangeRow / 2
+	if dqn.SenseRangeCol/2 < maxSenseRange {
+		maxSenseRange = dqn.SenseRangeCol / 2
+	}
+	for _, t := range l {
+		if err := t.Validate(); err != nil {
+			return nil, err
+		}
+		if _, ok := c.types[t.ID]; ok {
+			return nil, fmt.Errorf("monster type is duplicated. id = %s", t.ID)
+		}
+		if t.SenseRange > maxSenseRange {
+			return nil, fmt.Errorf("monster type %s senseRange must be less than or equal to %d. senseRange = %d", t.ID, maxSenseRange, t.SenseRange)
+		}
+		if t.Policy != "" && t.Policy != PolicyDQN {
+			if _, ok := c.policies[t.Policy]; !ok {
+				p, err := dqn.NewPolicyClient(t.Policy)
+				if err != nil {
+					return nil, fmt.Errorf("monster type %s has unknown policy. policy = %s", t.ID, t.Policy)
+				}
+				c.policies[t.Policy] = p
+			}
+		}
+		if t.Selector != "" {
+			s, err := dqn.ParseSelector(t.Selector)
+			if err != nil {
+				return nil, fmt.Errorf("monster type %s has invalid selector. %s", t.ID, err)
+			}
+			c.selectors[t.ID] = s
+		}
+		v := *t
+		c.types[t.ID] = &v
+	}
+	return c, nil
+}
+
+// Get is 指定したIDのMonsterTypeのCopyを返す
+func (c *MonsterTypeCatalog) Get(id string) (*firedb.MonsterType, bool) {
+	if c == nil {
+		return nil, false
+	}
+	t, ok := c.types[id]
+	if !ok {
+		return nil, false
+	}
+	v := *t
+	return &v, true
+}
+
+// Len is 登録されているMonsterTypeの数を返す
+func (c *MonsterTypeCatalog) Len() int {
+	if c == nil {
+		return 0
+	}
+	return len(c.types)
+}
+
+// Types is 全てのMonsterTypeのCopyをID順に並べて返す
+func (c *MonsterTypeCatalog) Types() []*firedb.MonsterType {
+	if c == nil {
+		return nil
+	}
+	l := make([]*firedb.MonsterType, 0, len(c.types))
+	for _, t := range c.types {
+		v := *t
+		l = append(l, &v)
+	}
+	sort.Slice(l, func(i, j int) bool {
+		return l[i].ID < l[j].ID
+	})
+	return l
+}
+
+// Policy is MonsterTypeのPolicyで行動を決めるClientを返す
+// DQNで行動を決めるMonsterTypeの場合はfalseを返す
+func (c *MonsterTypeCatalog) Policy(id string) (string, dqn.Client, bool) {
+	if c == nil {
+		return PolicyDQN, nil, false
+	}
+	t, ok := c.types[id]
+	if !ok || t.Policy == "" || t.Policy == PolicyDQN {
+		return PolicyDQN, nil, false
+	}
+	return t.Policy, c.policies[t.Policy], true
+}
+
+// Selector is MonsterTypeのQ Scoreから行動を選ぶ戦略を返す
+// 指定されていない場合はnilを返し、DQN Clientの設定を使う
+func (c *MonsterTypeCatalog) Selector(id string) dqn.Selector {
+	if c == nil {
+		return nil
+	}
+	return c.selectors[id]
+}
+
+// LoadMonsterTypeCatalog is MonsterTypeの定義を読み込んでCatalogを生成する
+// fileが指定されている場合はYAML Fileから、指定されていない場合はstoreを通してFirestoreから読み込む
+func LoadMonsterTypeCatalog(ctx context.Context, store firedb.MonsterTypeStore, file string) (*MonsterTypeCatalog, error) {
+	if file != "" {
+		l, err := ReadMonsterTypeFile(file)
+		if err != nil {
+			return nil, err
+		}
+		return NewMonsterTypeCatalog(l)
+	}
+	l, err := store.ListTypes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return NewMonsterTypeCatalog(l)
+}
+
+// ReadMonsterTypeFile is MonsterTypeのYAML Arrayのファイルを読み込む
+func ReadMonsterTypeFile(path string) ([]*firedb.MonsterType, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var l []*firedb.MonsterType
+	if err := yaml.UnmarshalStrict(b, &l); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/metal-tile/land/dqn"
+	"github.com/metal-tile/land/firedb"
+	"github.com/sinmetal/slog"
+	"github.com/sinmetal/stime"
+)
+
+func TestLoadMonsterTypeCatalog(t *testing.T) {
+	ctx := context.Background()
+
+	c, err := LoadMonsterTypeCatalog(ctx, &DummyMonsterTypeStore{}, "testdata/monster-types.yaml")
+	if err != nil {
+		t.Fatalf("failed LoadMonsterTypeCatalog. err=%+v", err)
+	}
+	if e, g := 3, c.Len(); e != g {
+		t.Fatalf("expected Len is %d; got %d", e, g)
+	}
+	slime, ok := c.Get("slime")
+	if !ok {
+		t.Fatalf("slime is not found")
+	}
+	expected := firedb.MonsterType{ID: "slime", HitPoint: 10, Attack: 1, Speed: 2, SenseRange: 2, Policy: "chase", SpriteID: "slime-green"}
+	if e, g := expected, *slime; e != g {
+		t.Fatalf("expected %+v; got %+v", e, g)
+	}
+
+	candidates := []struct {
+		id     string
+		policy string
+		ok     bool
+	}{
+		{id: "slime", policy: "chase", ok: true},
+		{id: "bat", policy: "wander", ok: true},
+		{id: "knight", policy: PolicyDQN, ok: false},
+		{id: "unknown", policy: PolicyDQN, ok: false},
+	}
+	for i, v := range candidates {
+		name, client, ok := c.Policy(v.id)
+		if e, g := v.policy, name; e != g {
+			t.Fatalf("%d : expected policy %s; got %s", i, e, g)
+		}
+		if e, g := v.ok, ok; e != g {
+			t.Fatalf("%d : expected ok %t; got %t", i, e, g)
+		}
+		if ok && client == nil {
+			t.Fatalf("%d : expected policy client", i)
+		}
+	}
+
+	if _, ok := c.Selector("knight").(*dqn.EpsilonGreedySelector); !ok {
+		t.Fatalf("expected knight selector is EpsilonGreedySelector; got %T", c.Selector("knight"))
+	}
+	if s := c.Selector("slime"); s != nil {
+		t.Fatalf("expected slime selector is nil; got %T", s)
+	}
+
+	// Fileを指定しない場合はStoreから読み込む
+	store := &DummyMonsterTypeStore{Types: []*firedb.MonsterType{{ID: "slime"}}}
+	c, err = LoadMonsterTypeCatalog(ctx, store, "")
+	if err != nil {
+		t.Fatalf("failed LoadMonsterTypeCatalog. err=%+v", err)
+	}
+	if e, g := 1, c.Len(); e != g {
+		t.Fatalf("expected Len is %d; got %d", e, g)
+	}
+}
+
+func TestNewMonsterTypeCatalog_Invalid(t *testing.T) {
+	candidates := [][]*firedb.MonsterType{
+		{{ID: ""}},
+		{{ID: "slime"}, {ID: "slime"}},
+		{{ID: "slime", Speed: -1}},
+		{{ID: "slime", SenseRange: dqn.SenseRangeRow}},
+		{{ID: "slime", Policy: "unknown"}},
+		{{ID: "slime", Selector: "epsilon:2"}},
+	}
+	for i, v := range candidates {
+		if _, err := NewMonsterTypeCatalog(v); err == nil {
+			t.Fatalf("%d : expected error", i)
+		}
+	}
+}
+
+func TestMonsterTypeCatalog_Nil(t *testing.T) {
+	var c *MonsterTypeCatalog
+	if _, ok := c.Get("slime"); ok {
+		t.Fatalf("expected not found")
+	}
+	if e, g := 0, c.Len(); e != g {
+		t.Fatalf("expected Len is %d; got %d", e, g)
+	}
+	if _, _, ok := c.Policy("slime"); ok {
+		t.Fatalf("expected dqn policy")
+	}
+}
+
+func TestHandleMonsters_MonsterType(t *testing.T) {
+	dqnDummy := &DQNDummyClient{
+		DummyAnswer: &dqn.Answer{
+			X:      1,
+			Y:      0,
+			IsMove: true,
+			Angle:  dqn.AngleRight,
+			Speed:  4,
+		},
+	}
+	types, err := NewMonsterTypeCatalog([]*firedb.MonsterType{
+		{ID: "slime", Speed: 2, Policy: "chase"},
+		{ID: "knight", Speed: 8},
+	})
+	if err != nil {
+		t.Fatalf("failed NewMonsterTypeCatalog. err=%+v", err)
+	}
+
+	msDummy := &DummyMonsterStore{}
+	client := &MonsterClient{
+		DQN:          dqnDummy,
+		MonsterStore: msDummy,
+		Types:        types,
+		PlayerStore: &DummyPlayerStore{
+			PlayerMap: map[string]*firedb.User{
+				"sinmetal": &firedb.User{Active: true},
+			},
+			PositionMap: map[string]*firedb.PlayerPosition{
+				// Monsterの右側にいる
+				"sinmetal": &firedb.PlayerPosition{
+					ID:                "sinmetal",
+					X:                 1000,
+					Y:                 1000,
+					FirestoreUpdateAt: stime.Now(),
+				},
+			},
+		},
+		Monsters: NewMonsterRegistry(),
+	}
+	mobs := []*firedb.MonsterPosition{
+		{ID: "slime", X: 950, Y: 1000, Speed: 4, Type: "slime"},
+		{ID: "knight", X: 950, Y: 1000, Speed: 4, Type: "knight"},
+		{ID: "unknown", X: 950, Y: 1000, Speed: 4, Type: "unknown"},
+	}
+	for _, mob := range mobs {
+		if err := client.Monsters.Add(mob); err != nil {
+			t.Fatalf("failed MonsterRegistry.Add. err=%+v", err)
+		}
+	}
+
+	ctx := slog.WithLog(context.Background())
+	if err := handleMonsters(ctx, client); err != nil {
+		t.Fatalf("failed handleMonsters. err=%+v", err)
+	}
+
+	// chaseのslimeはDQNには送らない
+	if e, g := 1, dqnDummy.BatchPredictionCount; e != g {
+		t.Fatalf("expected DQN.BatchPredictionCount is %d; got %d", e, g)
+	}
+	if e, g := 2, len(dqnDummy.Body.Instances); e != g {
+		t.Fatalf("expected len(Instances) is %d; got %d", e, g)
+	}
+
+	candidates := []struct {
+		id    string
+		x     float64
+		speed float64
+	}{
+		{id: "slime", x: 952, speed: 2},
+		{id: "knight", x: 958, speed: 8},
+		{id: "unknown", x: 954, speed: 4},
+	}
+	for _, v := range candidates {
+		mob, ok := client.Monsters.Get(v.id)
+		if !ok {
+			t.Fatalf("%s is not found", v.id)
+		}
+		if e, g := v.x, mob.X; e != g {
+			t.Fatalf("expected %s X is %f; got %f", v.id, e, g)
+		}
+		if e, g := v.speed, mob.Speed; e != g {
+			t.Fatalf("expected %s Speed is %f; got %f", v.id, e, g)
+		}
+	}
+}
+
+func TestBuildDQNInstance_SenseRange(t *testing.T) {
+	types, err := NewMonsterTypeCatalog([]*firedb.MonsterType{
+		{ID: "slime", SenseRange: 1},
+	})
+	if err != nil {
+		t.Fatalf("failed NewMonsterTypeCatalog. err=%+v", err)
+	}
+
+	// Monsterは row=31, col=29 にいて、プレイヤーは3Tile右にいる
+	client := &MonsterClient{
+		Types: types,
+		PlayerStore: &DummyPlayerStore{
+			PositionMap: map[string]*firedb.PlayerPosition{
+				"sinmetal": &firedb.PlayerPosition{ID: "sinmetal", X: 32 * 32, Y: 31 * 32, FirestoreUpdateAt: stime.Now()},
+			},
+		},
+	}
+	ctx := slog.WithLog(context.Background())
+
+	candidates := []struct {
+		mob    *firedb.MonsterPosition
+		sensed bool
+	}{
+		{mob: &firedb.MonsterPosition{ID: "slime", X: 950, Y: 1000, Type: "slime"}, sensed: false},
+		{mob: &firedb.MonsterPosition{ID: "knight", X: 950, Y: 1000}, sensed: true},
+	}
+	for i, v := range candidates {
+		instance, err := client.BuildDQNInstance(ctx, v.mob)
+		if err != nil {
+			t.Fatalf("%d : failed BuildDQNInstance. err=%+v", i, err)
+		}
+		sensed := instance.State[dqn.SenseRangeRow/2][dqn.SenseRangeCol/2+3][dqn.PlayerLayer] > 0
+		if e, g := v.sensed, sensed; e != g {
+			t.Fatalf("%d : expected sensed %t; got %t", i, e, g)
+		}
+	}
+}
+
+func TestBuildDQNInstance_Selector(t *testing.T) {
+	types, err := NewMonsterTypeCatalog([]*firedb.MonsterType{
+		{ID: "knight", Selector: "softmax:0.5"},
+		{ID: "slime"},
+	})
+	if err != nil {
+		t.Fatalf("failed NewMonsterTypeCatalog. err=%+v", err)
+	}
+	client := &MonsterClient{Types: types}
+	ctx := slog.WithLog(context.Background())
+
+	instance, err := client.BuildDQNInstance(ctx, &firedb.MonsterPosition{ID: "knight", X: 950, Y: 1000, Type: "knight"})
+	if err != nil {
+		t.Fatalf("failed BuildDQNInstance. err=%+v", err)
+	}
+	if _, ok := instance.Selector.(*dqn.SoftmaxSelector); !ok {
+		t.Fatalf("expected SoftmaxSelector; got %T", instance.Selector)
+	}
+
+	// MonsterTypeにSelectorが無い場合は、DQN Clientの設定を使う
+	instance, err = client.BuildDQNInstance(ctx, &firedb.MonsterPosition{ID: "slime", X: 950, Y: 1000, Type: "slime"})
+	if err != nil {
+		t.Fatalf("failed BuildDQNInstance. err=%+v", err)
+	}
+	if instance.Selector != nil {
+		t.Fatalf("expected nil selector; got %T", instance.Selector)
+	}
+}
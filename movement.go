@@ -0,0 +1,65 @@
+package main
+
+import (
+	"math"
+
+	"github.com/metal-tile/land/firedb"
+	"github.com/pkg/errors"
+)
+
+// MovementResolver is Monsterの移動先がMapの中かつ通行可能かを確認して、実際の移動先を決める
+// Monsterは左上のXY座標に置いた、Chip1つと同じ大きさの矩形として扱う
+// FieldStoreがnilの場合は、MapSizeRow x MapSizeCol のMapの範囲だけを確認する
+type MovementResolver struct {
+	FieldStore  firedb.FieldStore
+	Passability firedb.ChipPassability
+}
+
+// Resolve is (x, y) から (dx, dy) 動こうとした時の移動先を返す
+// DQNもPolicyもX軸かY軸の片方にしか動かないので、進めない場合は滑らせずにその場に留まる
+func (r *MovementResolver) Resolve(x float64, y float64, dx float64, dy float64) (nx float64, ny float64, moved bool, err error) {
+	if dx == 0 && dy == 0 {
+		return x, y, false, nil
+	}
+
+	ok, err := r.IsPassable(x+dx, y+dy)
+	if err != nil {
+		return x, y, false, err
+	}
+	if !ok {
+		return x, y, false, nil
+	}
+	return x + dx, y + dy, true, nil
+}
+
+// IsPassable is 指定したXY座標を左上にしてMonsterを置いた時に、重なる全てのChipがMapの中で、通行可能かどうかを返す
+func (r *MovementResolver) IsPassable(x float64, y float64) (bool, error) {
+	// マイナスの座標はConvertXYToRowColで0に切り捨てられてしまうので、先に確認する
+	if x < 0 || y < 0 {
+		return false, nil
+	}
+	top, left := ConvertXYToRowCol(x, y, 1.0)
+	// 右端と下端はMonsterの外なので含めない。Chipにぴったり揃っている場合は、そのChipだけに重なる
+	bottom := int(math.Ceil((y+firedb.MapChipHeight)/firedb.MapChipHeight)) - 1
+	right := int(math.Ceil((x+firedb.MapChipWidth)/firedb.MapChipWidth)) - 1
+	rows, cols := FieldSize(r.FieldStore)
+	if bottom >= rows || right >= cols {
+		return false, nil
+	}
+	if r.FieldStore == nil {
+		return true, nil
+	}
+
+	for row := top; row <= bottom; row++ {
+		for col := left; col <= right; col++ {
+			v, err := r.FieldStore.GetValue(row, col)
+			if err != nil {
+				return false, errors.Wrapf(err, "failed FieldStore.GetValue. row=%d,col=%d", row, col)
+			}
+			if r.Passability.IsObstacle(v) {
+				return false, nil
+			}
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/metal-tile/land/firedb"
+)
+
+func TestMovementResolver_Resolve(t *testing.T) {
+	fs := &DummyFieldStore{}
+	// 壁
+	fs.SetValue(31, 31, &firedb.FieldValue{Row: 31, Col: 31, ChipID: 2})
+	fs.SetValue(33, 29, &firedb.FieldValue{Row: 33, Col: 29, ChipID: 2})
+
+	r := &MovementResolver{
+		FieldStore:  fs,
+		Passability: firedb.ChipPassability{2: false},
+	}
+
+	const right = firedb.MapSizeCol*firedb.MapChipWidth - firedb.MapChipWidth
+	const bottom = firedb.MapSizeRow*firedb.MapChipHeight - firedb.MapChipHeight
+	candidates := []struct {
+		name  string
+		x     float64
+		y     float64
+		dx    float64
+		dy    float64
+		ex    float64
+		ey    float64
+		moved bool
+	}{
+		{name: "no move", x: 956, y: 1000, dx: 0, dy: 0, ex: 956, ey: 1000, moved: false},
+		{name: "move left", x: 956, y: 1000, dx: -4, dy: 0, ex: 952, ey: 1000, moved: true},
+		// 左上のChip(row=31,col=30)は通れるが、右側がrow=31,col=31の壁に重なる
+		{name: "blocked by wall on the right", x: 956, y: 1000, dx: 8, dy: 0, ex: 956, ey: 1000, moved: false},
+		// 左上のChip(row=32,col=29)は通れるが、下側がrow=33,col=29の壁に重なる
+		{name: "blocked by wall below", x: 928, y: 1024, dx: 0, dy: 4, ex: 928, ey: 1024, moved: false},
+		{name: "aligned next to wall", x: 956, y: 1000, dx: 0, dy: 4, ex: 956, ey: 1004, moved: true},
+		{name: "map left edge", x: 2, y: 1000, dx: -4, dy: 0, ex: 2, ey: 1000, moved: false},
+		{name: "map top edge", x: 950, y: 2, dx: 0, dy: -4, ex: 950, ey: 2, moved: false},
+		{name: "reach map right edge", x: right - 4, y: 1000, dx: 4, dy: 0, ex: right, ey: 1000, moved: true},
+		{name: "map right edge", x: right - 2, y: 1000, dx: 4, dy: 0, ex: right - 2, ey: 1000, moved: false},
+		{name: "map bottom edge", x: 950, y: bottom - 2, dx: 0, dy: 4, ex: 950, ey: bottom - 2, moved: false},
+	}
+
+	for _, v := range candidates {
+		x, y, moved, err := r.Resolve(v.x, v.y, v.dx, v.dy)
+		if err != nil {
+			t.Fatalf("%s : failed Resolve. err=%+v", v.name, err)
+		}
+		if e, g := v.ex, x; e != g {
+			t.Fatalf("%s : expected x is %f; got %f", v.name, e, g)
+		}
+		if e, g := v.ey, y; e != g {
+			t.Fatalf("%s : expected y is %f; got %f", v.name, e, g)
+		}
+		if e, g := v.moved, moved; e != g {
+			t.Fatalf("%s : expected moved is %t; got %t", v.name, e, g)
+		}
+	}
+}
+
+func TestMovementResolver_IsPassableWithFieldSize(t *testing.T) {
+	r := &MovementResolver{
+		FieldStore: &DummyFieldStore{Rows: 30, Cols: 40},
+	}
+
+	candidates := []struct {
+		name string
+		x    float64
+		y    float64
+		ok   bool
+	}{
+		{name: "inside", x: 39 * firedb.MapChipWidth, y: 29 * firedb.MapChipHeight, ok: true},
+		{name: "overlap right edge", x: 39*firedb.MapChipWidth + 1, y: 0, ok: false},
+		{name: "right edge", x: 40 * firedb.MapChipWidth, y: 0, ok: false},
+		{name: "bottom edge", x: 0, y: 30 * firedb.MapChipHeight, ok: false},
+		{name: "negative", x: -1, y: 0, ok: false},
+	}
+
+	for _, v := range candidates {
+		ok, err := r.IsPassable(v.x, v.y)
+		if err != nil {
+			t.Fatalf("%s : failed IsPassable. err=%+v", v.name, err)
+		}
+		if e, g := v.ok, ok; e != g {
+			t.Fatalf("%s : expected %t; got %t", v.name, e, g)
+		}
+	}
+}
@@ -2,37 +2,29 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	"github.com/metal-tile/land/firedb"
-	"github.com/sinmetal/stime"
+	"github.com/sinmetal/slog"
 )
 
-// WatchPassivePlayer is まったく動いていないプレイヤーを探して、パッシブ状態にDBを変更する
-func WatchPassivePlayer() error {
+// WatchPlayerPresence is 定期的にプレイヤーの在席状態を進めて、変わったものをDBに書き込む
+// ctxがcancelされると終了する
+func WatchPlayerPresence(ctx context.Context) error {
 	ps := firedb.NewPlayerStore()
+	t := time.NewTicker(10 * time.Second)
+	defer t.Stop()
 	for {
-		t := time.NewTicker(60 * time.Second)
-		for {
-			select {
-			case <-t.C:
-				ctx := context.Background()
-				pm := ps.GetPlayerMapSnapshot()
-				for k, v := range pm {
-					if v.Active == false {
-						continue
-					}
-					if IsPlayerPassive(v) {
-						ps.SetPassiveUser(ctx, k)
-					}
-				}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+			if _, err := ps.CheckPresence(ctx); err != nil {
+				lctx := slog.WithLog(context.Background())
+				slog.Warning(lctx, "FailedCheckPresence", fmt.Sprintf("%+v", err))
+				slog.Flush(lctx)
 			}
 		}
 	}
 }
-
-// IsPlayerPassive is プレイヤーがパッシブかどうかの判定
-func IsPlayerPassive(user *firedb.User) bool {
-	t := user.UpdatedAt.Add(time.Minute * 15)
-	return t.Before(stime.Now())
-}
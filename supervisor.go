@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sinmetal/slog"
+	"github.com/tenntenn/sync/recoverable"
+)
+
+const (
+	// DefaultMinBackoff is Subsystemを再起動するまでの最初の待ち時間
+	DefaultMinBackoff = 1 * time.Second
+
+	// DefaultMaxBackoff is Subsystemを再起動するまでの最大の待ち時間
+	DefaultMaxBackoff = 1 * time.Minute
+)
+
+// subsystem is Supervisorが管理する処理
+type subsystem struct {
+	name string
+	run  func(ctx context.Context) error
+}
+
+// Supervisor is Watcherや Monster Controlなどの常駐する処理を管理する
+// それぞれの処理にはcancel可能なctxを渡し、失敗したり終了した場合はBackoffしながら再起動する
+type Supervisor struct {
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	subsystems []subsystem
+}
+
+// NewSupervisor is Supervisorを生成する
+func NewSupervisor() *Supervisor {
+	return &Supervisor{
+		MinBackoff: DefaultMinBackoff,
+		MaxBackoff: DefaultMaxBackoff,
+	}
+}
+
+// Add is Supervisorに処理を追加する
+// runはctxがcancelされたら速やかに終了する必要がある
+func (s *Supervisor) Add(name string, run func(ctx context.Context) error) {
+	s.subsystems = append(s.subsystems, subsystem{name: name, run: run})
+}
+
+// Run is 全ての処理を起動して、ctxがcancelされて全ての処理が終了するまで待つ
+func (s *Supervisor) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, ss := range s.subsystems {
+		wg.Add(1)
+		go func(ss subsystem) {
+			defer wg.Done()
+			s.supervise(ctx, ss)
+		}(ss)
+	}
+	wg.Wait()
+}
+
+// supervise is 1つの処理をctxがcancelされるまで動かし続ける
+// MaxBackoff以上動き続けた後に失敗した場合は、Backoffを最初からやり直す
+func (s *Supervisor) supervise(ctx context.Context, ss subsystem) {
+	backoff := s.MinBackoff
+	for {
+		start := time.Now()
+		err := runRecoverable(ctx, ss.run)
+		if ctx.Err() != nil {
+			fmt.Printf("%s is stopped.\n", ss.name)
+			return
+		}
+
+		if time.Since(start) > s.MaxBackoff {
+			backoff = s.MinBackoff
+		}
+		logSubsystemFailure(ss.name, err, backoff)
+
+		select {
+		case <-ctx.Done():
+			fmt.Printf("%s is stopped.\n", ss.name)
+			return
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > s.MaxBackoff {
+			backoff = s.MaxBackoff
+		}
+	}
+}
+
+// runRecoverable is runがpanicした場合もerrorとして返す
+func runRecoverable(ctx context.Context, run func(ctx context.Context) error) error {
+	var err error
+	f := recoverable.Func(func() {
+		err = run(ctx)
+	})
+	if rerr := f(); rerr != nil {
+		v, _ := recoverable.RecoveredValue(rerr)
+		return fmt.Errorf("recovered %+v", v)
+	}
+	return err
+}
+
+func logSubsystemFailure(name string, err error, backoff time.Duration) {
+	ctx := slog.WithLog(context.Background())
+	defer slog.Flush(ctx)
+
+	slog.Error(ctx, "SubsystemFailed", fmt.Sprintf("%s is failed. restart after %s. %+v", name, backoff, err))
+}
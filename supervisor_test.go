@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSupervisor_Restart(t *testing.T) {
+	sv := NewSupervisor()
+	sv.MinBackoff = time.Millisecond
+	sv.MaxBackoff = 5 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var mu sync.Mutex
+	counts := make(map[string]int)
+	count := func(name string) int {
+		mu.Lock()
+		defer mu.Unlock()
+		counts[name]++
+		return counts[name]
+	}
+
+	sv.Add("error", func(ctx context.Context) error {
+		count("error")
+		return errors.New("watcher error")
+	})
+	sv.Add("panic", func(ctx context.Context) error {
+		count("panic")
+		panic("watcher panic")
+	})
+	sv.Add("long", func(ctx context.Context) error {
+		// 3回目に起動したら、cancelされるまで動き続ける
+		if count("long") < 3 {
+			return errors.New("watcher error")
+		}
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	done := make(chan struct{})
+	go func() {
+		sv.Run(ctx)
+		close(done)
+	}()
+
+	deadline := time.After(5 * time.Second)
+	for {
+		mu.Lock()
+		ok := counts["error"] >= 3 && counts["panic"] >= 3 && counts["long"] >= 3
+		mu.Unlock()
+		if ok {
+			break
+		}
+		select {
+		case <-deadline:
+			t.Fatalf("subsystems are not restarted. counts = %+v", counts)
+		case <-time.After(time.Millisecond):
+		}
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("supervisor is not stopped after cancel")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if e, g := 3, counts["long"]; e != g {
+		t.Fatalf("expected long subsystem started %d times; got %d", e, g)
+	}
+}
+
+func TestRunControlMonster_Cancel(t *testing.T) {
+	client := &MonsterClient{
+		PlayerStore: &DummyPlayerStore{},
+		Monsters:    NewMonsterRegistry(),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- RunControlMonster(ctx, client)
+	}()
+
+	time.Sleep(150 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Fatalf("expected err is %+v; got %+v", context.Canceled, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("RunControlMonster is not stopped after cancel")
+	}
+}